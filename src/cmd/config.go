@@ -0,0 +1,404 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/opslevel/opslevel-runner/pkg"
+	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
+	"github.com/spf13/viper"
+	corev1 "k8s.io/api/core/v1"
+)
+
+// configSections are the top level keys of the config file that are not backed by a flag
+var configSections = []string{"kubernetes", "logPipeline", "redaction"}
+
+// podConfigFlags maps the kubernetes config keys to the flags that provide their defaults
+var podConfigFlags = map[string]string{
+	"namespace":                            "job-pod-namespace",
+	"lifetime":                             "job-pod-max-lifetime",
+	"shell":                                "job-pod-shell",
+	"workingDir":                           "job-pod-workdir",
+	"agentMode":                            "job-agent-mode",
+	"helperImage":                          "job-pod-helper-image",
+	"execMode":                             "job-pod-exec-mode",
+	"execMaxReconnects":                    "job-pod-exec-max-reconnects",
+	"execTransport":                        "job-pod-exec-transport",
+	"usageSampleInterval":                  "job-pod-usage-sample-interval",
+	"debugHold":                            "job-pod-debug-hold",
+	"debugHoldDuration":                    "job-pod-debug-hold-duration",
+	"cancelGracePeriod":                    "job-pod-cancel-grace-period",
+	"resources.requests.cpu":               "job-pod-requests-cpu",
+	"resources.requests.memory":            "job-pod-requests-memory",
+	"resources.requests.ephemeral-storage": "job-pod-requests-ephemeral-storage",
+	"resources.limits.cpu":                 "job-pod-limits-cpu",
+	"resources.limits.memory":              "job-pod-limits-memory",
+	"resources.limits.ephemeral-storage":   "job-pod-limits-ephemeral-storage",
+}
+
+type ConfigValue struct {
+	Key    string
+	Value  string
+	Source string
+}
+
+var configCmd = &cobra.Command{
+	Use:   "config",
+	Short: "Inspect the runner configuration",
+	Long:  `Inspect the runner configuration`,
+}
+
+var configValidateCmd = &cobra.Command{
+	Use:          "validate",
+	Short:        "Validate the runner configuration and print the effective values",
+	Long:         `Load the configuration exactly as 'run' would, report unknown keys, type errors and invalid kubernetes settings, then print the effective values`,
+	Annotations:  map[string]string{skipK8SClientAnnotation: "true"},
+	SilenceUsage: true,
+	RunE:         runConfigValidate,
+}
+
+var configShowCmd = &cobra.Command{
+	Use:         "show",
+	Short:       "Print the effective runner configuration",
+	Long:        `Print the effective runner configuration with the source of each value`,
+	Annotations: map[string]string{skipK8SClientAnnotation: "true"},
+	RunE:        runConfigShow,
+}
+
+func init() {
+	configCmd.AddCommand(configValidateCmd)
+	configCmd.AddCommand(configShowCmd)
+	rootCmd.AddCommand(configCmd)
+}
+
+func runConfigValidate(cmd *cobra.Command, args []string) error {
+	podConfig, problems := pkg.ValidatePodConfig(cfgFile)
+	problems = append(validateConfigKeys(), problems...)
+	data, err := readConfigData()
+	if err != nil {
+		return err
+	}
+	pipeline, pipelineProblems := pkg.ValidateLogPipelineConfig(data)
+	problems = append(problems, pipelineProblems...)
+	redaction, redactionProblems := pkg.ValidateRedactionConfig(data)
+	problems = append(problems, redactionProblems...)
+	if podConfig != nil {
+		values := getEffectiveConfig(podConfig)
+		if pipeline != nil {
+			values = append(values, getLogPipelineValues(pipeline)...)
+		}
+		if redaction != nil {
+			values = append(values, getRedactionValues(redaction)...)
+		}
+		printConfig(values)
+	}
+	if len(problems) == 0 {
+		fmt.Println("\nConfiguration is valid")
+		return nil
+	}
+	fmt.Fprintf(os.Stderr, "\nFound %d problem(s):\n", len(problems))
+	for _, problem := range problems {
+		fmt.Fprintf(os.Stderr, "  - %s\n", problem)
+	}
+	return fmt.Errorf("configuration is invalid")
+}
+
+func runConfigShow(cmd *cobra.Command, args []string) error {
+	podConfig, err := pkg.ReadPodConfig(cfgFile)
+	if err != nil {
+		return err
+	}
+	data, err := readConfigData()
+	if err != nil {
+		return err
+	}
+	pipeline, err := pkg.ParseLogPipelineConfig(data)
+	if err != nil {
+		return err
+	}
+	redaction, err := pkg.ParseRedactionConfig(data)
+	if err != nil {
+		return err
+	}
+	values := append(getEffectiveConfig(podConfig), getLogPipelineValues(pipeline)...)
+	printConfig(append(values, getRedactionValues(redaction)...))
+	return nil
+}
+
+// configFlags returns the flags that make up the runner configuration sorted by name
+func configFlags() []*pflag.Flag {
+	var flags []*pflag.Flag
+	visit := func(flag *pflag.Flag) {
+		if flag.Name != "config" {
+			flags = append(flags, flag)
+		}
+	}
+	rootCmd.PersistentFlags().VisitAll(visit)
+	runCmd.Flags().VisitAll(visit)
+	sort.Slice(flags, func(i, j int) bool { return flags[i].Name < flags[j].Name })
+	return flags
+}
+
+// getConfigSource mirrors viper's precedence of flag > env > file > default
+func getConfigSource(flag *pflag.Flag) string {
+	if flag.Changed {
+		return "flag"
+	}
+	envs := append([]string{}, envBindings[flag.Name]...)
+	envs = append(envs, fmt.Sprintf("OPSLEVEL_%s", strings.ToUpper(flag.Name)))
+	for _, env := range envs {
+		if _, present := os.LookupEnv(env); present {
+			return fmt.Sprintf("env (%s)", env)
+		}
+	}
+	if viper.InConfig(flag.Name) {
+		return "file"
+	}
+	return "default"
+}
+
+func isSensitiveConfigKey(key string) bool {
+	key = strings.ToLower(key)
+	for _, word := range []string{"token", "password", "secret", "dsn"} {
+		if strings.Contains(key, word) {
+			return true
+		}
+	}
+	return false
+}
+
+func formatConfigValue(key string, value any) string {
+	var output string
+	switch casted := value.(type) {
+	case string:
+		output = casted
+	case nil:
+		output = ""
+	default:
+		data, err := json.Marshal(casted)
+		if err != nil {
+			output = fmt.Sprintf("%v", casted)
+		} else if string(data) != "null" {
+			output = string(data)
+		}
+	}
+	if output != "" && isSensitiveConfigKey(key) {
+		return "**********"
+	}
+	return output
+}
+
+func getEffectiveConfig(podConfig *pkg.K8SPodConfig) []ConfigValue {
+	var output []ConfigValue
+	sources := map[string]string{}
+	for _, flag := range configFlags() {
+		source := getConfigSource(flag)
+		sources[flag.Name] = source
+		output = append(output, ConfigValue{
+			Key:    flag.Name,
+			Value:  formatConfigValue(flag.Name, viper.Get(flag.Name)),
+			Source: source,
+		})
+	}
+	for _, value := range getPodConfigValues(podConfig) {
+		switch {
+		case viper.InConfig(fmt.Sprintf("kubernetes.%s", value.Key)):
+			value.Source = "file"
+		case podConfigFlags[value.Key] != "":
+			value.Source = fmt.Sprintf("%s via '%s'", sources[podConfigFlags[value.Key]], podConfigFlags[value.Key])
+		default:
+			value.Source = "default"
+		}
+		value.Key = fmt.Sprintf("kubernetes.%s", value.Key)
+		output = append(output, value)
+	}
+	return output
+}
+
+// getPodConfigValues flattens the kubernetes config into one value per config key
+func getPodConfigValues(podConfig *pkg.K8SPodConfig) []ConfigValue {
+	output := []ConfigValue{
+		{Key: "namespace", Value: podConfig.Namespace},
+		{Key: "lifetime", Value: strconv.Itoa(podConfig.Lifetime)},
+		{Key: "shell", Value: podConfig.Shell},
+		{Key: "workingDir", Value: podConfig.WorkingDir},
+		{Key: "annotations", Value: formatConfigValue("annotations", podConfig.Annotations)},
+		{Key: "serviceAccountName", Value: podConfig.ServiceAccountName},
+		{Key: "terminationGracePeriodSeconds", Value: strconv.FormatInt(podConfig.TerminationGracePeriodSeconds, 10)},
+		{Key: "dnsPolicy", Value: string(podConfig.DNSPolicy)},
+		{Key: "pullPolicy", Value: string(podConfig.PullPolicy)},
+		{Key: "securityContext", Value: formatConfigValue("securityContext", podConfig.SecurityContext)},
+		{Key: "nodeSelector", Value: formatConfigValue("nodeSelector", podConfig.NodeSelector)},
+		{Key: "agentMode", Value: strconv.FormatBool(podConfig.AgentMode)},
+		{Key: "helperImage", Value: podConfig.HelperImage},
+		{Key: "execMode", Value: podConfig.ExecMode},
+		{Key: "execMaxReconnects", Value: strconv.Itoa(podConfig.ExecMaxReconnects)},
+		{Key: "execTransport", Value: podConfig.ExecTransport},
+		{Key: "usageSampleInterval", Value: strconv.Itoa(podConfig.UsageSampleInterval)},
+		{Key: "debugHold", Value: strconv.FormatBool(podConfig.DebugHold)},
+		{Key: "debugHoldDuration", Value: strconv.Itoa(podConfig.DebugHoldDuration)},
+		{Key: "cancelGracePeriod", Value: strconv.Itoa(podConfig.CancelGracePeriod)},
+		{Key: "job.enabled", Value: strconv.FormatBool(podConfig.Job.Enabled)},
+		{Key: "job.ttlSecondsAfterFinished", Value: formatConfigValue("ttlSecondsAfterFinished", podConfig.Job.TTLSecondsAfterFinished)},
+		{Key: "job.labels", Value: formatConfigValue("labels", podConfig.Job.Labels)},
+		{Key: "job.annotations", Value: formatConfigValue("annotations", podConfig.Job.Annotations)},
+		{Key: "job.podFailurePolicy", Value: formatConfigValue("podFailurePolicy", podConfig.Job.PodFailurePolicy)},
+		{Key: "job.admissionTimeout", Value: strconv.Itoa(podConfig.Job.AdmissionTimeout)},
+		{Key: "isolation.enabled", Value: strconv.FormatBool(podConfig.Isolation.Enabled)},
+		{Key: "isolation.labels", Value: formatConfigValue("labels", podConfig.Isolation.Labels)},
+		{Key: "isolation.annotations", Value: formatConfigValue("annotations", podConfig.Isolation.Annotations)},
+		{Key: "isolation.resourceQuota", Value: formatConfigValue("resourceQuota", podConfig.Isolation.ResourceQuota)},
+		{Key: "isolation.limitRange", Value: formatConfigValue("limitRange", podConfig.Isolation.LimitRange)},
+		{Key: "egress.enabled", Value: strconv.FormatBool(podConfig.Egress.Enabled)},
+		{Key: "egress.dns", Value: formatConfigValue("dns", podConfig.Egress.DNS)},
+		{Key: "egress.rules", Value: formatConfigValue("rules", podConfig.Egress.Rules)},
+		{Key: "egress.profile", Value: podConfig.Egress.Profile},
+		{Key: "egress.profiles", Value: formatConfigValue("profiles", podConfig.Egress.Profiles)},
+		{Key: "cache.claimName", Value: podConfig.Cache.ClaimName},
+		{Key: "cache.mountPath", Value: podConfig.Cache.MountPath},
+		{Key: "cache.localPath", Value: podConfig.Cache.LocalPath},
+		{Key: "cache.maxSize", Value: podConfig.Cache.MaxSize.String()},
+		{Key: "cache.maxAge", Value: strconv.Itoa(podConfig.Cache.MaxAge)},
+		{Key: "cache.evictionInterval", Value: strconv.Itoa(podConfig.Cache.EvictionInterval)},
+	}
+	for _, list := range []struct {
+		name      string
+		resources corev1.ResourceList
+	}{
+		{"requests", podConfig.Resources.Requests},
+		{"limits", podConfig.Resources.Limits},
+	} {
+		names := make([]string, 0, len(list.resources))
+		for name := range list.resources {
+			names = append(names, string(name))
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			quantity := list.resources[corev1.ResourceName(name)]
+			output = append(output, ConfigValue{
+				Key:   fmt.Sprintf("resources.%s.%s", list.name, name),
+				Value: quantity.String(),
+			})
+		}
+	}
+	return output
+}
+
+// getLogPipelineValues lists the processors of each mode's log pipeline with their options
+func getLogPipelineValues(pipeline *pkg.LogPipelineConfig) []ConfigValue {
+	var output []ConfigValue
+	for _, mode := range []string{pkg.LogPipelineModeAPI, pkg.LogPipelineModeFaktory, pkg.LogPipelineModeTest} {
+		processors := make([]string, 0, len(pipeline.ForMode(mode)))
+		for _, processor := range pipeline.ForMode(mode) {
+			if len(processor.Options) == 0 {
+				processors = append(processors, processor.Name)
+			} else {
+				processors = append(processors, processor.Name+formatConfigValue(processor.Name, processor.Options))
+			}
+		}
+		source := "default"
+		if viper.InConfig(fmt.Sprintf("logPipeline.%s", mode)) {
+			source = "file"
+		}
+		output = append(output, ConfigValue{
+			Key:    fmt.Sprintf("logPipeline.%s", mode),
+			Value:  strings.Join(processors, ", "),
+			Source: source,
+		})
+	}
+	return output
+}
+
+// getRedactionValues lists the redaction settings and the names of the configured rules
+func getRedactionValues(redaction *pkg.RedactionConfig) []ConfigValue {
+	names := make([]string, 0, len(redaction.Rules))
+	for _, rule := range redaction.Rules {
+		names = append(names, rule.Name)
+	}
+	output := []ConfigValue{
+		{Key: "redaction.disableDefaults", Value: strconv.FormatBool(redaction.DisableDefaults)},
+		{Key: "redaction.rules", Value: strings.Join(names, ", ")},
+	}
+	for i := range output {
+		output[i].Source = "default"
+		if viper.InConfig(output[i].Key) {
+			output[i].Source = "file"
+		}
+	}
+	return output
+}
+
+// validateConfigKeys reports keys in the config file that the runner does not know
+// about and values from the config file or environment that can't be parsed as the
+// type of their flag, both of which viper silently ignores.
+func validateConfigKeys() []error {
+	var problems []error
+	known := map[string]bool{}
+	for _, section := range configSections {
+		known[strings.ToLower(section)] = true
+	}
+	for _, flag := range configFlags() {
+		known[flag.Name] = true
+		source := getConfigSource(flag)
+		if source == "flag" || source == "default" {
+			continue
+		}
+		if err := checkConfigType(flag, viper.Get(flag.Name)); err != nil {
+			problems = append(problems, fmt.Errorf("%s: %s from %s", flag.Name, err, source))
+		}
+	}
+	unknown := map[string]bool{}
+	for _, key := range viper.AllKeys() {
+		topLevel := strings.SplitN(key, ".", 2)[0]
+		if !known[topLevel] && viper.InConfig(topLevel) {
+			unknown[topLevel] = true
+		}
+	}
+	keys := make([]string, 0, len(unknown))
+	for key := range unknown {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		problems = append(problems, fmt.Errorf("%s: unknown config key", key))
+	}
+	return problems
+}
+
+func checkConfigType(flag *pflag.Flag, value any) error {
+	switch value.(type) {
+	case map[string]any, []any:
+		if flag.Value.Type() != "stringArray" && flag.Value.Type() != "stringSlice" {
+			return fmt.Errorf("expected a %s but got '%v'", flag.Value.Type(), value)
+		}
+		return nil
+	}
+	raw := strings.TrimSpace(fmt.Sprintf("%v", value))
+	switch flag.Value.Type() {
+	case "int", "int64":
+		if _, err := strconv.ParseInt(raw, 10, 64); err != nil {
+			return fmt.Errorf("expected an integer but got '%s'", raw)
+		}
+	case "bool":
+		if _, err := strconv.ParseBool(raw); err != nil {
+			return fmt.Errorf("expected a boolean but got '%s'", raw)
+		}
+	}
+	return nil
+}
+
+func printConfig(values []ConfigValue) {
+	fmt.Printf("Config file: %s\n\n", viper.ConfigFileUsed())
+	writer := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(writer, "KEY\tVALUE\tSOURCE")
+	for _, value := range values {
+		fmt.Fprintf(writer, "%s\t%s\t%s\n", value.Key, value.Value, value.Source)
+	}
+	writer.Flush()
+}
@@ -157,26 +157,26 @@ func extractCustomExtraFiles(helper worker.Helper, job *opslevel.RunnerJob) erro
 	return nil
 }
 
-func runJob(ctx context.Context, helper worker.Helper, job opslevel.RunnerJob) pkg.JobOutcome {
+func runJob(ctx context.Context, pipeline *jobLogPipeline, helper worker.Helper, job opslevel.RunnerJob) pkg.JobOutcome {
 	logger := log.With().Str("runner", "faktory").Logger()
-	logMaxBytes := viper.GetInt("job-pod-log-max-size")
-	logMaxDuration := time.Duration(viper.GetInt("job-pod-log-max-interval")) * time.Second
-	logPrefix := func() string { return fmt.Sprintf("%s [%d] ", time.Now().UTC().Format(time.RFC3339), 0) }
-	streamer := pkg.NewLogStreamer(
-		logger,
-		pkg.NewFaktorySetOutcomeProcessor(helper, logger, job.Id),
-		pkg.NewSanitizeLogProcessor(job.Variables),
-		pkg.NewPrefixLogProcessor(logPrefix),
-		pkg.NewFaktoryAppendJobLogProcessor(helper, logger, job.Id, logMaxBytes, logMaxDuration),
-	)
-	go streamer.Run(ctx)
-
 	pkg.MetricJobsProcessing.Inc()
+	pipelineJob := pkg.LogPipelineJob{
+		Logger:    logger,
+		Job:       job,
+		RunnerId:  "faktory",
+		Helper:    helper,
+		LogPrefix: getLogPrefix(0),
+	}
+	streamer, err := pipeline.newStreamer(pkg.LogPipelineModeFaktory, pipelineJob)
+	if err != nil {
+		return failJob(pipelineJob, err)
+	}
+	go streamer.Run(context.WithoutCancel(ctx)) // Flush stops it once the job has finished, including the cancel grace period
+
 	logger.Info().Msgf("Starting job '%s'", job.Id)
 	runner := pkg.NewJobRunner("faktory", cfgFile)
 	outcome := runner.Run(ctx, job, streamer.Stdout, streamer.Stderr)
-	streamer.Flush(outcome)
-	return outcome
+	return streamer.Flush(outcome)
 }
 
 func emitJobStartedMetrics() time.Time {
@@ -192,7 +192,14 @@ func emitJobCompleteMetrics(jobStart time.Time, job opslevel.RunnerJob, outcome
 	pkg.MetricJobsProcessing.Dec()
 }
 
-func legacyJobHandler(ctx context.Context, args ...interface{}) error {
+// newLegacyJobHandler handles the jobs of the legacy queue with the log pipeline resolved at startup
+func newLegacyJobHandler(pipeline *jobLogPipeline) worker.Perform {
+	return func(ctx context.Context, args ...interface{}) error {
+		return legacyJobHandler(ctx, pipeline, args...)
+	}
+}
+
+func legacyJobHandler(ctx context.Context, pipeline *jobLogPipeline, args ...interface{}) error {
 	jobStart := emitJobStartedMetrics()
 
 	helper := worker.HelperFor(ctx)
@@ -221,16 +228,16 @@ func legacyJobHandler(ctx context.Context, args ...interface{}) error {
 		return err
 	}
 
-	outcome := runJob(ctx, helper, job)
+	outcome := runJob(ctx, pipeline, helper, job)
 
 	emitJobCompleteMetrics(jobStart, job, outcome)
 	return nil
 }
 
-func runFaktory() {
+func runFaktory(pipeline *jobLogPipeline) {
 	mgr := worker.NewManager()
 	mgr.Concurrency = getConcurrency()
 	mgr.ProcessStrictPriorityQueues(viper.GetStringSlice("queues")...)
-	mgr.Register("legacy", legacyJobHandler)
+	mgr.Register("legacy", newLegacyJobHandler(pipeline))
 	startFaktory(mgr)
 }
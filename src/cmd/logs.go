@@ -0,0 +1,62 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/opslevel/opslevel-runner/pkg"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+var (
+	logsTail   int
+	logsFollow bool
+)
+
+var logsCmd = &cobra.Command{
+	Use:          "logs <job>",
+	Short:        "Print the archived log of a job",
+	Long:         `Print the log of a job from the archive in 'job-log-archive-dir', the job is either its number or its id`,
+	Args:         cobra.ExactArgs(1),
+	Annotations:  map[string]string{skipK8SClientAnnotation: "true"},
+	SilenceUsage: true,
+	RunE:         runLogs,
+}
+
+func init() {
+	rootCmd.AddCommand(logsCmd)
+
+	logsCmd.Flags().IntVarP(&logsTail, "tail", "n", 0, "Only print the last n lines of the log. Set to 0 to print all of it")
+	logsCmd.Flags().BoolVarP(&logsFollow, "follow", "f", false, "Keep printing new lines until the job's output ends")
+}
+
+func runLogs(cmd *cobra.Command, args []string) error {
+	directory := viper.GetString("job-log-archive-dir")
+	if directory == "" {
+		return fmt.Errorf("please specify the archive directory with --job-log-archive-dir")
+	}
+	archive, err := pkg.OpenArchivedLog(directory, args[0])
+	if err != nil {
+		return err
+	}
+	printLine := func(line string) { fmt.Fprintln(cmd.OutOrStdout(), line) }
+	skip := 0
+	if logsTail > 0 {
+		count, err := archive.Lines(0, nil)
+		if err != nil {
+			return err
+		}
+		skip = max(count-logsTail, 0)
+	}
+	if !logsFollow {
+		_, err = archive.Lines(skip, printLine)
+		return err
+	}
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	return archive.Follow(ctx, skip, printLine)
+}
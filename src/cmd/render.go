@@ -0,0 +1,43 @@
+package cmd
+
+import (
+	"fmt"
+
+	"github.com/opslevel/opslevel-runner/pkg"
+	"github.com/spf13/cobra"
+)
+
+var renderCmd = &cobra.Command{
+	Use:         "render",
+	Short:       "Print the kubernetes objects a job would create",
+	Long:        `Print the kubernetes objects a job would create as YAML manifests using the current configuration without contacting the cluster`,
+	Annotations: map[string]string{skipK8SClientAnnotation: "true"},
+	RunE:        doRender,
+}
+
+func init() {
+	rootCmd.AddCommand(renderCmd)
+
+	renderCmd.Flags().StringVarP(&jobFile, "file", "f", ".", "File to read data from. If '-' then reads from stdin. Defaults to read from './job.yaml'")
+}
+
+func doRender(cmd *cobra.Command, args []string) error {
+	// The runner must be configured before the job is read because reading the job replaces viper's config file
+	runner, err := pkg.NewOfflineJobRunner("1", cfgFile)
+	if err != nil {
+		return err
+	}
+	job, err := readJobInput()
+	if err != nil {
+		return err
+	}
+	if job.Id == "" {
+		job.Id = "1"
+	}
+	manifests, err := runner.Render(*job)
+	if err != nil {
+		return err
+	}
+	fmt.Print(string(manifests))
+	return nil
+}
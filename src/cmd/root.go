@@ -1,12 +1,17 @@
 package cmd
 
 import (
+	"bytes"
 	"errors"
+	"fmt"
+	"io"
 	"os"
 	"strings"
+	"time"
 
 	"github.com/getsentry/sentry-go"
 
+	"github.com/opslevel/opslevel-go/v2026"
 	"github.com/opslevel/opslevel-runner/pkg"
 	"github.com/rs/zerolog"
 	"github.com/rs/zerolog/log"
@@ -14,12 +19,25 @@ import (
 	"github.com/spf13/viper"
 )
 
-var cfgFile string
+// skipK8SClientAnnotation marks commands that must work without a reachable kubernetes configuration
+const skipK8SClientAnnotation = "opslevel-runner/skip-k8s-client"
+
+var (
+	cfgFile string
+	// cfgData is the config read from stdin when cfgFile is "."
+	cfgData     []byte
+	envBindings = map[string][]string{}
+)
 
 var rootCmd = &cobra.Command{
 	Use:   "opslevel-runner",
 	Short: "Opslevel Runner",
 	Long:  `Opslevel Runner`,
+	PersistentPreRun: func(cmd *cobra.Command, args []string) {
+		if _, skip := cmd.Annotations[skipK8SClientAnnotation]; !skip {
+			pkg.LoadK8SClient()
+		}
+	},
 }
 
 func Execute(v, c, d string) {
@@ -40,6 +58,13 @@ func init() {
 
 	rootCmd.PersistentFlags().Int("job-pod-max-wait", 60, "The max amount of time to wait for the job pod to become healthy.")
 	rootCmd.PersistentFlags().Int("job-pod-exec-max-wait", 60, "The max amount of time to wait for a job pod exec command with no output before timing out.")
+	rootCmd.PersistentFlags().String("job-pod-exec-mode", "stream", "How job commands are run in the job pod. 'stream' runs them inside the exec connection, 'resumable' runs them detached and re-attaches to their output if the connection drops, 'logs' runs them as the container's command and reads their output from the pod logs without exec.")
+	rootCmd.PersistentFlags().Int("job-pod-exec-max-reconnects", 10, "The max number of times to re-attach to a job pod when the exec or logs connection drops in 'resumable' or 'logs' exec mode.")
+	rootCmd.PersistentFlags().String("job-pod-exec-transport", "auto", "The streaming protocol used to exec into job pods. 'auto' uses 'websocket' when the API server supports it and falls back to 'spdy'.")
+	rootCmd.PersistentFlags().Int("job-pod-usage-sample-interval", 10, "How often in seconds to sample the job pod's cpu and memory usage while a job runs. Set to 0 to only read the usage when the job finishes.")
+	rootCmd.PersistentFlags().Bool("job-pod-debug-hold", false, "Keep the pod of every failed job for debugging for 'job-pod-debug-hold-duration' seconds.")
+	rootCmd.PersistentFlags().Int("job-pod-debug-hold-duration", 0, "How long in seconds to keep a failed job's pod for debugging when the runner or the job asks for it. Set to 0 to never keep failed job pods.")
+	rootCmd.PersistentFlags().Int("job-pod-cancel-grace-period", 30, "How long in seconds a cancelled job's processes get to exit after SIGTERM before they are sent SIGKILL.")
 	rootCmd.PersistentFlags().Int("job-pod-max-lifetime", 3600, "The max amount of time a job pod can run for.")
 	rootCmd.PersistentFlags().String("job-pod-namespace", "default", "The kubernetes namespace to create job pods in.")
 	rootCmd.PersistentFlags().Int64("job-pod-requests-cpu", 1000, "The job pod resource requests cpu millicores.")
@@ -52,6 +77,15 @@ func init() {
 	rootCmd.PersistentFlags().String("job-pod-workdir", "/jobs", "The job pod working directory.")
 	rootCmd.PersistentFlags().Int("job-pod-log-max-interval", 30, "The max amount of time between when pod logs are shipped to OpsLevel. Works in tandem with 'job-pod-log-max-size'")
 	rootCmd.PersistentFlags().Int("job-pod-log-max-size", 1000000, "The max amount in bytes to buffer before pod logs are shipped to OpsLevel. Works in tandem with 'job-pod-log-max-interval'")
+	rootCmd.PersistentFlags().Int("job-log-buffer-max-size", 10485760, "The max amount in bytes of a job's output to hold in memory while it waits to be processed. Set to 0 for no limit")
+	rootCmd.PersistentFlags().String("job-log-stderr-prefix", "", "A prefix added to the lines jobs write to stderr in the logs shipped to OpsLevel, e.g. '[stderr] '")
+	rootCmd.PersistentFlags().String("job-log-buffer-policy", pkg.LogBufferPolicyBlock, "What to do with job output when the log buffer is full. Either 'block' to slow down the job's output or 'drop' to drop lines")
+	rootCmd.PersistentFlags().String("job-log-archive-dir", "", "A directory to archive the complete log of every job in, gzip compressed. Empty means job logs aren't archived")
+	rootCmd.PersistentFlags().Int("job-log-archive-max-file-size", 104857600, "The max amount in bytes of a job's log to write to an archive file before it's rotated. Set to 0 for no limit")
+	rootCmd.PersistentFlags().Int("job-log-archive-max-age", 604800, "The max age in seconds of archived job logs before they are removed. Set to 0 for no limit")
+	rootCmd.PersistentFlags().Int("job-log-archive-max-size", 1073741824, "The max amount in bytes of archived job logs to keep, the oldest are removed first. Set to 0 for no limit")
+	rootCmd.PersistentFlags().String("job-log-spool-dir", "", "A directory to keep job logs and outcomes that failed to send to OpsLevel in until they are retried, including across restarts. Empty means they are only kept in memory")
+	rootCmd.PersistentFlags().Int("job-log-spool-max-age", 86400, "The max age in seconds of job logs and outcomes that failed to send to OpsLevel before they are no longer retried. Set to 0 to retry them forever")
 	rootCmd.PersistentFlags().Bool("job-agent-mode", false, "Enable agent mode with privileged security context for Container-in-Container support. WARNING: This grants elevated privileges and should only be enabled for trusted workloads.")
 	rootCmd.PersistentFlags().String("job-pod-helper-image", "", "Override the helper init container image. Defaults to the published ECR image matching the runner version. Useful for local development with kind.")
 	rootCmd.PersistentFlags().String("queue", "", "The queue this runner should process jobs from. Empty means the default queue.")
@@ -66,31 +100,47 @@ func init() {
 	rootCmd.PersistentFlags().Int("runner-max-replicas", 10, "The max replicas the runner leader should not scale above")
 
 	viper.BindPFlags(rootCmd.PersistentFlags())
-	viper.BindEnv("log-format", "OPSLEVEL_LOG_FORMAT")
-	viper.BindEnv("log-level", "OPSLEVEL_LOG_LEVEL")
-	viper.BindEnv("api-url", "OPSLEVEL_API_URL", "OPSLEVEL_APP_URL")
-	viper.BindEnv("api-token", "OPSLEVEL_API_TOKEN")
-	viper.BindEnv("scaling-enabled", "SCALING_ENABLED")
-
-	viper.BindEnv("job-pod-max-wait", "OPSLEVEL_JOB_POD_MAX_WAIT")
-	viper.BindEnv("job-pod-max-lifetime", "OPSLEVEL_JOB_POD_MAX_LIFETIME")
-	viper.BindEnv("job-pod-namespace", "OPSLEVEL_JOB_POD_NAMESPACE")
-	viper.BindEnv("job-pod-shell", "OPSLEVEL_JOB_POD_SHELL")
-	viper.BindEnv("job-pod-workdir", "OPSLEVEL_JOB_POD_WORKDIR")
-	viper.BindEnv("job-pod-log-max-interval", "OPSLEVEL_JOB_POD_LOG_MAX_INTERVAL")
-	viper.BindEnv("job-pod-log-max-size", "OPSLEVEL_JOB_POD_LOG_MAX_SIZE")
-	viper.BindEnv("job-agent-mode", "OPSLEVEL_JOB_AGENT_MODE")
-	viper.BindEnv("job-pod-helper-image", "OPSLEVEL_JOB_POD_HELPER_IMAGE")
-	viper.BindEnv("queue", "OPSLEVEL_QUEUE")
-
-	viper.BindEnv("k8s-api-qps", "OPSLEVEL_K8S_API_QPS")
-	viper.BindEnv("k8s-api-burst", "OPSLEVEL_K8S_API_BURST")
-
-	viper.BindEnv("runner-pod-name", "RUNNER_POD_NAME")
-	viper.BindEnv("runner-pod-namespace", "RUNNER_POD_NAMESPACE")
-	viper.BindEnv("runner-deployment", "RUNNER_DEPLOYMENT")
-	viper.BindEnv("runner-min-replicas", "RUNNER_MIN_REPLICAS")
-	viper.BindEnv("runner-max-replicas", "RUNNER_MAX_REPLICAS")
+	bindEnv("log-format", "OPSLEVEL_LOG_FORMAT")
+	bindEnv("log-level", "OPSLEVEL_LOG_LEVEL")
+	bindEnv("api-url", "OPSLEVEL_API_URL", "OPSLEVEL_APP_URL")
+	bindEnv("api-token", "OPSLEVEL_API_TOKEN")
+	bindEnv("scaling-enabled", "SCALING_ENABLED")
+
+	bindEnv("job-pod-max-wait", "OPSLEVEL_JOB_POD_MAX_WAIT")
+	bindEnv("job-pod-exec-mode", "OPSLEVEL_JOB_POD_EXEC_MODE")
+	bindEnv("job-pod-exec-max-reconnects", "OPSLEVEL_JOB_POD_EXEC_MAX_RECONNECTS")
+	bindEnv("job-pod-exec-transport", "OPSLEVEL_JOB_POD_EXEC_TRANSPORT")
+	bindEnv("job-pod-usage-sample-interval", "OPSLEVEL_JOB_POD_USAGE_SAMPLE_INTERVAL")
+	bindEnv("job-pod-debug-hold", "OPSLEVEL_JOB_POD_DEBUG_HOLD")
+	bindEnv("job-pod-debug-hold-duration", "OPSLEVEL_JOB_POD_DEBUG_HOLD_DURATION")
+	bindEnv("job-pod-cancel-grace-period", "OPSLEVEL_JOB_POD_CANCEL_GRACE_PERIOD")
+	bindEnv("job-pod-max-lifetime", "OPSLEVEL_JOB_POD_MAX_LIFETIME")
+	bindEnv("job-pod-namespace", "OPSLEVEL_JOB_POD_NAMESPACE")
+	bindEnv("job-pod-shell", "OPSLEVEL_JOB_POD_SHELL")
+	bindEnv("job-pod-workdir", "OPSLEVEL_JOB_POD_WORKDIR")
+	bindEnv("job-pod-log-max-interval", "OPSLEVEL_JOB_POD_LOG_MAX_INTERVAL")
+	bindEnv("job-pod-log-max-size", "OPSLEVEL_JOB_POD_LOG_MAX_SIZE")
+	bindEnv("job-log-buffer-max-size", "OPSLEVEL_JOB_LOG_BUFFER_MAX_SIZE")
+	bindEnv("job-log-buffer-policy", "OPSLEVEL_JOB_LOG_BUFFER_POLICY")
+	bindEnv("job-log-stderr-prefix", "OPSLEVEL_JOB_LOG_STDERR_PREFIX")
+	bindEnv("job-log-archive-dir", "OPSLEVEL_JOB_LOG_ARCHIVE_DIR")
+	bindEnv("job-log-archive-max-file-size", "OPSLEVEL_JOB_LOG_ARCHIVE_MAX_FILE_SIZE")
+	bindEnv("job-log-archive-max-age", "OPSLEVEL_JOB_LOG_ARCHIVE_MAX_AGE")
+	bindEnv("job-log-archive-max-size", "OPSLEVEL_JOB_LOG_ARCHIVE_MAX_SIZE")
+	bindEnv("job-log-spool-dir", "OPSLEVEL_JOB_LOG_SPOOL_DIR")
+	bindEnv("job-log-spool-max-age", "OPSLEVEL_JOB_LOG_SPOOL_MAX_AGE")
+	bindEnv("job-agent-mode", "OPSLEVEL_JOB_AGENT_MODE")
+	bindEnv("job-pod-helper-image", "OPSLEVEL_JOB_POD_HELPER_IMAGE")
+	bindEnv("queue", "OPSLEVEL_QUEUE")
+
+	bindEnv("k8s-api-qps", "OPSLEVEL_K8S_API_QPS")
+	bindEnv("k8s-api-burst", "OPSLEVEL_K8S_API_BURST")
+
+	bindEnv("runner-pod-name", "RUNNER_POD_NAME")
+	bindEnv("runner-pod-namespace", "RUNNER_POD_NAMESPACE")
+	bindEnv("runner-deployment", "RUNNER_DEPLOYMENT")
+	bindEnv("runner-min-replicas", "RUNNER_MIN_REPLICAS")
+	bindEnv("runner-max-replicas", "RUNNER_MAX_REPLICAS")
 
 	cobra.OnInitialize(initConfig)
 }
@@ -102,7 +152,97 @@ func initConfig() {
 	if value, present := os.LookupEnv("SENTRY_DSN"); present {
 		setupSentry(value)
 	}
-	pkg.LoadK8SClient()
+}
+
+// bindEnv binds the environment variables to the config key and remembers them so
+// the source of a config value can be reported by the 'config' command
+func bindEnv(key string, envs ...string) {
+	envBindings[key] = append(envBindings[key], envs...)
+	viper.BindEnv(append([]string{key}, envs...)...)
+}
+
+// getLogBufferConfig reads how much job output the log streamers may hold in memory
+func getLogBufferConfig() pkg.LogBufferConfig {
+	policy := viper.GetString("job-log-buffer-policy")
+	if policy != pkg.LogBufferPolicyBlock && policy != pkg.LogBufferPolicyDrop {
+		cobra.CheckErr(fmt.Errorf("invalid job-log-buffer-policy '%s' must be '%s' or '%s'", policy, pkg.LogBufferPolicyBlock, pkg.LogBufferPolicyDrop))
+	}
+	return pkg.LogBufferConfig{
+		MaxBytes: viper.GetInt("job-log-buffer-max-size"),
+		Policy:   policy,
+	}
+}
+
+// jobLogPipeline is the log pipeline and redaction rules resolved once at startup and shared by the jobs
+type jobLogPipeline struct {
+	config    *pkg.LogPipelineConfig
+	redaction []*pkg.RedactionRule
+	buffer    pkg.LogBufferConfig
+}
+
+// loadLogPipeline resolves the log pipeline from the loaded config so that the runner stops before it
+// takes any jobs when the pipeline can't be built
+func loadLogPipeline() (*jobLogPipeline, error) {
+	data, err := readConfigData()
+	if err != nil {
+		return nil, err
+	}
+	config, problems := pkg.ValidateLogPipelineConfig(data)
+	redaction, redactionProblems := pkg.ValidateRedactionConfig(data)
+	if err := errors.Join(append(problems, redactionProblems...)...); err != nil {
+		return nil, err
+	}
+	rules, err := pkg.NewRedactionRules(*redaction)
+	if err != nil {
+		return nil, err
+	}
+	return &jobLogPipeline{config: config, redaction: rules, buffer: getLogBufferConfig()}, nil
+}
+
+// newStreamer builds the log streamer of a job from the mode's log pipeline so that every mode
+// processes job output the same way unless configured otherwise
+func (p *jobLogPipeline) newStreamer(mode string, job pkg.LogPipelineJob) (pkg.LogStreamer, error) {
+	job.Redaction = p.redaction
+	processors, err := pkg.NewLogPipeline(p.config.ForMode(mode), job)
+	if err != nil {
+		return pkg.LogStreamer{}, err
+	}
+	streamer := pkg.NewLogStreamer(job.Logger, processors...)
+	streamer.SetBuffer(p.buffer)
+	streamer.SetJobId(job.Job.Id)
+	return streamer, nil
+}
+
+// failJob reports the job as failed through the mode's outcome processor alone when its log streamer
+// can't be built, which fails the job without stopping the runner
+func failJob(job pkg.LogPipelineJob, err error) pkg.JobOutcome {
+	outcome := pkg.JobOutcome{
+		Message: fmt.Sprintf("unable to build the job's log pipeline REASON: %s", err),
+		Outcome: opslevel.RunnerJobOutcomeEnumFailed,
+	}
+	job.Logger.Error().Err(err).Msgf("unable to build the log pipeline of job '%s'", job.Job.Id)
+	processors, err := pkg.NewLogPipeline([]pkg.LogProcessorConfig{{Name: "outcome"}}, job)
+	if err != nil {
+		job.Logger.Error().Err(err).Msgf("unable to report the outcome of job '%s'", job.Job.Id)
+		return outcome
+	}
+	for _, processor := range processors {
+		processor.Flush(outcome)
+	}
+	return outcome
+}
+
+// getLogPrefix stamps the lines shipped to OpsLevel with when they arrived at the runner and the
+// worker that ran the job, lines the job wrote to stderr also get the configured stderr prefix
+func getLogPrefix(worker int) func(record pkg.LogRecord) string {
+	stderrPrefix := viper.GetString("job-log-stderr-prefix")
+	return func(record pkg.LogRecord) string {
+		prefix := fmt.Sprintf("%s [%d] ", record.Time.UTC().Format(time.RFC3339), worker)
+		if record.Stream == pkg.LogStreamStderr {
+			prefix += stderrPrefix
+		}
+		return prefix
+	}
 }
 
 func checkFileExists(filePath string) bool {
@@ -110,11 +250,33 @@ func checkFileExists(filePath string) bool {
 	return !errors.Is(err, os.ErrNotExist)
 }
 
+// readConfigData returns the raw config viper loaded, for the sections that are decoded by the runner
+// instead of viper. It's empty when there is no config file.
+func readConfigData() ([]byte, error) {
+	if cfgFile == "." {
+		return cfgData, nil
+	}
+	path := viper.ConfigFileUsed()
+	if path == "" {
+		return nil, nil
+	}
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	return data, err
+}
+
 func readConfig() error {
 	if cfgFile != "" {
 		if cfgFile == "." {
 			viper.SetConfigType("yaml")
-			return viper.ReadConfig(os.Stdin)
+			data, err := io.ReadAll(os.Stdin)
+			if err != nil {
+				return err
+			}
+			cfgData = data
+			return viper.ReadConfig(bytes.NewReader(cfgData))
 		} else {
 			if !checkFileExists(cfgFile) {
 				if _, err := os.Create(cfgFile); err != nil {
@@ -4,7 +4,6 @@ import (
 	"context"
 	"errors"
 	"fmt"
-	"strings"
 	"sync"
 	"time"
 
@@ -36,21 +35,38 @@ func init() {
 	runCmd.Flags().Int("metrics-port", 10354, "The port on which to bind the metrics endpoint to.")
 	runCmd.Flags().Int("log-max-bytes", 512000, "The max amount in bytes before job logs will be sent to OpsLevel.")
 	runCmd.Flags().Int("log-max-time", 30, "The max amount of time in second before job logs will be sent to OpsLevel.")
+	runCmd.Flags().Int("reaper-interval", 300, "The amount of time in seconds between checks for expired job namespaces and debug holds to delete.")
 	viper.BindPFlags(runCmd.Flags())
 
 	rootCmd.AddCommand(runCmd)
 }
 
+// startMaintenance evicts job caches when the runner has the cache claim mounted and reaps
+// job namespaces that leaked in isolation mode and expired debug holds in the background
+func startMaintenance(ctx context.Context) {
+	podConfig, err := pkg.ReadPodConfig(cfgFile)
+	cobra.CheckErr(err)
+	go pkg.RunCacheEviction(ctx, podConfig)
+	if podConfig.Isolation.Enabled || podConfig.DebugHoldDuration > 0 {
+		_, clientset, err := pkg.GetSharedK8sClient()
+		cobra.CheckErr(err)
+		go pkg.NewReaper(clientset, podConfig, time.Duration(viper.GetInt("reaper-interval"))*time.Second).Run(ctx)
+	}
+}
+
 func doRun(cmd *cobra.Command, args []string) {
 	defer sentry.Flush(2 * time.Second)
 	logVersion()
 
 	log.Info().Msg("Starting runner ...")
+	pipeline, err := loadLogPipeline()
+	cobra.CheckErr(err)
 
 	switch viper.GetString("mode") {
 	case "faktory":
 		pkg.StartMetricsServer("faktory", viper.GetInt("metrics-port"))
-		runFaktory()
+		startMaintenance(context.Background())
+		runFaktory(pipeline)
 	case "api":
 		client := pkg.NewGraphClient()
 		var registerArgs []string
@@ -64,6 +80,15 @@ func doRun(cmd *cobra.Command, args []string) {
 		pkg.StartMetricsServer(string(runner.Id), viper.GetInt("metrics-port"))
 
 		ctx := signal.Init(context.Background())
+		startMaintenance(ctx)
+
+		shipQueue, err := pkg.NewShipQueue(client, log.Logger, pkg.ShipQueueConfig{
+			Directory: viper.GetString("job-log-spool-dir"),
+			MaxAge:    time.Duration(viper.GetInt("job-log-spool-max-age")) * time.Second,
+			RunnerId:  runner.Id,
+		})
+		cobra.CheckErr(err)
+		go shipQueue.Run(ctx)
 
 		if viper.GetBool("scaling-enabled") {
 			leaseLockName := viper.GetString("runner-deployment")
@@ -72,9 +97,10 @@ func doRun(cmd *cobra.Command, args []string) {
 			cobra.CheckErr(pkg.RunLeaderElection(ctx, runner.Id, leaseLockName, lockIdentity, leaseLockNamespace))
 		}
 
-		wg := startWorkers(ctx, runner.Id)
+		wg := startWorkers(ctx, runner.Id, shipQueue, pipeline)
 		time.Sleep(1 * time.Second)
 		wg.Wait()
+		drainShipQueue(shipQueue)
 		log.Info().Msgf("Unregister runner for id '%s'...", runner.Id)
 		err = client.RunnerUnregister(runner.Id)
 		if err != nil {
@@ -83,13 +109,28 @@ func doRun(cmd *cobra.Command, args []string) {
 	}
 }
 
-func startWorkers(ctx context.Context, runnerId opslevel.ID) *sync.WaitGroup {
+// drainShipQueue gives the logs and outcomes that failed to send a last chance before the runner exits,
+// what is left stays in the spool directory to be retried when the runner starts again
+func drainShipQueue(shipQueue *pkg.ShipQueue) {
+	if shipQueue.Len() == 0 {
+		return
+	}
+	log.Info().Msgf("Sending %d queued shipment(s) to OpsLevel ...", shipQueue.Len())
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	shipQueue.Drain(ctx)
+	if remaining := shipQueue.Len(); remaining > 0 {
+		log.Warn().Msgf("Exiting with %d shipment(s) that failed to send to OpsLevel", remaining)
+	}
+}
+
+func startWorkers(ctx context.Context, runnerId opslevel.ID, shipQueue *pkg.ShipQueue, pipeline *jobLogPipeline) *sync.WaitGroup {
 	wg := sync.WaitGroup{}
 	concurrency := getConcurrency()
 	wg.Add(concurrency)
 	jobQueue := make(chan opslevel.RunnerJob)
 	for w := 1; w <= concurrency; w++ {
-		go jobWorker(ctx, &wg, w, runnerId, jobQueue)
+		go jobWorker(ctx, &wg, w, runnerId, jobQueue, shipQueue, pipeline)
 	}
 	go jobPoller(ctx, runnerId, jobQueue)
 	return &wg
@@ -103,11 +144,8 @@ func getConcurrency() int {
 	return concurrency
 }
 
-func jobWorker(ctx context.Context, wg *sync.WaitGroup, index int, runnerId opslevel.ID, jobQueue <-chan opslevel.RunnerJob) {
-	logMaxBytes := viper.GetInt("job-pod-log-max-size")
-	logMaxDuration := time.Duration(viper.GetInt("job-pod-log-max-interval")) * time.Second
-	logPrefix := func() string { return fmt.Sprintf("%s [%d] ", time.Now().UTC().Format(time.RFC3339), index) }
-	logLevel := strings.ToLower(viper.GetString("log-level"))
+func jobWorker(ctx context.Context, wg *sync.WaitGroup, index int, runnerId opslevel.ID, jobQueue <-chan opslevel.RunnerJob, shipQueue *pkg.ShipQueue, pipeline *jobLogPipeline) {
+	logPrefix := getLogPrefix(index)
 	logger := log.With().Int("worker", index).Logger()
 	client := pkg.NewGraphClient()
 	tracer := pkg.GetTracer()
@@ -116,18 +154,21 @@ func jobWorker(ctx context.Context, wg *sync.WaitGroup, index int, runnerId opsl
 	logger.Info().Msgf("Starting job processor %d ...", index)
 	defer wg.Done()
 	for job := range jobQueue {
-		jobId := job.Id
 		jobNumber := job.Number()
 
-		streamer := pkg.NewLogStreamer(
-			logger,
-			pkg.NewSetOutcomeVarLogProcessor(client, logger, runnerId, jobId, jobNumber),
-			pkg.NewSanitizeLogProcessor(job.Variables),
-			pkg.NewPrefixLogProcessor(logPrefix),
-			pkg.NewOpsLevelAppendLogProcessor(client, logger, runnerId, jobId, jobNumber, logMaxBytes, logMaxDuration),
-		)
-		if logLevel == "trace" {
-			streamer.AddProcessor(pkg.NewLoggerLogProcessor(logger))
+		pipelineJob := pkg.LogPipelineJob{
+			Logger:    logger,
+			Job:       job,
+			RunnerId:  runnerId,
+			Client:    client,
+			LogPrefix: logPrefix,
+			ShipQueue: shipQueue,
+		}
+		streamer, err := pipeline.newStreamer(pkg.LogPipelineModeAPI, pipelineJob)
+		if err != nil {
+			outcome := failJob(pipelineJob, err)
+			pkg.MetricJobsFinished.WithLabelValues(string(outcome.Outcome)).Inc()
+			continue
 		}
 
 		jobStart := time.Now()
@@ -135,7 +176,7 @@ func jobWorker(ctx context.Context, wg *sync.WaitGroup, index int, runnerId opsl
 		pkg.MetricJobsProcessing.Inc()
 		logger.Info().Msgf("Starting job '%s'", jobNumber)
 
-		go streamer.Run(ctx)
+		go streamer.Run(context.WithoutCancel(ctx)) // Flush stops it once the job has finished, including the cancel grace period
 		traceCtx, spanStart := tracer.Start(ctx, "start-job",
 			trace.WithSpanKind(trace.SpanKindConsumer),
 			trace.WithAttributes(attribute.String("job", jobNumber)),
@@ -145,7 +186,7 @@ func jobWorker(ctx context.Context, wg *sync.WaitGroup, index int, runnerId opsl
 			"finish-job",
 			trace.WithSpanKind(trace.SpanKindConsumer),
 			trace.WithAttributes(attribute.String("job", jobNumber)))
-		streamer.Flush(outcome)
+		outcome = streamer.Flush(outcome)
 		spanFinish.SetAttributes(
 			attribute.String("outcome", string(outcome.Outcome)),
 		)
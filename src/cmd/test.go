@@ -5,7 +5,6 @@ import (
 	"errors"
 	"fmt"
 	"os"
-	"time"
 
 	"github.com/opslevel/opslevel-go/v2026"
 	"github.com/opslevel/opslevel-runner/pkg"
@@ -38,20 +37,26 @@ func doTest(cmd *cobra.Command, args []string) error {
 	if job.Id == "" {
 		job.Id = "1"
 	}
-	streamer := pkg.NewLogStreamer(
-		log.Logger,
-		pkg.NewSetOutcomeVarLogProcessor(nil, log.Logger, "1", "1", "1"),
-		pkg.NewSanitizeLogProcessor(job.Variables),
-		pkg.NewLoggerLogProcessor(log.Logger),
-		pkg.NewOpsLevelAppendLogProcessor(nil, log.Logger, "1", "1", "1", 1024000, 30*time.Second),
-	)
+	pipeline, err := loadLogPipeline()
+	if err != nil {
+		return err
+	}
+	streamer, err := pipeline.newStreamer(pkg.LogPipelineModeTest, pkg.LogPipelineJob{
+		Logger:    log.Logger,
+		Job:       *job,
+		RunnerId:  "1",
+		LogPrefix: getLogPrefix(0),
+	})
+	if err != nil {
+		return err
+	}
 	runner := pkg.NewJobRunner("1", cfgFile)
 
 	ctx := signal.Init(context.Background())
 
-	go streamer.Run(ctx)
+	go streamer.Run(context.WithoutCancel(ctx)) // Flush stops it once the job has finished, including the cancel grace period
 	outcome := runner.Run(ctx, *job, streamer.Stdout, streamer.Stderr)
-	streamer.Flush(outcome)
+	outcome = streamer.Flush(outcome)
 
 	if outcome.Outcome != opslevel.RunnerJobOutcomeEnumSuccess {
 		return fmt.Errorf("%s", outcome.Message)
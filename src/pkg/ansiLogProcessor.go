@@ -0,0 +1,73 @@
+package pkg
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+const (
+	// AnsiModeStrip removes every escape sequence so lines are plain text
+	AnsiModeStrip = "strip"
+	// AnsiModeNormalize keeps color and style sequences so they can be rendered and removes the rest
+	AnsiModeNormalize = "normalize"
+)
+
+var (
+	// escapeSequenceExp matches CSI sequences (e.g. colors and cursor movement), OSC sequences (e.g.
+	// window titles and hyperlinks) and the remaining escape sequences (e.g. character set selection)
+	escapeSequenceExp = regexp.MustCompile(`\x1b\[[0-?]*[ -/]*[@-~]|\x1b\][^\x07\x1b]*(?:\x07|\x1b\\)?|\x1b[ -/]*[0-~]?`)
+	// controlCharacterExp matches the C0 and C1 control characters except tab and escape
+	controlCharacterExp = regexp.MustCompile(`[\x00-\x08\x0a-\x1a\x1c-\x1f\x7f\x{80}-\x{9f}]`)
+)
+
+// AnsiLogProcessor cleans up terminal output so that it reads well outside a terminal. It collapses
+// lines that were overwritten with carriage returns (e.g. progress bars) to what was displayed last,
+// repairs invalid UTF-8 and strips or normalizes escape sequences and control characters.
+type AnsiLogProcessor struct {
+	mode string
+}
+
+func NewAnsiLogProcessor(mode string) (*AnsiLogProcessor, error) {
+	switch mode {
+	case "":
+		mode = AnsiModeStrip
+	case AnsiModeStrip, AnsiModeNormalize:
+	default:
+		return nil, fmt.Errorf("mode: '%s' is not one of [%s, %s]", mode, AnsiModeStrip, AnsiModeNormalize)
+	}
+	return &AnsiLogProcessor{
+		mode: mode,
+	}, nil
+}
+
+func (s *AnsiLogProcessor) Process(line string) string {
+	line = strings.ToValidUTF8(collapseCarriageReturns(line), "\uFFFD")
+	line = escapeSequenceExp.ReplaceAllStringFunc(line, func(sequence string) string {
+		if s.mode == AnsiModeNormalize && strings.HasPrefix(sequence, "\x1b[") && strings.HasSuffix(sequence, "m") {
+			return sequence
+		}
+		return ""
+	})
+	return controlCharacterExp.ReplaceAllString(line, "")
+}
+
+func (s *AnsiLogProcessor) ProcessStdout(line string) string {
+	return s.Process(line)
+}
+
+func (s *AnsiLogProcessor) ProcessStderr(line string) string {
+	return s.Process(line)
+}
+
+func (s *AnsiLogProcessor) Flush(outcome JobOutcome) {}
+
+// collapseCarriageReturns returns what a terminal displayed last for a line that was redrawn after
+// carriage returns, a trailing carriage return (e.g. from a CRLF line ending) doesn't clear the line
+func collapseCarriageReturns(line string) string {
+	line = strings.TrimRight(line, "\r")
+	if index := strings.LastIndexByte(line, '\r'); index >= 0 {
+		return line[index+1:]
+	}
+	return line
+}
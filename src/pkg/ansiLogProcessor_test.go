@@ -0,0 +1,42 @@
+package pkg
+
+import (
+	"testing"
+
+	"github.com/rocktavious/autopilot/v2023"
+)
+
+func TestAnsiLogProcessorStrip(t *testing.T) {
+	// Arrange
+	p, err := NewAnsiLogProcessor(AnsiModeStrip)
+	autopilot.Ok(t, err)
+	// Act
+	colored := p.Process("\x1b[1;32m::set-outcome-var result=ok\x1b[0m")
+	progress := p.Process("Downloading  10%\rDownloading  55%\rDownloading 100%\r")
+	title := p.Process("\x1b]0;my title\x07done\x1b[2K\x1b(B")
+	control := p.Process("bell\x07\tback\x08space\x00\x7f")
+	invalid := p.Process("caf\xe9 \xc2\x9bok")
+	// Assert
+	autopilot.Equals(t, "::set-outcome-var result=ok", colored)
+	autopilot.Equals(t, "Downloading 100%", progress)
+	autopilot.Equals(t, "done", title)
+	autopilot.Equals(t, "bell\tbackspace", control)
+	autopilot.Equals(t, "caf� ok", invalid)
+}
+
+func TestAnsiLogProcessorNormalize(t *testing.T) {
+	// Arrange
+	p, err := NewAnsiLogProcessor(AnsiModeNormalize)
+	autopilot.Ok(t, err)
+	// Act
+	line := p.Process("\x1b[31merror\x1b[0m\x1b[K \x1b]8;;https://example.com\x1b\\link\x1b]8;;\x1b\\")
+	// Assert
+	autopilot.Equals(t, "\x1b[31merror\x1b[0m link", line)
+}
+
+func TestAnsiLogProcessorInvalidMode(t *testing.T) {
+	// Act
+	_, err := NewAnsiLogProcessor("colorize")
+	// Assert
+	autopilot.Equals(t, "mode: 'colorize' is not one of [strip, normalize]", err.Error())
+}
@@ -0,0 +1,382 @@
+package pkg
+
+import (
+	"bufio"
+	"compress/gzip"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/rs/zerolog"
+)
+
+const (
+	// archiveDoneFile marks the archive of a job whose output has ended
+	archiveDoneFile      = "done"
+	archiveFileExtension = ".log.gz"
+	// archiveFlushInterval is how often the archive is flushed so that it can be followed while the job runs
+	archiveFlushInterval = time.Second
+	// archivePollInterval is how often following an archive checks for more output
+	archivePollInterval = time.Second
+)
+
+// LogArchiveConfig configures the archive of job logs on disk. The log of each job is written to
+// numbered gzip files in a directory named after the job, a file is rotated once MaxFileSize bytes of
+// output were written to it. The archives of other jobs older than MaxAge seconds are removed, followed
+// by the oldest archives of finished jobs until all of them fit in MaxSize bytes. A limit of 0 or less is
+// no limit.
+type LogArchiveConfig struct {
+	Directory   string
+	MaxFileSize int
+	MaxAge      int
+	MaxSize     int
+}
+
+// ArchiveLogProcessor writes the job's log with the time and stream of each line to the archive
+type ArchiveLogProcessor struct {
+	config    LogArchiveConfig
+	logger    zerolog.Logger
+	job       string
+	file      *os.File
+	writer    *gzip.Writer
+	files     int
+	written   int
+	lastFlush time.Time
+	failed    bool
+}
+
+func NewArchiveLogProcessor(logger zerolog.Logger, config LogArchiveConfig, job string) *ArchiveLogProcessor {
+	return &ArchiveLogProcessor{
+		config: config,
+		logger: logger,
+		job:    job,
+	}
+}
+
+func (s *ArchiveLogProcessor) ProcessRecord(record LogRecord) LogRecord {
+	if s.failed {
+		return record
+	}
+	line := fmt.Sprintf("%s %s %s\n", record.Time.UTC().Format(time.RFC3339Nano), record.Stream, record.Text)
+	if err := s.write(line); err != nil {
+		s.logger.Error().Err(err).Msgf("error while archiving the log of job '%s', the rest of it won't be archived", s.job)
+		s.failed = true
+		s.close()
+	}
+	return record
+}
+
+func (s *ArchiveLogProcessor) write(line string) error {
+	if s.writer == nil || (s.config.MaxFileSize > 0 && s.written > 0 && s.written+len(line) > s.config.MaxFileSize) {
+		if err := s.rotate(); err != nil {
+			return err
+		}
+	}
+	if _, err := s.writer.Write([]byte(line)); err != nil {
+		return err
+	}
+	s.written += len(line)
+	if time.Since(s.lastFlush) >= archiveFlushInterval {
+		s.lastFlush = time.Now()
+		return s.writer.Flush()
+	}
+	return nil
+}
+
+// rotate closes the current file and opens the next one, the first time it also removes the archives
+// that are past their retention and the archive of an earlier run of the job so a re-run doesn't keep
+// its done marker or files
+func (s *ArchiveLogProcessor) rotate() error {
+	directory, err := getArchiveDirectory(s.config.Directory, s.job)
+	if err != nil {
+		return err
+	}
+	if s.writer == nil {
+		if err := PruneLogArchive(s.config, s.job); err != nil {
+			s.logger.Warn().Err(err).Msg("error while removing old job log archives")
+		}
+		if err := os.RemoveAll(directory); err != nil {
+			return err
+		}
+		if err := os.MkdirAll(directory, 0o750); err != nil {
+			return err
+		}
+	} else if err := s.close(); err != nil {
+		return err
+	}
+	s.files++
+	s.file, err = os.OpenFile(filepath.Join(directory, getArchiveFileName(s.files)), os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0o640)
+	if err != nil {
+		return err
+	}
+	s.writer = gzip.NewWriter(s.file)
+	s.written = 0
+	return nil
+}
+
+func (s *ArchiveLogProcessor) close() error {
+	if s.writer == nil {
+		return nil
+	}
+	err := errors.Join(s.writer.Close(), s.file.Close())
+	s.writer, s.file = nil, nil
+	return err
+}
+
+func (s *ArchiveLogProcessor) ProcessStdout(line string) string {
+	return s.ProcessRecord(LogRecord{Text: line, Stream: LogStreamStdout, Time: time.Now()}).Text
+}
+
+func (s *ArchiveLogProcessor) ProcessStderr(line string) string {
+	return s.ProcessRecord(LogRecord{Text: line, Stream: LogStreamStderr, Time: time.Now()}).Text
+}
+
+// Flush closes the archive and marks it done so that following it stops
+func (s *ArchiveLogProcessor) Flush(outcome JobOutcome) {
+	if s.writer == nil {
+		return
+	}
+	directory, _ := getArchiveDirectory(s.config.Directory, s.job)
+	err := s.close()
+	if err == nil {
+		err = os.WriteFile(filepath.Join(directory, archiveDoneFile), []byte(outcome.Outcome), 0o640)
+	}
+	if err != nil {
+		s.logger.Error().Err(err).Msgf("error while closing the log archive of job '%s'", s.job)
+	}
+}
+
+// getArchiveDirectory returns the directory of the job's archive, the job must not be a path
+func getArchiveDirectory(directory string, job string) (string, error) {
+	if job == "" || job == "." || job == ".." || strings.ContainsAny(job, `/\`) {
+		return "", fmt.Errorf("invalid job '%s' for the log archive", job)
+	}
+	return filepath.Join(directory, job), nil
+}
+
+// getArchiveFileName returns the name of the numbered file of a job's archive
+func getArchiveFileName(number int) string {
+	return fmt.Sprintf("%04d%s", number, archiveFileExtension)
+}
+
+type archivedJob struct {
+	directory string
+	modified  time.Time
+	size      int64
+	done      bool
+}
+
+// PruneLogArchive removes the archives that are older than MaxAge and then the oldest archives of
+// finished jobs until the rest fit in MaxSize. The archive of the job named keep is never removed, nor
+// are the archives other workers are still writing to unless they expired.
+func PruneLogArchive(config LogArchiveConfig, keep string) error {
+	entries, err := os.ReadDir(config.Directory)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+	var jobs []archivedJob
+	var total int64
+	for _, entry := range entries {
+		if !entry.IsDir() || entry.Name() == keep {
+			continue
+		}
+		job := archivedJob{directory: filepath.Join(config.Directory, entry.Name())}
+		files, err := os.ReadDir(job.directory)
+		if err != nil {
+			return err
+		}
+		for _, file := range files {
+			info, err := file.Info()
+			if err != nil {
+				continue
+			}
+			if file.Name() == archiveDoneFile {
+				job.done = true
+			}
+			job.size += info.Size()
+			if info.ModTime().After(job.modified) {
+				job.modified = info.ModTime()
+			}
+		}
+		jobs = append(jobs, job)
+		total += job.size
+	}
+	sort.Slice(jobs, func(i, j int) bool { return jobs[i].modified.Before(jobs[j].modified) })
+	for _, job := range jobs {
+		expired := config.MaxAge > 0 && time.Since(job.modified) > time.Duration(config.MaxAge)*time.Second
+		if !expired && (!job.done || config.MaxSize <= 0 || total <= int64(config.MaxSize)) {
+			continue
+		}
+		if err := os.RemoveAll(job.directory); err != nil {
+			return err
+		}
+		total -= job.size
+	}
+	return nil
+}
+
+// ArchivedLog is the archive of a job's log on disk
+type ArchivedLog struct {
+	directory string
+}
+
+// OpenArchivedLog finds the archive of a job, either by its number or its id
+func OpenArchivedLog(directory string, job string) (*ArchivedLog, error) {
+	job = job[strings.LastIndex(job, "/")+1:]
+	path, err := getArchiveDirectory(directory, job)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := os.Stat(path); err != nil {
+		if os.IsNotExist(err) {
+			return nil, fmt.Errorf("no archived log for job '%s' in '%s'", job, directory)
+		}
+		return nil, err
+	}
+	return &ArchivedLog{directory: path}, nil
+}
+
+// Done reports whether the job's output has ended
+func (a *ArchivedLog) Done() bool {
+	_, err := os.Stat(filepath.Join(a.directory, archiveDoneFile))
+	return err == nil
+}
+
+// Lines calls fn with each line of the log after the first skip lines and returns how many lines the
+// log has. A file that is still being written is read up to its last flush.
+func (a *ArchivedLog) Lines(skip int, fn func(line string)) (int, error) {
+	files, err := filepath.Glob(filepath.Join(a.directory, "*"+archiveFileExtension))
+	if err != nil {
+		return 0, err
+	}
+	slices.Sort(files)
+	done := a.Done()
+	count := 0
+	for i, file := range files {
+		err := readArchiveFile(file, func(line string) {
+			if count >= skip && fn != nil {
+				fn(line)
+			}
+			count++
+		})
+		writing := !done && i == len(files)-1
+		if err != nil && !(writing && (errors.Is(err, io.ErrUnexpectedEOF) || errors.Is(err, io.EOF))) {
+			return count, fmt.Errorf("error while reading '%s': %w", file, err)
+		}
+	}
+	return count, nil
+}
+
+// Follow calls fn with each line of the log after the first skip lines as it's written and returns
+// once the job's output has ended or the context is done. Each file is read once while it grows.
+func (a *ArchivedLog) Follow(ctx context.Context, skip int, fn func(line string)) error {
+	count := 0
+	for number := 1; ; number++ {
+		path := filepath.Join(a.directory, getArchiveFileName(number))
+		for !fileExists(path) {
+			// The last file is written before the job's output is marked as ended
+			if a.Done() && !fileExists(path) {
+				return nil
+			}
+			select {
+			case <-ctx.Done():
+				return nil
+			case <-time.After(archivePollInterval):
+			}
+		}
+		next := filepath.Join(a.directory, getArchiveFileName(number+1))
+		err := followArchiveFile(ctx, path, func() bool { return a.Done() || fileExists(next) }, func(line string) {
+			if count >= skip {
+				fn(line)
+			}
+			count++
+		})
+		if ctx.Err() != nil {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("error while reading '%s': %w", path, err)
+		}
+	}
+}
+
+func fileExists(path string) bool {
+	_, err := os.Stat(path)
+	return err == nil
+}
+
+// growingFile reads a file that is still being written, at its end it waits for more of it until
+// complete reports that the file has been written in full
+type growingFile struct {
+	ctx      context.Context
+	file     *os.File
+	complete func() bool
+}
+
+func (f *growingFile) Read(p []byte) (int, error) {
+	for {
+		n, err := f.file.Read(p)
+		if n > 0 || err != io.EOF {
+			return n, err
+		}
+		if f.complete() {
+			// Read what was written before the file was complete
+			return f.file.Read(p)
+		}
+		select {
+		case <-f.ctx.Done():
+			return 0, f.ctx.Err()
+		case <-time.After(archivePollInterval):
+		}
+	}
+}
+
+// followArchiveFile reads the file as it's written until complete reports that it's been written in full
+func followArchiveFile(ctx context.Context, path string, complete func() bool, fn func(line string)) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	reader, err := gzip.NewReader(&growingFile{ctx: ctx, file: file, complete: complete})
+	if err != nil {
+		return err
+	}
+	defer reader.Close()
+	// Stop at the end of the file's stream instead of waiting for another one
+	reader.Multistream(false)
+	return scanArchive(reader, fn)
+}
+
+func readArchiveFile(path string, fn func(line string)) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	reader, err := gzip.NewReader(file)
+	if err != nil {
+		return err
+	}
+	defer reader.Close()
+	return scanArchive(reader, fn)
+}
+
+func scanArchive(reader io.Reader, fn func(line string)) error {
+	scanner := bufio.NewScanner(reader)
+	scanner.Buffer(make([]byte, 64*1024), 64*1024*1024)
+	for scanner.Scan() {
+		fn(scanner.Text())
+	}
+	return scanner.Err()
+}
@@ -0,0 +1,163 @@
+package pkg
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/opslevel/opslevel-go/v2026"
+	"github.com/rocktavious/autopilot/v2023"
+	"github.com/rs/zerolog"
+)
+
+func readArchivedLog(t *testing.T, directory string, job string, skip int) []string {
+	archive, err := OpenArchivedLog(directory, job)
+	autopilot.Ok(t, err)
+	lines := []string{}
+	_, err = archive.Lines(skip, func(line string) { lines = append(lines, line) })
+	autopilot.Ok(t, err)
+	return lines
+}
+
+func TestArchiveLogProcessor(t *testing.T) {
+	// Arrange
+	directory := t.TempDir()
+	at := time.Date(2026, 10, 19, 12, 0, 0, 0, time.UTC)
+	p := NewArchiveLogProcessor(zerolog.Nop(), LogArchiveConfig{Directory: directory, MaxFileSize: 90}, "42")
+	// Act
+	p.ProcessRecord(LogRecord{Text: "cloning", Stream: LogStreamStdout, Time: at})
+	p.ProcessRecord(LogRecord{Text: "warning: shallow", Stream: LogStreamStderr, Time: at.Add(time.Second)})
+	p.ProcessRecord(LogRecord{Text: "done", Stream: LogStreamStdout, Time: at.Add(2 * time.Second)})
+	p.Flush(JobOutcome{Outcome: opslevel.RunnerJobOutcomeEnumSuccess})
+	// Assert
+	files, _ := filepath.Glob(filepath.Join(directory, "42", "*.log.gz"))
+	autopilot.Equals(t, 2, len(files))
+	archive, err := OpenArchivedLog(directory, "Z2lkOi8vb3BzbGV2ZWwvUnVubmVySm9iLzQy/42")
+	autopilot.Ok(t, err)
+	autopilot.Equals(t, true, archive.Done())
+	autopilot.Equals(t, []string{
+		"2026-10-19T12:00:00Z stdout cloning",
+		"2026-10-19T12:00:01Z stderr warning: shallow",
+		"2026-10-19T12:00:02Z stdout done",
+	}, readArchivedLog(t, directory, "42", 0))
+	autopilot.Equals(t, []string{"2026-10-19T12:00:02Z stdout done"}, readArchivedLog(t, directory, "42", 2))
+}
+
+func TestArchiveLogProcessorReplacesEarlierRun(t *testing.T) {
+	// Arrange
+	directory := t.TempDir()
+	at := time.Date(2026, 10, 19, 12, 0, 0, 0, time.UTC)
+	config := LogArchiveConfig{Directory: directory, MaxFileSize: 90}
+	first := NewArchiveLogProcessor(zerolog.Nop(), config, "42")
+	first.ProcessRecord(LogRecord{Text: "cloning", Stream: LogStreamStdout, Time: at})
+	first.ProcessRecord(LogRecord{Text: "warning: shallow", Stream: LogStreamStderr, Time: at.Add(time.Second)})
+	first.ProcessRecord(LogRecord{Text: "done", Stream: LogStreamStdout, Time: at.Add(2 * time.Second)})
+	first.Flush(JobOutcome{Outcome: opslevel.RunnerJobOutcomeEnumFailed})
+	// Act
+	rerun := NewArchiveLogProcessor(zerolog.Nop(), config, "42")
+	rerun.ProcessRecord(LogRecord{Text: "retrying", Stream: LogStreamStdout, Time: at.Add(time.Minute)})
+	files, _ := filepath.Glob(filepath.Join(directory, "42", "*.log.gz"))
+	archive, err := OpenArchivedLog(directory, "42")
+	autopilot.Ok(t, err)
+	done := archive.Done()
+	rerun.Flush(JobOutcome{Outcome: opslevel.RunnerJobOutcomeEnumSuccess})
+	// Assert
+	autopilot.Equals(t, 1, len(files))
+	autopilot.Equals(t, false, done)
+	autopilot.Equals(t, []string{"2026-10-19T12:01:00Z stdout retrying"}, readArchivedLog(t, directory, "42", 0))
+}
+
+func TestArchiveLogProcessorCanBeReadWhileWriting(t *testing.T) {
+	// Arrange
+	directory := t.TempDir()
+	p := NewArchiveLogProcessor(zerolog.Nop(), LogArchiveConfig{Directory: directory}, "7")
+	// Act
+	p.ProcessRecord(LogRecord{Text: "first", Stream: LogStreamStdout})
+	p.ProcessRecord(LogRecord{Text: "second", Stream: LogStreamStdout})
+	archive, err := OpenArchivedLog(directory, "7")
+	autopilot.Ok(t, err)
+	count, readErr := archive.Lines(0, nil)
+	// Assert
+	autopilot.Ok(t, readErr)
+	autopilot.Equals(t, false, archive.Done())
+	autopilot.Equals(t, 1, count)
+}
+
+func TestArchivedLogFollow(t *testing.T) {
+	// Arrange
+	directory := t.TempDir()
+	at := time.Date(2026, 10, 19, 12, 0, 0, 0, time.UTC)
+	p := NewArchiveLogProcessor(zerolog.Nop(), LogArchiveConfig{Directory: directory, MaxFileSize: 90}, "9")
+	p.ProcessRecord(LogRecord{Text: "cloning", Stream: LogStreamStdout, Time: at})
+	archive, err := OpenArchivedLog(directory, "9")
+	autopilot.Ok(t, err)
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	var mutex sync.Mutex
+	lines := []string{}
+	followed := make(chan error)
+	// Act
+	go func() {
+		followed <- archive.Follow(ctx, 1, func(line string) {
+			mutex.Lock()
+			defer mutex.Unlock()
+			lines = append(lines, line)
+		})
+	}()
+	time.Sleep(archiveFlushInterval + 100*time.Millisecond)
+	p.ProcessRecord(LogRecord{Text: "warning: shallow", Stream: LogStreamStderr, Time: at.Add(time.Second)})
+	p.ProcessRecord(LogRecord{Text: "done", Stream: LogStreamStdout, Time: at.Add(2 * time.Second)})
+	p.Flush(JobOutcome{Outcome: opslevel.RunnerJobOutcomeEnumSuccess})
+	followErr := <-followed
+	// Assert: the job's output ended before the context was done
+	autopilot.Ok(t, followErr)
+	autopilot.Ok(t, ctx.Err())
+	autopilot.Equals(t, []string{
+		"2026-10-19T12:00:01Z stderr warning: shallow",
+		"2026-10-19T12:00:02Z stdout done",
+	}, lines)
+}
+
+func TestPruneLogArchive(t *testing.T) {
+	// Arrange
+	directory := t.TempDir()
+	writeArchive := func(job string, size int, age time.Duration, done bool) {
+		path := filepath.Join(directory, job)
+		autopilot.Ok(t, os.MkdirAll(path, 0o750))
+		file := filepath.Join(path, "0001.log.gz")
+		autopilot.Ok(t, os.WriteFile(file, make([]byte, size), 0o640))
+		modified := time.Now().Add(-age)
+		autopilot.Ok(t, os.Chtimes(file, modified, modified))
+		if done {
+			autopilot.Ok(t, os.WriteFile(filepath.Join(path, archiveDoneFile), nil, 0o640))
+			autopilot.Ok(t, os.Chtimes(filepath.Join(path, archiveDoneFile), modified, modified))
+		}
+	}
+	writeArchive("expired", 10, 3*time.Hour, false)
+	writeArchive("live", 100, 2*time.Hour, false)
+	writeArchive("oldest", 100, 90*time.Minute, true)
+	writeArchive("newest", 100, time.Minute, true)
+	writeArchive("running", 100, 4*time.Hour, false)
+	// Act
+	err := PruneLogArchive(LogArchiveConfig{Directory: directory, MaxAge: 9000, MaxSize: 250}, "running")
+	// Assert
+	autopilot.Ok(t, err)
+	entries, _ := os.ReadDir(directory)
+	names := []string{}
+	for _, entry := range entries {
+		names = append(names, entry.Name())
+	}
+	autopilot.Equals(t, []string{"live", "newest", "running"}, names)
+}
+
+func TestOpenArchivedLogRejectsPaths(t *testing.T) {
+	// Act
+	_, missingErr := OpenArchivedLog(t.TempDir(), "1")
+	_, pathErr := OpenArchivedLog(t.TempDir(), "..")
+	// Assert
+	autopilot.Equals(t, true, missingErr != nil)
+	autopilot.Equals(t, "invalid job '..' for the log archive", pathErr.Error())
+}
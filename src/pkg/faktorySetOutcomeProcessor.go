@@ -71,13 +71,7 @@ func (s *FaktorySetOutcomeProcessor) ProcessStderr(line string) string {
 }
 
 func (s *FaktorySetOutcomeProcessor) Flush(outcome JobOutcome) {
-	vars := make([]opslevel.RunnerJobOutcomeVariable, 0)
-	for k, v := range s.vars {
-		vars = append(vars, opslevel.RunnerJobOutcomeVariable{
-			Key:   k,
-			Value: v,
-		})
-	}
+	vars := mergeOutcomeVariables(s.vars, outcome.OutcomeVariables)
 	payload := opslevel.RunnerReportJobOutcomeInput{
 		RunnerId:         "faktory",
 		RunnerJobId:      s.jobId,
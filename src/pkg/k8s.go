@@ -1,12 +1,16 @@
 package pkg
 
 import (
+	"bytes"
 	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"path"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/rs/zerolog/log"
@@ -14,7 +18,9 @@ import (
 
 	"k8s.io/apimachinery/pkg/util/intstr"
 
+	batchv1 "k8s.io/api/batch/v1"
 	corev1 "k8s.io/api/core/v1"
+	networkingv1 "k8s.io/api/networking/v1"
 	policyv1 "k8s.io/api/policy/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/util/wait"
@@ -23,6 +29,7 @@ import (
 	"k8s.io/client-go/rest"
 	"k8s.io/client-go/tools/clientcmd"
 	"k8s.io/client-go/tools/remotecommand"
+	"sigs.k8s.io/yaml"
 
 	"github.com/opslevel/opslevel-go/v2026"
 	"github.com/rs/zerolog"
@@ -51,22 +58,103 @@ type JobConfig struct {
 	PodName       string
 	ContainerName string
 	Stdin         io.Reader
-	Stdout        *SafeBuffer
-	Stderr        *SafeBuffer
+	Stdout        io.Writer
+	Stderr        io.Writer
 }
 
 type JobRunner struct {
 	runnerId  string
 	logger    zerolog.Logger
 	config    *rest.Config
-	clientset *kubernetes.Clientset
+	clientset kubernetes.Interface
 	podConfig *K8SPodConfig
+	// execFallback is set once an exec fell back to SPDY in auto mode so that later execs skip WebSocket
+	execFallback atomic.Bool
 }
 
 type JobOutcome struct {
 	Message          string
 	Outcome          opslevel.RunnerJobOutcomeEnum
 	OutcomeVariables []opslevel.RunnerJobOutcomeVariable
+	ResourceUsage    *ResourceUsage
+}
+
+// JobObjects are the kubernetes objects created to run a single job
+type JobObjects struct {
+	ConfigMap *corev1.ConfigMap
+	PDB       *policyv1.PodDisruptionBudget
+	Pod       *corev1.Pod
+	Job       *batchv1.Job // When set the Pod is created through the Job instead of directly
+
+	// Only set in isolation mode where every job runs in a namespace of its own
+	Namespace     *corev1.Namespace
+	NetworkPolicy *networkingv1.NetworkPolicy
+	ResourceQuota *corev1.ResourceQuota
+	LimitRange    *corev1.LimitRange
+
+	// Only set when egress controls are enabled
+	EgressPolicy *networkingv1.NetworkPolicy
+}
+
+// YAML returns the objects as a multi document YAML stream in the order they are created
+func (o *JobObjects) YAML() ([]byte, error) {
+	configMap := o.ConfigMap.DeepCopy()
+	configMap.TypeMeta = metav1.TypeMeta{APIVersion: "v1", Kind: "ConfigMap"}
+	pdb := o.PDB.DeepCopy()
+	pdb.TypeMeta = metav1.TypeMeta{APIVersion: "policy/v1", Kind: "PodDisruptionBudget"}
+	var objects []any
+	if o.Namespace != nil {
+		namespace := o.Namespace.DeepCopy()
+		namespace.TypeMeta = metav1.TypeMeta{APIVersion: "v1", Kind: "Namespace"}
+		networkPolicy := o.NetworkPolicy.DeepCopy()
+		networkPolicy.TypeMeta = metav1.TypeMeta{APIVersion: "networking.k8s.io/v1", Kind: "NetworkPolicy"}
+		objects = append(objects, namespace, networkPolicy)
+	}
+	if o.ResourceQuota != nil {
+		resourceQuota := o.ResourceQuota.DeepCopy()
+		resourceQuota.TypeMeta = metav1.TypeMeta{APIVersion: "v1", Kind: "ResourceQuota"}
+		objects = append(objects, resourceQuota)
+	}
+	if o.LimitRange != nil {
+		limitRange := o.LimitRange.DeepCopy()
+		limitRange.TypeMeta = metav1.TypeMeta{APIVersion: "v1", Kind: "LimitRange"}
+		objects = append(objects, limitRange)
+	}
+	if o.EgressPolicy != nil {
+		egressPolicy := o.EgressPolicy.DeepCopy()
+		egressPolicy.TypeMeta = metav1.TypeMeta{APIVersion: "networking.k8s.io/v1", Kind: "NetworkPolicy"}
+		objects = append(objects, egressPolicy)
+	}
+	objects = append(objects, configMap, pdb)
+	if o.Job != nil {
+		job := o.Job.DeepCopy()
+		job.TypeMeta = metav1.TypeMeta{APIVersion: "batch/v1", Kind: "Job"}
+		objects = append(objects, job)
+	} else {
+		pod := o.Pod.DeepCopy()
+		pod.TypeMeta = metav1.TypeMeta{APIVersion: "v1", Kind: "Pod"}
+		objects = append(objects, pod)
+	}
+
+	var documents [][]byte
+	for _, object := range objects {
+		data, err := json.Marshal(object)
+		if err != nil {
+			return nil, err
+		}
+		// Status is owned by the cluster so it is dropped to keep the manifests applyable
+		manifest := map[string]any{}
+		if err := json.Unmarshal(data, &manifest); err != nil {
+			return nil, err
+		}
+		delete(manifest, "status")
+		document, err := yaml.Marshal(manifest)
+		if err != nil {
+			return nil, err
+		}
+		documents = append(documents, document)
+	}
+	return bytes.Join(documents, []byte("---\n")), nil
 }
 
 func GetSharedK8sClient() (*rest.Config, *kubernetes.Clientset, error) {
@@ -98,17 +186,26 @@ func NewJobRunner(runnerId string, path string) *JobRunner {
 	}
 	// kubernetes.Clientset is thread-safe and designed to be shared across goroutines
 	config, client, _ := GetSharedK8sClient() // Already validated by LoadK8SClient
-	pod, err := ReadPodConfig(path)
+	runner, err := NewOfflineJobRunner(runnerId, path)
 	if err != nil {
 		panic(err)
 	}
+	runner.config = config
+	runner.clientset = client
+	return runner
+}
+
+// NewOfflineJobRunner returns a JobRunner without a kubernetes client which can only be used to Render jobs
+func NewOfflineJobRunner(runnerId string, path string) (*JobRunner, error) {
+	pod, err := ReadPodConfig(path)
+	if err != nil {
+		return nil, err
+	}
 	return &JobRunner{
 		runnerId:  runnerId,
 		logger:    log.With().Str("runner", runnerId).Logger(),
-		config:    config,
-		clientset: client,
 		podConfig: pod,
-	}
+	}, nil
 }
 
 // getPodEnv returns the env vars to inject into a container for the given
@@ -226,7 +323,7 @@ func (s *JobRunner) getPodObject(identifier string, labels map[string]string, jo
 		initContainers = append(initContainers, s.getInitContainer(job, containerSecurityContext))
 	}
 
-	return &corev1.Pod{
+	pod := &corev1.Pod{
 		ObjectMeta: metav1.ObjectMeta{
 			Name:        identifier,
 			Namespace:   s.podConfig.Namespace,
@@ -248,7 +345,7 @@ func (s *JobRunner) getPodObject(identifier string, labels map[string]string, jo
 					Command: []string{
 						"/bin/sh",
 						"-c",
-						fmt.Sprintf("sleep %d", s.podConfig.Lifetime),
+						fmt.Sprintf("sleep %d", s.podConfig.getPodMaxLifetime()),
 					},
 					Resources:       s.podConfig.Resources,
 					Env:             s.getPodEnv(job.Variables, opslevel.RunnerJobVariableScopeMain),
@@ -299,6 +396,17 @@ func (s *JobRunner) getPodObject(identifier string, labels map[string]string, jo
 			},
 		},
 	}
+	s.addCacheVolume(pod, job)
+	s.addExecVolume(pod)
+	s.setLogsModeCommand(pod, s.getJobScript(job))
+	return pod
+}
+
+// getJobScript assembles the script that runs job.Commands in the job's working directory
+func (s *JobRunner) getJobScript(job opslevel.RunnerJob) string {
+	workingDirectory := path.Join(s.podConfig.WorkingDir, string(job.Id))
+	commands := append([]string{fmt.Sprintf("mkdir -p %s", workingDirectory), fmt.Sprintf("cd %s", workingDirectory), "set -xv"}, job.Commands...)
+	return strings.Join(commands, ";\n")
 }
 
 // getInitContainer assembles a container that runs job.InitCommands before the
@@ -348,9 +456,8 @@ func (s *JobRunner) getInitContainer(job opslevel.RunnerJob, securityContext *co
 	}
 }
 
-// TODO: Remove all usages of "Viper" they should be passed in at JobRunner configuration time
-func (s *JobRunner) Run(ctx context.Context, job opslevel.RunnerJob, stdout, stderr *SafeBuffer) JobOutcome {
-	id := string(job.Id)
+// getIdentifier returns the name shared by every kubernetes object created for the job
+func (s *JobRunner) getIdentifier(job opslevel.RunnerJob) string {
 	// Once we get off "the old API" method of runner we can circle back around to this
 	// and fix it to generate safe pod names since k8s has limitations.
 	var identifier string
@@ -360,29 +467,112 @@ func (s *JobRunner) Run(ctx context.Context, job opslevel.RunnerJob, stdout, std
 	case "api":
 		identifier = fmt.Sprintf("opslevel-job-%s-%d", job.Number(), time.Now().Unix())
 	}
+	return identifier
+}
+
+func (s *JobRunner) getLabels(identifier string) map[string]string {
 	runnerIdentifier := fmt.Sprintf("runner-%s", s.runnerId)
-	labels := map[string]string{
+	return map[string]string{
 		"app.kubernetes.io/instance":   identifier,
 		"app.kubernetes.io/managed-by": runnerIdentifier,
 	}
+}
+
+// getJobObjects assembles every kubernetes object Run creates for the job
+func (s *JobRunner) getJobObjects(identifier string, job opslevel.RunnerJob) (*JobObjects, error) {
+	labels := s.getLabels(identifier)
 	labelSelector, err := CreateLabelSelector(labels)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create label selector REASON: %s", err)
+	}
+	pod := s.getPodObject(identifier, labels, job)
+	objects := &JobObjects{
+		ConfigMap: s.getConfigMapObject(identifier, job),
+		PDB:       s.getPBDObject(identifier, labelSelector),
+		Pod:       pod,
+		Job:       s.getBatchJobObject(pod),
+	}
+	s.setIsolationObjects(objects, identifier, labels)
+	if err := s.setEgressObjects(objects, identifier, labels, job); err != nil {
+		return nil, err
+	}
+	return objects, nil
+}
+
+// Render returns the YAML manifests of the kubernetes objects Run would create for the job
+// without contacting the cluster
+func (s *JobRunner) Render(job opslevel.RunnerJob) ([]byte, error) {
+	objects, err := s.getJobObjects(s.getIdentifier(job), job)
+	if err != nil {
+		return nil, err
+	}
+	return objects.YAML()
+}
+
+// TODO: Remove all usages of "Viper" they should be passed in at JobRunner configuration time
+func (s *JobRunner) Run(ctx context.Context, job opslevel.RunnerJob, stdout, stderr io.Writer) JobOutcome {
+	unlockCache, locked := s.lockCache(job)
+	if locked {
+		defer unlockCache()
+	} else {
+		s.logger.Warn().Msgf("Cache '%s' is in use by another job, running job '%s' without it", getCacheKey(job), job.Number())
+		job = withoutCache(job)
+	}
+	objects, err := s.getJobObjects(s.getIdentifier(job), job)
 	if err != nil {
 		return JobOutcome{
-			Message: fmt.Sprintf("failed to create label selector REASON: %s", err),
+			Message: err.Error(),
 			Outcome: opslevel.RunnerJobOutcomeEnumFailed,
 		}
 	}
+	s.touchCache(job)
+	timeout := time.Second * time.Duration(viper.GetInt("job-pod-max-wait"))
+	var hold *DebugHold // Set when the objects of a failed job are kept for debugging instead of deleted
+	var namespace *corev1.Namespace
+	if objects.Namespace != nil {
+		namespace, err = s.CreateIsolation(ctx, objects, s.getNamespaceExpiry(time.Now(), timeout))
+		defer func() {
+			if hold == nil {
+				s.DeleteNamespace(context.Background(), namespace) // Use Background for cleanup to ensure it completes
+			}
+		}()
+		if err != nil {
+			return JobOutcome{
+				Message: fmt.Sprintf("failed to create job namespace REASON: %s", err),
+				Outcome: opslevel.RunnerJobOutcomeEnumFailed,
+			}
+		}
+	}
+	var egressPolicy *networkingv1.NetworkPolicy
+	if objects.EgressPolicy != nil {
+		egressPolicy, err = s.CreateNetworkPolicy(ctx, objects.EgressPolicy)
+		defer func() {
+			if hold == nil {
+				s.DeleteNetworkPolicy(context.Background(), egressPolicy) // Use Background for cleanup to ensure it completes
+			}
+		}()
+		if err != nil {
+			return JobOutcome{
+				Message: fmt.Sprintf("failed to create egress network policy REASON: %s", err),
+				Outcome: opslevel.RunnerJobOutcomeEnumFailed,
+			}
+		}
+	}
 	// TODO: manage pods based on image for re-use?
-	cfgMap, err := s.CreateConfigMap(ctx, s.getConfigMapObject(identifier, job))
+	cfgMap, err := s.CreateConfigMap(ctx, objects.ConfigMap)
 	if err != nil {
 		return JobOutcome{
 			Message: fmt.Sprintf("failed to create configmap REASON: %s", err),
 			Outcome: opslevel.RunnerJobOutcomeEnumFailed,
 		}
 	}
-	defer s.DeleteConfigMap(context.Background(), cfgMap) // Use Background for cleanup to ensure it completes
+	defer func() {
+		if hold == nil {
+			s.DeleteConfigMap(context.Background(), cfgMap) // Use Background for cleanup to ensure it completes
+		}
+	}()
 
-	pdb, err := s.CreatePDB(ctx, s.getPBDObject(identifier, labelSelector))
+	pdb, err := s.CreatePDB(ctx, objects.PDB)
 	if err != nil {
 		return JobOutcome{
 			Message: fmt.Sprintf("failed to create pod disruption budget REASON: %s", err),
@@ -391,17 +581,62 @@ func (s *JobRunner) Run(ctx context.Context, job opslevel.RunnerJob, stdout, std
 	}
 	defer s.DeletePDB(context.Background(), pdb) // Use Background for cleanup to ensure it completes
 
-	pod, err := s.CreatePod(ctx, s.getPodObject(identifier, labels, job))
-	if err != nil {
+	var pod *corev1.Pod
+	var batchJob *batchv1.Job
+	if objects.Job != nil {
+		batchJob, err = s.CreateJob(ctx, objects.Job)
+		if err != nil {
+			return JobOutcome{
+				Message: fmt.Sprintf("failed to create job REASON: %s", err),
+				Outcome: opslevel.RunnerJobOutcomeEnumFailed,
+			}
+		}
+		defer func() {
+			if hold == nil {
+				s.DeleteJob(context.Background(), batchJob) // Use Background for cleanup to ensure it completes
+			}
+		}()
+
+		pod, err = s.GetJobPod(ctx, batchJob, timeout)
+		if err != nil {
+			return JobOutcome{
+				Message: fmt.Sprintf("job's pod was not created REASON: %s", err),
+				Outcome: opslevel.RunnerJobOutcomeEnumPodTimeout,
+			}
+		}
+	} else {
+		pod, err = s.CreatePod(ctx, objects.Pod)
+		if err != nil {
+			return JobOutcome{
+				Message: fmt.Sprintf("failed to create pod REASON: %s", err),
+				Outcome: opslevel.RunnerJobOutcomeEnumFailed,
+			}
+		}
+		defer func() {
+			if hold == nil {
+				s.DeletePod(context.Background(), pod) // Use Background for cleanup to ensure it completes
+			}
+		}()
+	}
+
+	output := newHoldMarkerWriter(stdout)
+	errorOutput := &tailWriter{max: maxOutcomeStderrBytes}
+	stderr = io.MultiWriter(stderr, errorOutput)
+	setLogStep(stdout, LogStepInit)
+	waitForInitOutput := s.followInitContainer(ctx, pod, output)
+	waitErr := s.WaitForPod(ctx, pod, timeout)
+	waitForInitOutput(waitErr)
+	if err := output.Flush(); err != nil {
+		s.logger.Warn().Err(err).Msg("failed to write the output of the init commands")
+	}
+	setLogStep(stdout, LogStepMain)
+	var initErr *InitContainerError
+	if errors.As(waitErr, &initErr) {
 		return JobOutcome{
-			Message: fmt.Sprintf("failed to create pod REASON: %s", err),
+			Message: fmt.Sprintf("pod failed to start REASON: %s", initErr),
 			Outcome: opslevel.RunnerJobOutcomeEnumFailed,
 		}
 	}
-	defer s.DeletePod(context.Background(), pod) // Use Background for cleanup to ensure it completes
-
-	timeout := time.Second * time.Duration(viper.GetInt("job-pod-max-wait"))
-	waitErr := s.WaitForPod(ctx, pod, timeout)
 	if waitErr != nil {
 		// TODO: get pod status or status message?
 		return JobOutcome{
@@ -410,20 +645,87 @@ func (s *JobRunner) Run(ctx context.Context, job opslevel.RunnerJob, stdout, std
 		}
 	}
 
-	workingDirectory := path.Join(s.podConfig.WorkingDir, id)
-	commands := append([]string{fmt.Sprintf("mkdir -p %s", workingDirectory), fmt.Sprintf("cd %s", workingDirectory), "set -xv"}, job.Commands...)
-	runErr := s.Exec(ctx, stdout, stderr, pod, pod.Spec.Containers[0].Name, s.podConfig.Shell, "-e", "-c", strings.Join(commands, ";\n"))
+	containerName := pod.Spec.Containers[0].Name
+	sampler := s.startUsageSampler(ctx, pod, containerName)
+	execCtx, stopExec := s.withGracefulCancel(ctx, pod, containerName)
+	var runErr error
+	switch s.podConfig.ExecMode {
+	case ExecModeLogs:
+		runErr = s.RunWithLogs(execCtx, output, pod, containerName)
+	case ExecModeResumable:
+		runErr = s.ExecResumable(execCtx, output, stderr, pod, containerName, s.getJobScript(job))
+	default:
+		runErr = s.Exec(execCtx, output, stderr, pod, containerName, getStreamCommand(s.podConfig.Shell, s.getJobScript(job), s.getJobPidFile())...)
+	}
+	stopExec()
+	if runErr != nil && ctx.Err() != nil {
+		runErr = fmt.Errorf("job was cancelled: %w", runErr)
+	}
+	if err := output.Flush(); err != nil {
+		s.logger.Warn().Err(err).Msg("failed to write the output of the job's commands")
+	}
+	usage := s.collectResourceUsage(ctx, pod, containerName, sampler)
+	s.logger.Info().Msgf("Job used %s", usage)
+	usage.observe()
+
 	if runErr != nil {
-		return JobOutcome{
-			Message: fmt.Sprintf("pod execution failed REASON: %s %s", strings.TrimSuffix(stderr.String(), "\n"), runErr),
+		outcome := usage.Apply(JobOutcome{
+			Message: fmt.Sprintf("pod execution failed REASON: %s %s", errorOutput, runErr),
 			Outcome: opslevel.RunnerJobOutcomeEnumFailed,
+		})
+		// A cancelled job isn't held, whoever cancelled it doesn't want to debug it
+		if reason := s.getDebugHoldReason(job, output); reason != "" && ctx.Err() == nil {
+			candidate := &DebugHold{
+				Namespace:    namespace,
+				EgressPolicy: egressPolicy,
+				ConfigMap:    cfgMap,
+				Job:          batchJob,
+				Pod:          pod,
+				Reason:       reason,
+				ExpiresAt:    time.Now().Add(time.Duration(s.podConfig.DebugHoldDuration) * time.Second),
+				Shell:        s.podConfig.Shell,
+			}
+			if err := s.HoldForDebugging(context.Background(), candidate); err != nil {
+				s.logger.Error().Err(err).Msg("unable to hold the job pod for debugging")
+			} else {
+				hold = candidate
+				_, _ = io.WriteString(stdout, hold.Instructions())
+				outcome.Message = fmt.Sprintf("%s (pod %s/%s held for debugging until %s)", outcome.Message, pod.Namespace, pod.Name, hold.ExpiresAt.UTC().Format(time.RFC3339))
+			}
 		}
+		return outcome
 	}
 
-	return JobOutcome{
+	return usage.Apply(JobOutcome{
 		Message: "",
 		Outcome: opslevel.RunnerJobOutcomeEnumSuccess,
+	})
+}
+
+// maxOutcomeStderrBytes bounds how much of the end of the commands' stderr a failed job's outcome message includes
+const maxOutcomeStderrBytes = 4096
+
+// tailWriter is a goroutine safe writer that keeps the last max bytes written to it
+type tailWriter struct {
+	mutex sync.Mutex
+	max   int
+	tail  []byte
+}
+
+func (w *tailWriter) Write(p []byte) (int, error) {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	w.tail = append(w.tail, p...)
+	if len(w.tail) > w.max {
+		w.tail = append(w.tail[:0], w.tail[len(w.tail)-w.max:]...)
 	}
+	return len(p), nil
+}
+
+func (w *tailWriter) String() string {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	return strings.TrimSuffix(string(w.tail), "\n")
 }
 
 func CreateLabelSelector(labels map[string]string) (*metav1.LabelSelector, error) {
@@ -465,11 +767,7 @@ func (s *JobRunner) ExecWithConfig(ctx context.Context, config JobConfig) error
 	}, scheme.ParameterCodec)
 	s.logger.Debug().Msgf("Execing pod %s/%s ...", config.Namespace, config.PodName)
 	s.logger.Trace().Msgf("ExecWithOptions: execute(POST %s)", req.URL())
-	exec, err := remotecommand.NewSPDYExecutor(s.config, "POST", req.URL())
-	if err != nil {
-		return err
-	}
-	return exec.StreamWithContext(ctx, remotecommand.StreamOptions{
+	return s.streamExec(ctx, req.URL(), remotecommand.StreamOptions{
 		Stdin:  config.Stdin,
 		Stdout: config.Stdout,
 		Stderr: config.Stderr,
@@ -477,7 +775,7 @@ func (s *JobRunner) ExecWithConfig(ctx context.Context, config JobConfig) error
 	})
 }
 
-func (s *JobRunner) Exec(ctx context.Context, stdout, stderr *SafeBuffer, pod *corev1.Pod, containerName string, cmd ...string) error {
+func (s *JobRunner) Exec(ctx context.Context, stdout, stderr io.Writer, pod *corev1.Pod, containerName string, cmd ...string) error {
 	return s.ExecWithConfig(ctx, JobConfig{
 		Command:       cmd,
 		Namespace:     pod.Namespace,
@@ -510,10 +808,17 @@ func (s *JobRunner) isPodInDesiredState(podConfig *corev1.Pod) wait.ConditionWit
 		if err != nil {
 			return false, err
 		}
+		if err := getInitContainerError(pod); err != nil {
+			return false, err
+		}
 		switch pod.Status.Phase {
 		case corev1.PodRunning:
 			return true, nil
 		case corev1.PodFailed, corev1.PodSucceeded:
+			if s.podConfig.ExecMode == ExecModeLogs {
+				// The job's commands are the container's command so it may finish before we see it running
+				return true, nil
+			}
 			return false, fmt.Errorf("pod ran to completion")
 		}
 		return false, nil
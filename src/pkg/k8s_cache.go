@@ -0,0 +1,291 @@
+package pkg
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/opslevel/opslevel-go/v2026"
+	"github.com/rs/zerolog/log"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+)
+
+const (
+	// CacheKeyVariable is the job variable a job uses to opt into a persistent cache volume
+	CacheKeyVariable = "OPSLEVEL_CACHE_KEY"
+	// CacheDirVariable is the env var that tells the job where its cache is mounted
+	CacheDirVariable = "OPSLEVEL_CACHE_DIR"
+	cacheVolumeName  = "cache"
+	// cacheLockExtension is the suffix of the file next to a cache directory that a job holds while it has the cache mounted
+	cacheLockExtension = ".lock"
+)
+
+var (
+	// cacheLocks are the caches the jobs of this runner have mounted
+	cacheLocks      = map[string]bool{}
+	cacheLocksMutex sync.Mutex
+)
+
+// CacheConfig configures the persistent cache volumes shared across jobs. Each cache key
+// gets its own directory on the claim which is mounted into the job at MountPath.
+type CacheConfig struct {
+	ClaimName        string            `yaml:"claimName"` // caching is disabled when empty
+	MountPath        string            `yaml:"mountPath"`
+	LocalPath        string            `yaml:"localPath"` // where the runner has the same claim mounted, eviction is disabled when empty
+	MaxSize          resource.Quantity `yaml:"maxSize"`
+	MaxAge           int               `yaml:"maxAge"`           // in seconds
+	EvictionInterval int               `yaml:"evictionInterval"` // in seconds
+}
+
+func (c *CacheConfig) Enabled() bool {
+	return c.ClaimName != ""
+}
+
+// getCacheKey returns the cache key the job supplied or an empty string if it didn't
+func getCacheKey(job opslevel.RunnerJob) string {
+	for _, variable := range job.Variables {
+		if variable.Key == CacheKeyVariable {
+			return variable.Value
+		}
+	}
+	return ""
+}
+
+// cacheDirectory maps a cache key to the directory that holds it on the cache claim.
+// Keys are hashed because they are arbitrary strings supplied by the job.
+func cacheDirectory(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:16])
+}
+
+// addCacheVolume mounts the job's cache directory into the init and job containers
+func (s *JobRunner) addCacheVolume(pod *corev1.Pod, job opslevel.RunnerJob) {
+	key := getCacheKey(job)
+	if !s.podConfig.Cache.Enabled() || key == "" {
+		return
+	}
+	pod.Spec.Volumes = append(pod.Spec.Volumes, corev1.Volume{
+		Name: cacheVolumeName,
+		VolumeSource: corev1.VolumeSource{
+			PersistentVolumeClaim: &corev1.PersistentVolumeClaimVolumeSource{
+				ClaimName: s.podConfig.Cache.ClaimName,
+			},
+		},
+	})
+	mount := corev1.VolumeMount{
+		Name:      cacheVolumeName,
+		ReadOnly:  false,
+		MountPath: s.podConfig.Cache.MountPath,
+		SubPath:   cacheDirectory(key),
+	}
+	env := corev1.EnvVar{
+		Name:  CacheDirVariable,
+		Value: s.podConfig.Cache.MountPath,
+	}
+	for i, container := range pod.Spec.InitContainers {
+		if container.Name == ContainerNameInit {
+			pod.Spec.InitContainers[i].VolumeMounts = append(pod.Spec.InitContainers[i].VolumeMounts, mount)
+			pod.Spec.InitContainers[i].Env = append(pod.Spec.InitContainers[i].Env, env)
+		}
+	}
+	for i := range pod.Spec.Containers {
+		pod.Spec.Containers[i].VolumeMounts = append(pod.Spec.Containers[i].VolumeMounts, mount)
+		pod.Spec.Containers[i].Env = append(pod.Spec.Containers[i].Env, env)
+	}
+}
+
+// touchCache marks the job's cache as used so it is the last to be evicted
+func (s *JobRunner) touchCache(job opslevel.RunnerJob) {
+	key := getCacheKey(job)
+	if !s.podConfig.Cache.Enabled() || s.podConfig.Cache.LocalPath == "" || key == "" {
+		return
+	}
+	directory := filepath.Join(s.podConfig.Cache.LocalPath, cacheDirectory(key))
+	if err := os.MkdirAll(directory, 0o777); err != nil {
+		s.logger.Warn().Err(err).Msgf("unable to create cache directory '%s'", directory)
+		return
+	}
+	now := time.Now()
+	if err := os.Chtimes(directory, now, now); err != nil {
+		s.logger.Warn().Err(err).Msgf("unable to touch cache directory '%s'", directory)
+	}
+}
+
+// lockCache takes the job's cache so that only one job at a time writes to it. The lock is held in
+// memory and, when the runner has the claim mounted at LocalPath, in a lock file on the claim so jobs
+// of other runners see it too. A lock file older than the pod lifetime is left behind by a runner that
+// died and is taken over. It returns false when another job holds the cache.
+func (s *JobRunner) lockCache(job opslevel.RunnerJob) (func(), bool) {
+	key := getCacheKey(job)
+	if !s.podConfig.Cache.Enabled() || key == "" {
+		return func() {}, true
+	}
+	directory := cacheDirectory(key)
+	cacheLocksMutex.Lock()
+	defer cacheLocksMutex.Unlock()
+	if cacheLocks[directory] {
+		return nil, false
+	}
+	lockFile := ""
+	if s.podConfig.Cache.LocalPath != "" {
+		lockFile = filepath.Join(s.podConfig.Cache.LocalPath, directory+cacheLockExtension)
+		stale := time.Duration(s.podConfig.getPodMaxLifetime()) * time.Second
+		if err := createLockFile(lockFile, string(job.Id), stale); err != nil {
+			if !errors.Is(err, fs.ErrExist) {
+				s.logger.Warn().Err(err).Msgf("unable to lock cache '%s'", lockFile)
+			}
+			return nil, false
+		}
+	}
+	cacheLocks[directory] = true
+	return func() {
+		cacheLocksMutex.Lock()
+		defer cacheLocksMutex.Unlock()
+		delete(cacheLocks, directory)
+		if lockFile != "" {
+			if err := os.Remove(lockFile); err != nil && !os.IsNotExist(err) {
+				s.logger.Warn().Err(err).Msgf("unable to unlock cache '%s'", lockFile)
+			}
+		}
+	}, true
+}
+
+// createLockFile creates the lock file unless it exists and isn't older than stale
+func createLockFile(path string, owner string, stale time.Duration) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0o777); err != nil {
+		return err
+	}
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0o666)
+	if errors.Is(err, fs.ErrExist) {
+		info, statErr := os.Stat(path)
+		if statErr != nil || time.Since(info.ModTime()) < stale {
+			return err
+		}
+		if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		file, err = os.OpenFile(path, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0o666)
+	}
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintln(file, owner)
+	return errors.Join(err, file.Close())
+}
+
+// withoutCache returns a copy of the job that doesn't mount its cache
+func withoutCache(job opslevel.RunnerJob) opslevel.RunnerJob {
+	job.Variables = slices.DeleteFunc(slices.Clone(job.Variables), func(variable opslevel.RunnerJobVariable) bool {
+		return variable.Key == CacheKeyVariable
+	})
+	return job
+}
+
+type cacheEntry struct {
+	path     string
+	size     int64
+	lastUsed time.Time
+}
+
+func getCacheEntries(root string) ([]cacheEntry, error) {
+	dirEntries, err := os.ReadDir(root)
+	if err != nil {
+		return nil, err
+	}
+	var entries []cacheEntry
+	for _, dirEntry := range dirEntries {
+		if !dirEntry.IsDir() {
+			continue
+		}
+		info, err := dirEntry.Info()
+		if err != nil {
+			continue
+		}
+		entry := cacheEntry{path: filepath.Join(root, dirEntry.Name()), lastUsed: info.ModTime()}
+		_ = filepath.WalkDir(entry.path, func(_ string, d fs.DirEntry, err error) error {
+			if err != nil || d.IsDir() {
+				return nil
+			}
+			if fileInfo, err := d.Info(); err == nil {
+				entry.size += fileInfo.Size()
+			}
+			return nil
+		})
+		entries = append(entries, entry)
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].lastUsed.Before(entries[j].lastUsed) })
+	return entries, nil
+}
+
+// evictCache removes caches that have not been used for MaxAge and then the least recently
+// used caches until the total size is below MaxSize. Caches used within the last 'inUse'
+// are never evicted because a running job may still have them mounted.
+func evictCache(config CacheConfig, inUse time.Duration, now time.Time) ([]string, int64, error) {
+	entries, err := getCacheEntries(config.LocalPath)
+	if err != nil {
+		return nil, 0, err
+	}
+	var total int64
+	for _, entry := range entries {
+		total += entry.size
+	}
+	maxSize := config.MaxSize.Value()
+	var evicted []string
+	for _, entry := range entries {
+		if now.Sub(entry.lastUsed) < inUse {
+			continue
+		}
+		expired := config.MaxAge > 0 && now.Sub(entry.lastUsed) > time.Duration(config.MaxAge)*time.Second
+		oversized := maxSize > 0 && total > maxSize
+		if !expired && !oversized {
+			continue
+		}
+		if err := os.RemoveAll(entry.path); err != nil {
+			return evicted, total, err
+		}
+		total -= entry.size
+		evicted = append(evicted, entry.path)
+	}
+	return evicted, total, nil
+}
+
+// RunCacheEviction periodically evicts caches from the runner's local mount of the cache claim
+func RunCacheEviction(ctx context.Context, config *K8SPodConfig) {
+	if !config.Cache.Enabled() || config.Cache.LocalPath == "" {
+		return
+	}
+	logger := log.With().Str("worker", "cache").Logger()
+	inUse := time.Duration(config.Lifetime) * time.Second
+	ticker := time.NewTicker(time.Duration(max(config.Cache.EvictionInterval, 1)) * time.Second)
+	defer ticker.Stop()
+	logger.Info().Msgf("Starting cache eviction for '%s' ...", config.Cache.LocalPath)
+	for {
+		select {
+		case <-ctx.Done():
+			logger.Info().Msg("Stopping cache eviction ...")
+			return
+		case <-ticker.C:
+			evicted, size, err := evictCache(config.Cache, inUse, time.Now())
+			if err != nil {
+				logger.Error().Err(err).Msg("failed to evict caches")
+			}
+			for _, path := range evicted {
+				logger.Info().Msgf("Evicted cache '%s'", path)
+			}
+			if MetricCacheEvictions != nil {
+				MetricCacheEvictions.Add(float64(len(evicted)))
+				MetricCacheSize.Set(float64(size))
+			}
+		}
+	}
+}
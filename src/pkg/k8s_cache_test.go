@@ -0,0 +1,178 @@
+package pkg
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/opslevel/opslevel-go/v2026"
+	"github.com/rocktavious/autopilot/v2023"
+	"github.com/rs/zerolog"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+)
+
+func getCacheRunner(cache CacheConfig) *JobRunner {
+	return &JobRunner{
+		logger: zerolog.Nop(),
+		podConfig: &K8SPodConfig{
+			Namespace: "test", WorkingDir: "/workdir", Shell: "/bin/sh",
+			SecurityContext: corev1.PodSecurityContext{}, TerminationGracePeriodSeconds: 30,
+			Cache: cache,
+		},
+	}
+}
+
+func findVolumeMount(mounts []corev1.VolumeMount, name string) *corev1.VolumeMount {
+	for i := range mounts {
+		if mounts[i].Name == name {
+			return &mounts[i]
+		}
+	}
+	return nil
+}
+
+func writeCacheEntry(t *testing.T, root, name string, size int, lastUsed time.Time) string {
+	t.Helper()
+	directory := filepath.Join(root, name)
+	autopilot.Ok(t, os.MkdirAll(directory, 0o755))
+	autopilot.Ok(t, os.WriteFile(filepath.Join(directory, "data"), make([]byte, size), 0o600))
+	autopilot.Ok(t, os.Chtimes(directory, lastUsed, lastUsed))
+	return directory
+}
+
+func TestGetPodObject_CacheVolume(t *testing.T) {
+	// Arrange
+	runner := getCacheRunner(CacheConfig{ClaimName: "runner-cache", MountPath: "/cache"})
+	job := opslevel.RunnerJob{
+		Image:        "alpine:latest",
+		InitCommands: []string{"/opslevel/clone-repo ."},
+		Variables: []opslevel.RunnerJobVariable{
+			{Key: CacheKeyVariable, Value: "github.com/opslevel/opslevel-runner"},
+		},
+	}
+	// Act
+	pod := runner.getPodObject("test-pod", map[string]string{}, job)
+	// Assert
+	var claimName string
+	for _, volume := range pod.Spec.Volumes {
+		if volume.Name == cacheVolumeName {
+			claimName = volume.PersistentVolumeClaim.ClaimName
+		}
+	}
+	autopilot.Equals(t, "runner-cache", claimName)
+	autopilot.Assert(t, findVolumeMount(pod.Spec.InitContainers[0].VolumeMounts, cacheVolumeName) == nil, "helper: should not mount the cache")
+	for _, container := range []corev1.Container{pod.Spec.InitContainers[1], pod.Spec.Containers[0]} {
+		mount := findVolumeMount(container.VolumeMounts, cacheVolumeName)
+		autopilot.Assert(t, mount != nil, "%s: should mount the cache", container.Name)
+		autopilot.Equals(t, "/cache", mount.MountPath)
+		autopilot.Equals(t, cacheDirectory("github.com/opslevel/opslevel-runner"), mount.SubPath)
+		autopilot.Equals(t, corev1.EnvVar{Name: CacheDirVariable, Value: "/cache"}, container.Env[len(container.Env)-1])
+	}
+}
+
+func TestGetPodObject_CacheVolumeWithoutKey(t *testing.T) {
+	// Arrange
+	runner := getCacheRunner(CacheConfig{ClaimName: "runner-cache", MountPath: "/cache"})
+	job := opslevel.RunnerJob{Image: "alpine:latest"}
+	// Act
+	pod := runner.getPodObject("test-pod", map[string]string{}, job)
+	// Assert
+	autopilot.Assert(t, findVolumeMount(pod.Spec.Containers[0].VolumeMounts, cacheVolumeName) == nil, "job: should not mount the cache without a cache key")
+	autopilot.Equals(t, 3, len(pod.Spec.Volumes))
+}
+
+func TestCacheDirectory_Stable(t *testing.T) {
+	// Act
+	first := cacheDirectory("../../etc")
+	second := cacheDirectory("../../etc")
+	// Assert
+	autopilot.Equals(t, first, second)
+	autopilot.Equals(t, 32, len(first))
+	autopilot.Assert(t, first != cacheDirectory("other"), "different keys should map to different directories")
+}
+
+func TestEvictCache_MaxAge(t *testing.T) {
+	// Arrange
+	now := time.Now()
+	root := t.TempDir()
+	stale := writeCacheEntry(t, root, "stale", 10, now.Add(-48*time.Hour))
+	fresh := writeCacheEntry(t, root, "fresh", 10, now.Add(-2*time.Hour))
+	config := CacheConfig{LocalPath: root, MaxAge: 24 * 60 * 60}
+	// Act
+	evicted, size, err := evictCache(config, time.Hour, now)
+	// Assert
+	autopilot.Ok(t, err)
+	autopilot.Equals(t, []string{stale}, evicted)
+	autopilot.Equals(t, int64(10), size)
+	_, err = os.Stat(fresh)
+	autopilot.Ok(t, err)
+}
+
+func TestEvictCache_MaxSize(t *testing.T) {
+	// Arrange
+	now := time.Now()
+	root := t.TempDir()
+	oldest := writeCacheEntry(t, root, "oldest", 100, now.Add(-4*time.Hour))
+	older := writeCacheEntry(t, root, "older", 100, now.Add(-3*time.Hour))
+	writeCacheEntry(t, root, "newer", 100, now.Add(-2*time.Hour))
+	writeCacheEntry(t, root, "in-use", 100, now.Add(-time.Minute))
+	config := CacheConfig{LocalPath: root, MaxSize: resource.MustParse("150")}
+	// Act
+	evicted, size, err := evictCache(config, time.Hour, now)
+	// Assert: the in use cache is kept even though the total is still above the max size
+	autopilot.Ok(t, err)
+	autopilot.Equals(t, []string{oldest, older, filepath.Join(root, "newer")}, evicted)
+	autopilot.Equals(t, int64(100), size)
+}
+
+func TestLockCache(t *testing.T) {
+	// Arrange
+	localPath := t.TempDir()
+	runner := getCacheRunner(CacheConfig{ClaimName: "runner-cache", MountPath: "/cache", LocalPath: localPath})
+	runner.podConfig.Lifetime = 3600
+	job := opslevel.RunnerJob{Id: "1", Variables: []opslevel.RunnerJobVariable{{Key: CacheKeyVariable, Value: "lock"}}}
+	lockFile := filepath.Join(localPath, cacheDirectory("lock")+cacheLockExtension)
+	// Act
+	unlock, locked := runner.lockCache(job)
+	_, lockedTwice := runner.lockCache(job)
+	unlock()
+	autopilot.Ok(t, os.WriteFile(lockFile, []byte("2\n"), 0o600))
+	_, lockedByOtherRunner := runner.lockCache(job)
+	stale := time.Now().Add(-2 * time.Hour)
+	autopilot.Ok(t, os.Chtimes(lockFile, stale, stale))
+	unlockStale, lockedStale := runner.lockCache(job)
+	owner, _ := os.ReadFile(lockFile)
+	unlockStale()
+	_, statErr := os.Stat(lockFile)
+	// Assert
+	autopilot.Equals(t, true, locked)
+	autopilot.Equals(t, false, lockedTwice)
+	autopilot.Equals(t, false, lockedByOtherRunner)
+	autopilot.Equals(t, true, lockedStale)
+	autopilot.Equals(t, "1\n", string(owner))
+	autopilot.Equals(t, true, os.IsNotExist(statErr))
+}
+
+func TestGetPodObject_CacheVolumeWhenLocked(t *testing.T) {
+	// Arrange
+	runner := getCacheRunner(CacheConfig{ClaimName: "runner-cache", MountPath: "/cache"})
+	job := opslevel.RunnerJob{
+		Id:    "1",
+		Image: "alpine:latest",
+		Variables: []opslevel.RunnerJobVariable{
+			{Key: "FOO", Value: "bar"},
+			{Key: CacheKeyVariable, Value: "locked"},
+		},
+	}
+	unlock, _ := runner.lockCache(job)
+	defer unlock()
+	// Act
+	_, locked := runner.lockCache(job)
+	pod := runner.getPodObject("test-pod", map[string]string{}, withoutCache(job))
+	// Assert
+	autopilot.Equals(t, false, locked)
+	autopilot.Assert(t, findVolumeMount(pod.Spec.Containers[0].VolumeMounts, cacheVolumeName) == nil, "job: should not mount the locked cache")
+	autopilot.Equals(t, 2, len(job.Variables))
+}
@@ -0,0 +1,107 @@
+package pkg
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"path"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// jobPidFile is the file the job's process group is recorded in, as the negated group id so that kill
+// signals every process in it, or as the pid of the job's shell when the container has no setsid
+const jobPidFile = "opslevel-job.pid"
+
+// getSessionScript runs the script in $1 in a session of its own so that its processes can be signalled
+// as a group without touching the container's other processes, e.g. sidecars or other exec sessions
+func getSessionScript(shell, pidFile string) string {
+	return fmt.Sprintf(`if command -v setsid > /dev/null; then setsid %[1]s -c 'echo "-$$" > "$2"; exec %[1]s -e -c "$1"' opslevel "$1" %[2]s; `+
+		`else %[1]s -c 'echo "$$" > "$2"; exec %[1]s -e -c "$1"' opslevel "$1" %[2]s; fi`, shell, pidFile)
+}
+
+// getStreamCommand runs the script in a session of its own and waits for it. The session is started in
+// the background because setsid forks when it's already a process group leader, and then doesn't wait.
+func getStreamCommand(shell, script, pidFile string) []string {
+	return []string{shell, "-c", getSessionScript(shell, `"$2"`) + " &\nwait $!", "opslevel", script, pidFile}
+}
+
+// getJobPidFile returns where the job's process group is recorded in the job container
+func (s *JobRunner) getJobPidFile() string {
+	if s.podConfig.ExecMode == ExecModeResumable {
+		return path.Join(execMountPath, jobPidFile)
+	}
+	return path.Join(s.podConfig.WorkingDir, jobPidFile)
+}
+
+// getSignalCommand sends the signal to the job's process group recorded in the pid file, nothing is
+// signalled when the job hasn't started yet
+func getSignalCommand(shell, signal, pidFile string) []string {
+	script := `[ -f "$2" ] || exit 0
+kill -s "$1" -- $(cat "$2") 2> /dev/null
+exit 0`
+	return []string{shell, "-c", script, "opslevel", signal, pidFile}
+}
+
+// signalJob delivers the signal to the job's processes. In logs mode the job's commands are the container's
+// command and exec may not be allowed, so the pod is deleted with the grace period instead which has the
+// kubelet send SIGTERM and then SIGKILL once the grace period is over.
+func (s *JobRunner) signalJob(ctx context.Context, pod *corev1.Pod, containerName string, signal string, gracePeriod time.Duration) {
+	if !s.canExec() {
+		if signal != "TERM" {
+			return
+		}
+		seconds := int64(gracePeriod.Seconds())
+		err := s.clientset.CoreV1().Pods(pod.Namespace).Delete(ctx, pod.Name, metav1.DeleteOptions{GracePeriodSeconds: &seconds})
+		if err != nil {
+			s.logger.Warn().Err(err).Msgf("unable to terminate pod %s/%s", pod.Namespace, pod.Name)
+		}
+		return
+	}
+	var stdout, stderr bytes.Buffer
+	err := s.ExecWithConfig(ctx, JobConfig{
+		Command:       getSignalCommand(s.podConfig.Shell, signal, s.getJobPidFile()),
+		Namespace:     pod.Namespace,
+		PodName:       pod.Name,
+		ContainerName: containerName,
+		Stdout:        &stdout,
+		Stderr:        &stderr,
+	})
+	if err != nil {
+		s.logger.Warn().Err(err).Msgf("unable to send SIG%s to the job's processes: %s", signal, stderr.String())
+	}
+}
+
+// withGracefulCancel returns a context for running the job's commands that outlives ctx. When ctx is
+// cancelled the job's processes are sent SIGTERM and get the cancel grace period to clean up and exit
+// while their output is still streamed, then they are sent SIGKILL and the returned context is cancelled
+// too. The returned cancel function must be called once the job's commands have finished.
+func (s *JobRunner) withGracefulCancel(ctx context.Context, pod *corev1.Pod, containerName string) (context.Context, context.CancelFunc) {
+	execCtx, cancel := context.WithCancel(context.WithoutCancel(ctx))
+	gracePeriod := time.Duration(s.podConfig.CancelGracePeriod) * time.Second
+	go func() {
+		select {
+		case <-execCtx.Done():
+			return
+		case <-ctx.Done():
+		}
+		s.logger.Info().Msgf("Job was cancelled, sending SIGTERM to its processes and waiting up to %s for them to exit ...", gracePeriod)
+		s.signalJob(execCtx, pod, containerName, "TERM", gracePeriod)
+		select {
+		case <-execCtx.Done():
+			return
+		case <-time.After(gracePeriod):
+		}
+		s.logger.Warn().Msg("Job's processes did not exit within the cancel grace period, sending SIGKILL ...")
+		s.signalJob(execCtx, pod, containerName, "KILL", gracePeriod)
+		// Give the output streams a moment to deliver the last of the output
+		select {
+		case <-execCtx.Done():
+		case <-time.After(5 * time.Second):
+		}
+		cancel()
+	}()
+	return execCtx, cancel
+}
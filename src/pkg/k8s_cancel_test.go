@@ -0,0 +1,113 @@
+package pkg
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/rocktavious/autopilot/v2023"
+	"github.com/rs/zerolog"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+)
+
+func waitForFile(t *testing.T, file string) {
+	t.Helper()
+	for range 50 {
+		if _, err := os.Stat(file); err == nil {
+			return
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+	t.Fatalf("%s was not written", file)
+}
+
+func TestSignalCommand_SignalsOnlyTheJobsProcessGroup(t *testing.T) {
+	// Arrange
+	pidFile := filepath.Join(t.TempDir(), jobPidFile)
+	bystander := exec.Command("sleep", "30")
+	autopilot.Ok(t, bystander.Start())
+	defer func() { _ = bystander.Process.Kill() }()
+	command := getStreamCommand("/bin/sh", "trap 'echo cleaned up; exit 3' TERM; sleep 30 & wait", pidFile)
+	var stdout bytes.Buffer
+	job := exec.Command(command[0], command[1:]...)
+	job.Stdout = &stdout
+	autopilot.Ok(t, job.Start())
+	waitForFile(t, pidFile)
+	signal := getSignalCommand("/bin/sh", "TERM", pidFile)
+	// Act
+	err := exec.Command(signal[0], signal[1:]...).Run()
+	// Assert
+	autopilot.Ok(t, err)
+	var exitErr *exec.ExitError
+	autopilot.Assert(t, errors.As(job.Wait(), &exitErr), "the job should exit with its trap's exit code")
+	autopilot.Equals(t, 3, exitErr.ExitCode())
+	autopilot.Equals(t, "cleaned up\n", stdout.String())
+	autopilot.Ok(t, bystander.Process.Signal(syscall.Signal(0)))
+}
+
+func TestSignalCommand_ResumableJob(t *testing.T) {
+	// Arrange
+	directory := filepath.Join(t.TempDir(), "exec")
+	runExecCommand(t, directory, getLaunchCommand("/bin/sh", "trap 'exit 3' TERM; sleep 30 & wait"))
+	waitForFile(t, filepath.Join(directory, jobPidFile))
+	signal := getSignalCommand("/bin/sh", "TERM", filepath.Join(directory, jobPidFile))
+	// Act
+	err := exec.Command(signal[0], signal[1:]...).Run()
+	// Assert
+	autopilot.Ok(t, err)
+	waitForExitCode(t, directory)
+	exitCode, err := os.ReadFile(filepath.Join(directory, "exit-code"))
+	autopilot.Ok(t, err)
+	autopilot.Equals(t, "3\n", string(exitCode))
+}
+
+func TestSignalCommand_JobNotStarted(t *testing.T) {
+	// Arrange
+	signal := getSignalCommand("/bin/sh", "TERM", filepath.Join(t.TempDir(), jobPidFile))
+	// Act
+	err := exec.Command(signal[0], signal[1:]...).Run()
+	// Assert
+	autopilot.Ok(t, err)
+}
+
+func TestSignalJob_LogsModeDeletesPodWithGracePeriod(t *testing.T) {
+	// Arrange
+	pod := &corev1.Pod{ObjectMeta: metav1.ObjectMeta{Name: "opslevel-job-42", Namespace: "jobs"}}
+	clientset := fake.NewClientset(pod)
+	runner := &JobRunner{logger: zerolog.Nop(), clientset: clientset, podConfig: &K8SPodConfig{ExecMode: ExecModeLogs}}
+	// Act
+	runner.signalJob(context.Background(), pod, ContainerNameJob, "TERM", 30*time.Second)
+	runner.signalJob(context.Background(), pod, ContainerNameJob, "KILL", 30*time.Second)
+	// Assert
+	actions := clientset.Actions()
+	autopilot.Equals(t, 1, len(actions))
+	deleteAction := actions[0].(k8stesting.DeleteAction)
+	autopilot.Equals(t, int64(30), *deleteAction.GetDeleteOptions().GracePeriodSeconds)
+}
+
+func TestWithGracefulCancel_OutlivesParent(t *testing.T) {
+	// Arrange
+	pod := &corev1.Pod{ObjectMeta: metav1.ObjectMeta{Name: "opslevel-job-42", Namespace: "jobs"}}
+	runner := &JobRunner{
+		logger:    zerolog.Nop(),
+		clientset: fake.NewClientset(pod),
+		podConfig: &K8SPodConfig{ExecMode: ExecModeLogs, CancelGracePeriod: 30},
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	// Act
+	execCtx, stop := runner.withGracefulCancel(ctx, pod, ContainerNameJob)
+	cancel()
+	// Assert: the job's commands keep running during the grace period until they finish
+	autopilot.Ok(t, execCtx.Err())
+	stop()
+	autopilot.Equals(t, context.Canceled, execCtx.Err())
+}
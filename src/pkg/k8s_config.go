@@ -1,8 +1,11 @@
 package pkg
 
 import (
+	"bytes"
+	"encoding/json"
 	"fmt"
 	"os"
+	"path"
 
 	"github.com/spf13/viper"
 	corev1 "k8s.io/api/core/v1"
@@ -29,9 +32,20 @@ type K8SPodConfig struct {
 	NodeSelector                  map[string]string           `yaml:"nodeSelector"`
 	AgentMode                     bool                        `yaml:"agentMode"`
 	HelperImage                   string                      `yaml:"helperImage"`
+	Cache                         CacheConfig                 `yaml:"cache"`
+	ExecMode                      string                      `yaml:"execMode"`
+	ExecMaxReconnects             int                         `yaml:"execMaxReconnects"`
+	ExecTransport                 string                      `yaml:"execTransport"`
+	Job                           K8SJobConfig                `yaml:"job"`
+	UsageSampleInterval           int                         `yaml:"usageSampleInterval"` // in seconds
+	Isolation                     K8SIsolationConfig          `yaml:"isolation"`
+	Egress                        K8SEgressConfig             `yaml:"egress"`
+	DebugHold                     bool                        `yaml:"debugHold"`
+	DebugHoldDuration             int                         `yaml:"debugHoldDuration"` // in seconds
+	CancelGracePeriod             int                         `yaml:"cancelGracePeriod"` // in seconds
 }
 
-func ReadPodConfig(path string) (*K8SPodConfig, error) {
+func ReadPodConfig(configPath string) (*K8SPodConfig, error) {
 	config := Config{
 		Kubernetes: K8SPodConfig{
 			Namespace:  viper.GetString("job-pod-namespace"),
@@ -53,14 +67,28 @@ func ReadPodConfig(path string) (*K8SPodConfig, error) {
 			TerminationGracePeriodSeconds: 5,
 			AgentMode:                     viper.GetBool("job-agent-mode"),
 			HelperImage:                   viper.GetString("job-pod-helper-image"),
+			ExecMode:                      viper.GetString("job-pod-exec-mode"),
+			ExecMaxReconnects:             viper.GetInt("job-pod-exec-max-reconnects"),
+			ExecTransport:                 viper.GetString("job-pod-exec-transport"),
+			UsageSampleInterval:           viper.GetInt("job-pod-usage-sample-interval"),
+			DebugHold:                     viper.GetBool("job-pod-debug-hold"),
+			DebugHoldDuration:             viper.GetInt("job-pod-debug-hold-duration"),
+			CancelGracePeriod:             viper.GetInt("job-pod-cancel-grace-period"),
+			Cache: CacheConfig{
+				MountPath:        "/cache",
+				EvictionInterval: 300,
+			},
+			Job: K8SJobConfig{
+				AdmissionTimeout: 3600,
+			},
 		},
 	}
 	// Early out with viper defaults if config file doesn't exist
-	if _, err := os.Stat(path); os.IsNotExist(err) {
+	if _, err := os.Stat(configPath); os.IsNotExist(err) {
 		return &config.Kubernetes, nil
 	}
 
-	file, err := os.ReadFile(path)
+	file, err := os.ReadFile(configPath)
 	if err != nil {
 		return nil, err
 	}
@@ -77,3 +105,153 @@ func (c *K8SPodConfig) helperImage() string {
 	}
 	return fmt.Sprintf("public.ecr.aws/opslevel/opslevel-runner:v%s", ImageTagVersion)
 }
+
+// ValidatePodConfig resolves the kubernetes configuration exactly like ReadPodConfig
+// does and then reports every problem it can find with it rather than stopping at
+// the first one, so operators can fix a config file in a single pass.
+func ValidatePodConfig(configPath string) (*K8SPodConfig, []error) {
+	problems := checkPodConfigFile(configPath)
+	config, err := ReadPodConfig(configPath)
+	if err != nil {
+		if len(problems) == 0 {
+			problems = append(problems, fmt.Errorf("kubernetes: %w", err))
+		}
+		return nil, problems
+	}
+	for resourceName, quantity := range config.Resources.Requests {
+		if quantity.Sign() < 0 {
+			problems = append(problems, fmt.Errorf("kubernetes.resources.requests.%s: quantity '%s' must not be negative", resourceName, quantity.String()))
+		}
+	}
+	for resourceName, quantity := range config.Resources.Limits {
+		if quantity.Sign() < 0 {
+			problems = append(problems, fmt.Errorf("kubernetes.resources.limits.%s: quantity '%s' must not be negative", resourceName, quantity.String()))
+		}
+	}
+	for resourceName, request := range config.Resources.Requests {
+		limit, ok := config.Resources.Limits[resourceName]
+		if ok && request.Cmp(limit) > 0 {
+			problems = append(problems, fmt.Errorf("kubernetes.resources.requests.%s: request '%s' is greater than limit '%s'", resourceName, request.String(), limit.String()))
+		}
+	}
+	if config.Lifetime <= 0 {
+		problems = append(problems, fmt.Errorf("kubernetes.lifetime: must be greater than 0 but was %d", config.Lifetime))
+	}
+	if config.Shell == "" {
+		problems = append(problems, fmt.Errorf("kubernetes.shell: must not be empty"))
+	}
+	if !path.IsAbs(config.WorkingDir) {
+		problems = append(problems, fmt.Errorf("kubernetes.workingDir: '%s' must be an absolute path", config.WorkingDir))
+	}
+	if config.TerminationGracePeriodSeconds < 0 {
+		problems = append(problems, fmt.Errorf("kubernetes.terminationGracePeriodSeconds: must not be negative but was %d", config.TerminationGracePeriodSeconds))
+	}
+	switch config.PullPolicy {
+	case "", corev1.PullAlways, corev1.PullIfNotPresent, corev1.PullNever:
+	default:
+		problems = append(problems, fmt.Errorf("kubernetes.pullPolicy: '%s' is not one of [Always, IfNotPresent, Never]", config.PullPolicy))
+	}
+	switch config.DNSPolicy {
+	case "", corev1.DNSClusterFirst, corev1.DNSClusterFirstWithHostNet, corev1.DNSDefault, corev1.DNSNone:
+	default:
+		problems = append(problems, fmt.Errorf("kubernetes.dnsPolicy: '%s' is not one of [ClusterFirst, ClusterFirstWithHostNet, Default, None]", config.DNSPolicy))
+	}
+	switch config.ExecMode {
+	case "", ExecModeStream, ExecModeResumable, ExecModeLogs:
+	default:
+		problems = append(problems, fmt.Errorf("kubernetes.execMode: '%s' is not one of [%s, %s, %s]", config.ExecMode, ExecModeStream, ExecModeResumable, ExecModeLogs))
+	}
+	switch config.ExecTransport {
+	case "", ExecTransportAuto, ExecTransportWebSocket, ExecTransportSPDY:
+	default:
+		problems = append(problems, fmt.Errorf("kubernetes.execTransport: '%s' is not one of [%s, %s, %s]", config.ExecTransport, ExecTransportAuto, ExecTransportWebSocket, ExecTransportSPDY))
+	}
+	if config.ExecMaxReconnects < 0 {
+		problems = append(problems, fmt.Errorf("kubernetes.execMaxReconnects: must not be negative but was %d", config.ExecMaxReconnects))
+	}
+	if config.UsageSampleInterval < 0 {
+		problems = append(problems, fmt.Errorf("kubernetes.usageSampleInterval: must not be negative but was %d", config.UsageSampleInterval))
+	}
+	if config.CancelGracePeriod < 0 {
+		problems = append(problems, fmt.Errorf("kubernetes.cancelGracePeriod: must not be negative but was %d", config.CancelGracePeriod))
+	}
+	if config.DebugHoldDuration < 0 {
+		problems = append(problems, fmt.Errorf("kubernetes.debugHoldDuration: must not be negative but was %d", config.DebugHoldDuration))
+	}
+	if config.DebugHold && config.DebugHoldDuration <= 0 {
+		problems = append(problems, fmt.Errorf("kubernetes.debugHold: requires kubernetes.debugHoldDuration to be greater than 0"))
+	}
+	if config.DebugHold && config.DebugHoldDuration > 0 && config.ExecMode == ExecModeLogs {
+		problems = append(problems, fmt.Errorf("kubernetes.debugHold: can't be used with the '%s' exec mode because the job container exits with the job's commands", ExecModeLogs))
+	}
+	if config.Job.AdmissionTimeout < 0 {
+		problems = append(problems, fmt.Errorf("kubernetes.job.admissionTimeout: must not be negative but was %d", config.Job.AdmissionTimeout))
+	}
+	if config.Job.TTLSecondsAfterFinished != nil && *config.Job.TTLSecondsAfterFinished < 0 {
+		problems = append(problems, fmt.Errorf("kubernetes.job.ttlSecondsAfterFinished: must not be negative but was %d", *config.Job.TTLSecondsAfterFinished))
+	}
+	if config.Isolation.Enabled && config.Cache.Enabled() {
+		problems = append(problems, fmt.Errorf("kubernetes.isolation: can't be used with kubernetes.cache because the cache claim can't be mounted from another namespace"))
+	}
+	if config.Egress.Enabled && config.Egress.Profile != "" {
+		if _, ok := config.Egress.Profiles[config.Egress.Profile]; !ok {
+			problems = append(problems, fmt.Errorf("kubernetes.egress.profile: '%s' is not one of %v", config.Egress.Profile, config.Egress.profileNames()))
+		}
+	}
+	if config.Cache.Enabled() {
+		if !path.IsAbs(config.Cache.MountPath) {
+			problems = append(problems, fmt.Errorf("kubernetes.cache.mountPath: '%s' must be an absolute path", config.Cache.MountPath))
+		}
+		if config.Cache.MaxSize.Sign() < 0 {
+			problems = append(problems, fmt.Errorf("kubernetes.cache.maxSize: quantity '%s' must not be negative", config.Cache.MaxSize.String()))
+		}
+		if config.Cache.MaxAge < 0 {
+			problems = append(problems, fmt.Errorf("kubernetes.cache.maxAge: must not be negative but was %d", config.Cache.MaxAge))
+		}
+	}
+	return config, problems
+}
+
+// checkPodConfigFile decodes the kubernetes section of the config file strictly so that
+// misspelled keys are reported instead of silently ignored, and parses each resource
+// quantity on its own so an invalid one can be pointed out by its key.
+func checkPodConfigFile(configPath string) []error {
+	file, err := os.ReadFile(configPath)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return []error{err}
+	}
+	sections := map[string]any{}
+	if err := yaml.Unmarshal(file, &sections); err != nil {
+		return []error{err}
+	}
+	section, ok := sections["kubernetes"].(map[string]any)
+	if !ok {
+		return nil
+	}
+	var problems []error
+	resources, _ := section["resources"].(map[string]any)
+	for _, name := range []string{"requests", "limits"} {
+		list, _ := resources[name].(map[string]any)
+		for resourceName, value := range list {
+			if _, err := resource.ParseQuantity(fmt.Sprintf("%v", value)); err != nil {
+				problems = append(problems, fmt.Errorf("kubernetes.resources.%s.%s: '%v' is not a valid quantity", name, resourceName, value))
+			}
+		}
+	}
+	if len(problems) > 0 {
+		return problems
+	}
+	data, err := json.Marshal(section)
+	if err != nil {
+		return []error{err}
+	}
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&K8SPodConfig{}); err != nil {
+		return []error{fmt.Errorf("kubernetes: %w", err)}
+	}
+	return nil
+}
@@ -0,0 +1,126 @@
+package pkg
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/rocktavious/autopilot/v2023"
+	"github.com/spf13/viper"
+)
+
+func writePodConfig(t *testing.T, contents string) string {
+	t.Helper()
+	viper.Set("job-pod-max-lifetime", 3600)
+	viper.Set("job-pod-shell", "/bin/sh")
+	viper.Set("job-pod-workdir", "/jobs")
+	t.Cleanup(viper.Reset)
+	configPath := filepath.Join(t.TempDir(), "opslevel.yaml")
+	autopilot.Ok(t, os.WriteFile(configPath, []byte(contents), 0o600))
+	return configPath
+}
+
+func problemMessages(problems []error) []string {
+	output := make([]string, 0, len(problems))
+	for _, problem := range problems {
+		output = append(output, problem.Error())
+	}
+	return output
+}
+
+func TestValidatePodConfig_Valid(t *testing.T) {
+	// Arrange
+	configPath := writePodConfig(t, `
+kubernetes:
+  namespace: jobs
+  resources:
+    requests:
+      cpu: 500m
+    limits:
+      cpu: "1"
+`)
+	// Act
+	config, problems := ValidatePodConfig(configPath)
+	// Assert
+	autopilot.Equals(t, []string{}, problemMessages(problems))
+	autopilot.Equals(t, "jobs", config.Namespace)
+}
+
+func TestValidatePodConfig_MissingFile(t *testing.T) {
+	// Arrange
+	configPath := writePodConfig(t, "")
+	autopilot.Ok(t, os.Remove(configPath))
+	// Act
+	config, problems := ValidatePodConfig(configPath)
+	// Assert
+	autopilot.Equals(t, []string{}, problemMessages(problems))
+	autopilot.Equals(t, "/bin/sh", config.Shell)
+}
+
+func TestValidatePodConfig_UnknownKey(t *testing.T) {
+	// Arrange
+	configPath := writePodConfig(t, `
+kubernetes:
+  namepsace: jobs
+`)
+	// Act
+	_, problems := ValidatePodConfig(configPath)
+	// Assert
+	autopilot.Equals(t, []string{`kubernetes: json: unknown field "namepsace"`}, problemMessages(problems))
+}
+
+func TestValidatePodConfig_InvalidQuantity(t *testing.T) {
+	// Arrange
+	configPath := writePodConfig(t, `
+kubernetes:
+  resources:
+    limits:
+      memory: lots
+`)
+	// Act
+	config, problems := ValidatePodConfig(configPath)
+	// Assert
+	autopilot.Assert(t, config == nil, "config should not resolve with an invalid quantity")
+	autopilot.Equals(t, []string{"kubernetes.resources.limits.memory: 'lots' is not a valid quantity"}, problemMessages(problems))
+}
+
+func TestValidatePodConfig_RequestGreaterThanLimit(t *testing.T) {
+	// Arrange
+	configPath := writePodConfig(t, `
+kubernetes:
+  pullPolicy: Sometimes
+  resources:
+    requests:
+      cpu: "2"
+    limits:
+      cpu: "1"
+`)
+	// Act
+	_, problems := ValidatePodConfig(configPath)
+	// Assert
+	autopilot.Equals(t, []string{
+		"kubernetes.resources.requests.cpu: request '2' is greater than limit '1'",
+		"kubernetes.pullPolicy: 'Sometimes' is not one of [Always, IfNotPresent, Never]",
+	}, problemMessages(problems))
+}
+
+func TestValidatePodConfig_DebugHold(t *testing.T) {
+	// Arrange
+	cases := map[string]struct {
+		config   string
+		problems []string
+	}{
+		"missing duration": {"execMode: logs\n  debugHold: true", []string{"kubernetes.debugHold: requires kubernetes.debugHoldDuration to be greater than 0"}},
+		"logs mode":        {"execMode: logs\n  debugHold: true\n  debugHoldDuration: 60", []string{"kubernetes.debugHold: can't be used with the 'logs' exec mode because the job container exits with the job's commands"}},
+		"duration only":    {"execMode: logs\n  debugHoldDuration: 60", []string{}},
+	}
+	for name, tc := range cases {
+		t.Run(name, func(t *testing.T) {
+			configPath := writePodConfig(t, "kubernetes:\n  "+tc.config+"\n")
+			// Act
+			_, problems := ValidatePodConfig(configPath)
+			// Assert
+			autopilot.Equals(t, tc.problems, problemMessages(problems))
+		})
+	}
+}
@@ -0,0 +1,201 @@
+package pkg
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/opslevel/opslevel-go/v2026"
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	networkingv1 "k8s.io/api/networking/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+const (
+	// DebugHoldVariable lets a job ask for its pod to be kept when it fails
+	DebugHoldVariable = "OPSLEVEL_DEBUG_HOLD"
+	// HoldOnFailureCommand is the log command a job prints to ask for its pod to be kept when it fails
+	HoldOnFailureCommand = "::hold-on-failure::"
+	// DebugHoldReasonAnnotation records why a failed job's pod was kept
+	DebugHoldReasonAnnotation = "opslevel.com/debug-hold-reason"
+)
+
+// getPodMaxLifetime is how long the job container stays up, which includes the time a failed job's
+// pod may be held for debugging after the job's max lifetime
+func (c *K8SPodConfig) getPodMaxLifetime() int {
+	return c.Lifetime + c.DebugHoldDuration
+}
+
+// holdMarkerMaxLine is the longest line holdMarkerWriter keeps back while it could still be the hold on
+// failure log command, which may be padded with whitespace
+const holdMarkerMaxLine = 1024
+
+// holdMarkerWriter passes the job's output through to writer a line at a time so that it can remove
+// the hold on failure log command and remember that the job printed it. A line that can't be the log
+// command is passed through before its newline arrives so output without newlines isn't held back.
+type holdMarkerWriter struct {
+	mutex     sync.Mutex
+	writer    io.Writer
+	partial   []byte
+	passing   bool // the rest of the current line is passed through as it arrives
+	requested bool
+}
+
+func newHoldMarkerWriter(writer io.Writer) *holdMarkerWriter {
+	return &holdMarkerWriter{writer: writer}
+}
+
+// isHoldMarkerCandidate reports whether the start of a line may still turn out to be the log command
+func isHoldMarkerCandidate(partial []byte) bool {
+	trimmed := strings.TrimSpace(string(partial))
+	return len(partial) <= holdMarkerMaxLine && (trimmed == HoldOnFailureCommand || strings.HasPrefix(HoldOnFailureCommand, trimmed))
+}
+
+func (w *holdMarkerWriter) Write(p []byte) (int, error) {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	w.partial = append(w.partial, p...)
+	for len(w.partial) > 0 {
+		index := bytes.IndexByte(w.partial, '\n')
+		if w.passing {
+			end := len(w.partial)
+			if index >= 0 {
+				end = index + 1
+				w.passing = false
+			}
+			line := w.partial[:end]
+			w.partial = w.partial[end:]
+			if _, err := w.writer.Write(line); err != nil {
+				return len(p), err
+			}
+			continue
+		}
+		if index < 0 {
+			if isHoldMarkerCandidate(w.partial) {
+				break
+			}
+			w.passing = true
+			continue
+		}
+		line := w.partial[:index+1]
+		w.partial = w.partial[index+1:]
+		if strings.TrimSpace(string(line)) == HoldOnFailureCommand {
+			w.requested = true
+			continue
+		}
+		if _, err := w.writer.Write(line); err != nil {
+			return len(p), err
+		}
+	}
+	return len(p), nil
+}
+
+// Flush writes the last line if it never received a terminating newline
+func (w *holdMarkerWriter) Flush() error {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	passing := w.passing
+	w.passing = false
+	if len(w.partial) == 0 {
+		return nil
+	}
+	if !passing && strings.TrimSpace(string(w.partial)) == HoldOnFailureCommand {
+		w.requested = true
+		w.partial = nil
+		return nil
+	}
+	_, err := w.writer.Write(w.partial)
+	w.partial = nil
+	return err
+}
+
+func (w *holdMarkerWriter) Requested() bool {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	return w.requested
+}
+
+// getDebugHoldReason returns why a failed job's pod should be kept or an empty string if it shouldn't
+func (s *JobRunner) getDebugHoldReason(job opslevel.RunnerJob, output *holdMarkerWriter) string {
+	if s.podConfig.DebugHoldDuration <= 0 || s.podConfig.ExecMode == ExecModeLogs {
+		return ""
+	}
+	if s.podConfig.DebugHold {
+		return "debug hold is enabled for all jobs"
+	}
+	for _, variable := range job.Variables {
+		if variable.Key == DebugHoldVariable {
+			if enabled, _ := strconv.ParseBool(variable.Value); enabled {
+				return fmt.Sprintf("job set %s", DebugHoldVariable)
+			}
+		}
+	}
+	if output.Requested() {
+		return fmt.Sprintf("job printed %s", HoldOnFailureCommand)
+	}
+	return ""
+}
+
+// DebugHold is a failed job's kubernetes objects that are kept for debugging instead of deleted
+type DebugHold struct {
+	Namespace    *corev1.Namespace
+	EgressPolicy *networkingv1.NetworkPolicy // Kept so the held pod's egress stays restricted
+	ConfigMap    *corev1.ConfigMap
+	Job          *batchv1.Job
+	Pod          *corev1.Pod
+	Reason       string
+	ExpiresAt    time.Time
+	Shell        string // The job's shell, used to get a shell in the held pod
+}
+
+// Instructions tells the user how to get a shell in the held pod
+func (h *DebugHold) Instructions() string {
+	return fmt.Sprintf("Holding pod for debugging until %s because %s, get a shell in it with:\n  kubectl exec -it -n %s %s -c %s -- %s\n",
+		h.ExpiresAt.UTC().Format(time.RFC3339), h.Reason, h.Pod.Namespace, h.Pod.Name, ContainerNameJob, h.Shell)
+}
+
+// HoldForDebugging labels and annotates the held objects so that the reaper deletes them once the hold expires
+func (s *JobRunner) HoldForDebugging(ctx context.Context, hold *DebugHold) error {
+	patch, err := json.Marshal(map[string]any{
+		"metadata": map[string]any{
+			"labels": map[string]string{CreatedByLabel: CreatedByValue},
+			"annotations": map[string]string{
+				ExpiresAtAnnotation:       hold.ExpiresAt.UTC().Format(time.RFC3339),
+				DebugHoldReasonAnnotation: hold.Reason,
+			},
+		},
+	})
+	if err != nil {
+		return err
+	}
+	if hold.Namespace != nil {
+		if _, err := s.clientset.CoreV1().Namespaces().Patch(ctx, hold.Namespace.Name, types.MergePatchType, patch, metav1.PatchOptions{}); err != nil {
+			return fmt.Errorf("failed to annotate namespace: %w", err)
+		}
+	}
+	if hold.EgressPolicy != nil {
+		if _, err := s.clientset.NetworkingV1().NetworkPolicies(hold.EgressPolicy.Namespace).Patch(ctx, hold.EgressPolicy.Name, types.MergePatchType, patch, metav1.PatchOptions{}); err != nil {
+			return fmt.Errorf("failed to annotate network policy: %w", err)
+		}
+	}
+	if hold.Job != nil {
+		if _, err := s.clientset.BatchV1().Jobs(hold.Job.Namespace).Patch(ctx, hold.Job.Name, types.MergePatchType, patch, metav1.PatchOptions{}); err != nil {
+			return fmt.Errorf("failed to annotate job: %w", err)
+		}
+	}
+	if _, err := s.clientset.CoreV1().ConfigMaps(hold.ConfigMap.Namespace).Patch(ctx, hold.ConfigMap.Name, types.MergePatchType, patch, metav1.PatchOptions{}); err != nil {
+		return fmt.Errorf("failed to annotate configmap: %w", err)
+	}
+	if _, err := s.clientset.CoreV1().Pods(hold.Pod.Namespace).Patch(ctx, hold.Pod.Name, types.MergePatchType, patch, metav1.PatchOptions{}); err != nil {
+		return fmt.Errorf("failed to annotate pod: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,109 @@
+package pkg
+
+import (
+	"bytes"
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/opslevel/opslevel-go/v2026"
+	"github.com/rocktavious/autopilot/v2023"
+	"github.com/rs/zerolog"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func TestHoldMarkerWriter(t *testing.T) {
+	// Arrange
+	stdout := &bytes.Buffer{}
+	writer := newHoldMarkerWriter(stdout)
+	// Act
+	_, _ = writer.Write([]byte("first\n::hold-on"))
+	_, _ = writer.Write([]byte("-failure::\n+ echo ::hold-on-failure::\n::hold"))
+	autopilot.Equals(t, "first\n+ echo ::hold-on-failure::\n", stdout.String())
+	autopilot.Ok(t, writer.Flush())
+	// Assert
+	autopilot.Equals(t, "first\n+ echo ::hold-on-failure::\n::hold", stdout.String())
+	autopilot.Equals(t, true, writer.Requested())
+}
+
+func TestHoldMarkerWriterPassesThroughLinesWithoutNewline(t *testing.T) {
+	// Arrange
+	stdout := &bytes.Buffer{}
+	writer := newHoldMarkerWriter(stdout)
+	padded := strings.Repeat(" ", holdMarkerMaxLine)
+	// Act
+	_, _ = writer.Write([]byte("progress 10%"))
+	_, _ = writer.Write([]byte(" 20%"))
+	afterProgress := stdout.String()
+	_, _ = writer.Write([]byte("\n::hold-on-failure::\n"))
+	_, _ = writer.Write([]byte(padded))
+	_, _ = writer.Write([]byte(" "))
+	autopilot.Ok(t, writer.Flush())
+	// Assert
+	autopilot.Equals(t, "progress 10% 20%", afterProgress)
+	autopilot.Equals(t, "progress 10% 20%\n"+padded+" ", stdout.String())
+	autopilot.Equals(t, true, writer.Requested())
+}
+
+func TestGetDebugHoldReason(t *testing.T) {
+	holdVariable := []opslevel.RunnerJobVariable{{Key: DebugHoldVariable, Value: "true"}}
+	cases := map[string]struct {
+		config    K8SPodConfig
+		variables []opslevel.RunnerJobVariable
+		printed   bool
+		expected  string
+	}{
+		"disabled":           {K8SPodConfig{DebugHold: true}, holdVariable, true, ""},
+		"logs mode":          {K8SPodConfig{DebugHoldDuration: 60, DebugHold: true, ExecMode: ExecModeLogs}, nil, false, ""},
+		"all jobs":           {K8SPodConfig{DebugHoldDuration: 60, DebugHold: true}, nil, false, "debug hold is enabled for all jobs"},
+		"variable":           {K8SPodConfig{DebugHoldDuration: 60}, holdVariable, false, "job set OPSLEVEL_DEBUG_HOLD"},
+		"log command":        {K8SPodConfig{DebugHoldDuration: 60}, nil, true, "job printed ::hold-on-failure::"},
+		"nothing asked":      {K8SPodConfig{DebugHoldDuration: 60}, nil, false, ""},
+		"variable not truey": {K8SPodConfig{DebugHoldDuration: 60}, []opslevel.RunnerJobVariable{{Key: DebugHoldVariable, Value: "no"}}, false, ""},
+	}
+	for name, tc := range cases {
+		t.Run(name, func(t *testing.T) {
+			// Arrange
+			runner := &JobRunner{podConfig: &tc.config}
+			output := newHoldMarkerWriter(&bytes.Buffer{})
+			if tc.printed {
+				_, _ = output.Write([]byte(HoldOnFailureCommand + "\n"))
+			}
+			// Act
+			reason := runner.getDebugHoldReason(opslevel.RunnerJob{Variables: tc.variables}, output)
+			// Assert
+			autopilot.Equals(t, tc.expected, reason)
+		})
+	}
+}
+
+func TestHoldForDebugging(t *testing.T) {
+	// Arrange
+	pod := &corev1.Pod{ObjectMeta: metav1.ObjectMeta{Name: "opslevel-job-42", Namespace: "jobs"}}
+	configMap := &corev1.ConfigMap{ObjectMeta: metav1.ObjectMeta{Name: "opslevel-job-42", Namespace: "jobs"}}
+	clientset := fake.NewClientset(pod, configMap)
+	runner := &JobRunner{logger: zerolog.Nop(), clientset: clientset, podConfig: &K8SPodConfig{}}
+	hold := &DebugHold{
+		ConfigMap: configMap,
+		Pod:       pod,
+		Reason:    "job printed ::hold-on-failure::",
+		ExpiresAt: time.Date(2026, 10, 19, 12, 0, 0, 0, time.UTC),
+		Shell:     "/bin/bash",
+	}
+	// Act
+	err := runner.HoldForDebugging(context.Background(), hold)
+	// Assert
+	autopilot.Ok(t, err)
+	heldPod, err := clientset.CoreV1().Pods("jobs").Get(context.Background(), "opslevel-job-42", metav1.GetOptions{})
+	autopilot.Ok(t, err)
+	autopilot.Equals(t, CreatedByValue, heldPod.Labels[CreatedByLabel])
+	autopilot.Equals(t, "2026-10-19T12:00:00Z", heldPod.Annotations[ExpiresAtAnnotation])
+	autopilot.Equals(t, "job printed ::hold-on-failure::", heldPod.Annotations[DebugHoldReasonAnnotation])
+	heldConfigMap, err := clientset.CoreV1().ConfigMaps("jobs").Get(context.Background(), "opslevel-job-42", metav1.GetOptions{})
+	autopilot.Ok(t, err)
+	autopilot.Equals(t, "2026-10-19T12:00:00Z", heldConfigMap.Annotations[ExpiresAtAnnotation])
+	autopilot.Assert(t, strings.HasSuffix(hold.Instructions(), "\n  kubectl exec -it -n jobs opslevel-job-42 -c job -- /bin/bash\n"), "%s should end with the kubectl exec command", hold.Instructions())
+}
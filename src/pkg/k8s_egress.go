@@ -0,0 +1,125 @@
+package pkg
+
+import (
+	"context"
+	"fmt"
+	"slices"
+
+	"github.com/opslevel/opslevel-go/v2026"
+	corev1 "k8s.io/api/core/v1"
+	networkingv1 "k8s.io/api/networking/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/intstr"
+)
+
+// EgressProfileVariable lets a job pick one of the configured egress profiles
+const EgressProfileVariable = "OPSLEVEL_EGRESS_PROFILE"
+
+// K8SEgressConfig configures a NetworkPolicy per job that only allows the job pod to reach DNS and
+// the configured destinations. Jobs use Rules unless the runner's Profile or the job's
+// OPSLEVEL_EGRESS_PROFILE variable selects one of the Profiles instead.
+type K8SEgressConfig struct {
+	Enabled  bool                                              `yaml:"enabled"`
+	DNS      []networkingv1.NetworkPolicyPeer                  `yaml:"dns"` // defaults to any pod in kube-system
+	Rules    []networkingv1.NetworkPolicyEgressRule            `yaml:"rules"`
+	Profile  string                                            `yaml:"profile"`
+	Profiles map[string][]networkingv1.NetworkPolicyEgressRule `yaml:"profiles"`
+}
+
+func getEgressProfile(job opslevel.RunnerJob) string {
+	for _, variable := range job.Variables {
+		if variable.Key == EgressProfileVariable {
+			return variable.Value
+		}
+	}
+	return ""
+}
+
+// getEgressRules returns the rules the job's egress profile allows on top of DNS
+func (c *K8SEgressConfig) getEgressRules(job opslevel.RunnerJob) ([]networkingv1.NetworkPolicyEgressRule, error) {
+	profile := c.Profile
+	if jobProfile := getEgressProfile(job); jobProfile != "" {
+		profile = jobProfile
+	}
+	if profile == "" {
+		return c.Rules, nil
+	}
+	rules, ok := c.Profiles[profile]
+	if !ok {
+		return nil, fmt.Errorf("egress profile '%s' is not one of %v", profile, c.profileNames())
+	}
+	return rules, nil
+}
+
+func (c *K8SEgressConfig) profileNames() []string {
+	var names []string
+	for name := range c.Profiles {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+	return names
+}
+
+func (c *K8SEgressConfig) getDNSRule() networkingv1.NetworkPolicyEgressRule {
+	peers := c.DNS
+	if len(peers) == 0 {
+		peers = []networkingv1.NetworkPolicyPeer{{
+			NamespaceSelector: &metav1.LabelSelector{
+				MatchLabels: map[string]string{corev1.LabelMetadataName: "kube-system"},
+			},
+		}}
+	}
+	udp, tcp := corev1.ProtocolUDP, corev1.ProtocolTCP
+	port := intstr.FromInt32(53)
+	return networkingv1.NetworkPolicyEgressRule{
+		To: peers,
+		Ports: []networkingv1.NetworkPolicyPort{
+			{Protocol: &udp, Port: &port},
+			{Protocol: &tcp, Port: &port},
+		},
+	}
+}
+
+// setEgressObjects adds the NetworkPolicy that limits the job pod's egress, it selects the pod by
+// its instance label so it must be created before the pod starts
+func (s *JobRunner) setEgressObjects(objects *JobObjects, identifier string, labels map[string]string, job opslevel.RunnerJob) error {
+	config := s.podConfig.Egress
+	if !config.Enabled {
+		return nil
+	}
+	rules, err := config.getEgressRules(job)
+	if err != nil {
+		return err
+	}
+	objects.EgressPolicy = &networkingv1.NetworkPolicy{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      fmt.Sprintf("%s-egress", identifier),
+			Namespace: objects.Pod.Namespace,
+			Labels:    labels,
+		},
+		Spec: networkingv1.NetworkPolicySpec{
+			PodSelector: metav1.LabelSelector{
+				MatchLabels: map[string]string{"app.kubernetes.io/instance": identifier},
+			},
+			PolicyTypes: []networkingv1.PolicyType{networkingv1.PolicyTypeEgress},
+			Egress:      append([]networkingv1.NetworkPolicyEgressRule{config.getDNSRule()}, rules...),
+		},
+	}
+	return nil
+}
+
+func (s *JobRunner) CreateNetworkPolicy(ctx context.Context, config *networkingv1.NetworkPolicy) (*networkingv1.NetworkPolicy, error) {
+	s.logger.Trace().Msgf("Creating network policy %s/%s ...", config.Namespace, config.Name)
+	return s.clientset.NetworkingV1().NetworkPolicies(config.Namespace).Create(ctx, config, metav1.CreateOptions{})
+}
+
+func (s *JobRunner) DeleteNetworkPolicy(ctx context.Context, config *networkingv1.NetworkPolicy) {
+	if config == nil {
+		return
+	}
+	s.logger.Trace().Msgf("Deleting network policy %s/%s ...", config.Namespace, config.Name)
+	err := s.clientset.NetworkingV1().NetworkPolicies(config.Namespace).Delete(ctx, config.Name, metav1.DeleteOptions{})
+	if err != nil {
+		s.logger.Error().Err(err).Msgf("received error on NetworkPolicy deletion")
+	}
+}
@@ -0,0 +1,94 @@
+package pkg
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/opslevel/opslevel-go/v2026"
+	"github.com/rocktavious/autopilot/v2023"
+	networkingv1 "k8s.io/api/networking/v1"
+)
+
+// egressConfig allows all traffic but the metadata endpoint and has a profile that allows none
+var egressConfig = K8SEgressConfig{
+	Enabled: true,
+	Rules: []networkingv1.NetworkPolicyEgressRule{{
+		To: []networkingv1.NetworkPolicyPeer{{
+			IPBlock: &networkingv1.IPBlock{CIDR: "0.0.0.0/0", Except: []string{"169.254.169.254/32"}},
+		}},
+	}},
+	Profiles: map[string][]networkingv1.NetworkPolicyEgressRule{
+		"none": {},
+	},
+}
+
+func TestGetJobObjects_Egress(t *testing.T) {
+	// Arrange
+	runner := getFeatureRunner(func(config *K8SPodConfig) { config.Egress = egressConfig })
+	job := opslevel.RunnerJob{Id: "42", Image: "alpine:latest", Commands: []string{"echo hello"}}
+	// Act
+	objects, err := runner.getJobObjects("opslevel-job-42-1700000000", job)
+	autopilot.Ok(t, err)
+	manifests, err := objects.YAML()
+	autopilot.Ok(t, err)
+	// Assert
+	autopilot.Equals(t, "jobs", objects.EgressPolicy.Namespace)
+	autopilot.Equals(t, map[string]string{"app.kubernetes.io/instance": "opslevel-job-42-1700000000"}, objects.EgressPolicy.Spec.PodSelector.MatchLabels)
+	autopilot.Equals(t, 2, len(objects.EgressPolicy.Spec.Egress))
+	assertGolden(t, filepath.Join("testdata", "render", "egress.yaml"), manifests)
+}
+
+func TestGetJobObjects_EgressJobProfile(t *testing.T) {
+	// Arrange
+	runner := getFeatureRunner(func(config *K8SPodConfig) { config.Egress = egressConfig })
+	job := opslevel.RunnerJob{Image: "alpine:latest", Variables: []opslevel.RunnerJobVariable{{Key: EgressProfileVariable, Value: "none"}}}
+	// Act
+	objects, err := runner.getJobObjects("opslevel-job-42-1700000000", job)
+	// Assert
+	autopilot.Ok(t, err)
+	autopilot.Equals(t, []networkingv1.NetworkPolicyEgressRule{runner.podConfig.Egress.getDNSRule()}, objects.EgressPolicy.Spec.Egress)
+}
+
+func TestGetJobObjects_EgressUnknownProfile(t *testing.T) {
+	// Arrange
+	runner := getFeatureRunner(func(config *K8SPodConfig) { config.Egress = egressConfig })
+	job := opslevel.RunnerJob{Image: "alpine:latest", Variables: []opslevel.RunnerJobVariable{{Key: EgressProfileVariable, Value: "internet"}}}
+	// Act
+	_, err := runner.getJobObjects("opslevel-job-42-1700000000", job)
+	// Assert
+	autopilot.Equals(t, "egress profile 'internet' is not one of [none]", err.Error())
+}
+
+func TestGetJobObjects_EgressIsolation(t *testing.T) {
+	// Arrange
+	runner := getFeatureRunner(func(config *K8SPodConfig) { config.Egress = egressConfig })
+	runner.podConfig.Isolation.Enabled = true
+	// Act
+	objects, err := runner.getJobObjects("opslevel-job-42-1700000000", opslevel.RunnerJob{Image: "alpine:latest"})
+	// Assert
+	autopilot.Ok(t, err)
+	autopilot.Equals(t, "opslevel-job-42-1700000000", objects.EgressPolicy.Namespace)
+}
+
+func TestValidatePodConfig_Egress(t *testing.T) {
+	// Arrange
+	configPath := writePodConfig(t, `
+kubernetes:
+  egress:
+    enabled: true
+    profile: internet
+    profiles:
+      github:
+        - to:
+            - ipBlock:
+                cidr: 140.82.112.0/20
+          ports:
+            - protocol: TCP
+              port: 443
+`)
+	// Act
+	config, problems := ValidatePodConfig(configPath)
+	// Assert
+	autopilot.Equals(t, []string{"kubernetes.egress.profile: 'internet' is not one of [github]"}, problemMessages(problems))
+	autopilot.Equals(t, int32(443), config.Egress.Profiles["github"][0].Ports[0].Port.IntVal)
+}
@@ -0,0 +1,230 @@
+package pkg
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/util/httpstream"
+	"k8s.io/client-go/tools/remotecommand"
+	utilexec "k8s.io/client-go/util/exec"
+)
+
+const (
+	// ExecModeStream runs the job's commands inside the exec stream so a dropped connection fails the job
+	ExecModeStream = "stream"
+	// ExecModeResumable runs the job's commands detached and tails their output so the runner can re-attach
+	ExecModeResumable = "resumable"
+
+	// ExecTransportAuto uses WebSocket for exec and falls back to SPDY if the API server or a proxy can't upgrade to it
+	ExecTransportAuto      = "auto"
+	ExecTransportWebSocket = "websocket"
+	ExecTransportSPDY      = "spdy"
+
+	execVolumeName = "exec"
+	execMountPath  = "/opslevel-exec"
+)
+
+// ExecOffsets tracks how many bytes of each output file have been received so
+// that a re-attach continues from the last byte instead of repeating output.
+type ExecOffsets struct {
+	Stdout int64
+	Stderr int64
+}
+
+type offsetWriter struct {
+	writer io.Writer
+	offset *int64
+}
+
+func (w *offsetWriter) Write(p []byte) (int, error) {
+	n, err := w.writer.Write(p)
+	*w.offset += int64(n)
+	return n, err
+}
+
+func shouldFallbackToSPDY(err error) bool {
+	return err != nil && (httpstream.IsUpgradeFailure(err) || httpstream.IsHTTPSProxyError(err))
+}
+
+// streamExec streams an exec session over the configured transport. In auto mode it tries WebSocket and
+// falls back to SPDY, once it has fallen back it uses SPDY right away for the rest of the runner's execs.
+func (s *JobRunner) streamExec(ctx context.Context, url *url.URL, options remotecommand.StreamOptions) error {
+	transport := s.podConfig.ExecTransport
+	if transport == ExecTransportAuto && s.execFallback.Load() {
+		transport = ExecTransportSPDY
+	}
+	var exec remotecommand.Executor
+	var err error
+	switch transport {
+	case ExecTransportSPDY:
+		exec, err = remotecommand.NewSPDYExecutor(s.config, "POST", url)
+	case ExecTransportWebSocket:
+		exec, err = remotecommand.NewWebSocketExecutor(s.config, "GET", url.String())
+	default:
+		transport = ExecTransportWebSocket
+		exec, err = s.newFallbackExecutor(url, &transport)
+	}
+	if err != nil {
+		return err
+	}
+	err = exec.StreamWithContext(ctx, options)
+	if MetricExecSessions != nil && !shouldFallbackToSPDY(err) {
+		MetricExecSessions.WithLabelValues(transport).Inc()
+	}
+	return err
+}
+
+// newFallbackExecutor execs over WebSocket and falls back to SPDY if the upgrade fails, setting
+// transport to the one that was used
+func (s *JobRunner) newFallbackExecutor(url *url.URL, transport *string) (remotecommand.Executor, error) {
+	websocket, err := remotecommand.NewWebSocketExecutor(s.config, "GET", url.String())
+	if err != nil {
+		return nil, err
+	}
+	spdy, err := remotecommand.NewSPDYExecutor(s.config, "POST", url)
+	if err != nil {
+		return nil, err
+	}
+	return remotecommand.NewFallbackExecutor(websocket, spdy, func(err error) bool {
+		if !shouldFallbackToSPDY(err) {
+			return false
+		}
+		s.logger.Debug().Err(err).Msg("unable to exec over websocket, falling back to spdy")
+		s.execFallback.Store(true)
+		*transport = ExecTransportSPDY
+		return true
+	})
+}
+
+// addExecVolume adds the volume that holds the output of detached commands in resumable mode
+func (s *JobRunner) addExecVolume(pod *corev1.Pod) {
+	if s.podConfig.ExecMode != ExecModeResumable {
+		return
+	}
+	pod.Spec.Volumes = append(pod.Spec.Volumes, corev1.Volume{
+		Name: execVolumeName,
+		VolumeSource: corev1.VolumeSource{
+			EmptyDir: &corev1.EmptyDirVolumeSource{},
+		},
+	})
+	for i := range pod.Spec.Containers {
+		pod.Spec.Containers[i].VolumeMounts = append(pod.Spec.Containers[i].VolumeMounts, corev1.VolumeMount{
+			Name:      execVolumeName,
+			ReadOnly:  false,
+			MountPath: execMountPath,
+		})
+	}
+}
+
+// getLaunchCommand starts the script in the background, in a session of its own, with its output and
+// exit code written to execMountPath. Launching is skipped if a pid file exists so that retrying a launch is safe.
+func getLaunchCommand(shell, script string) []string {
+	launcher := fmt.Sprintf(`[ -f "$2/pid" ] && exit 0
+mkdir -p "$2" && : > "$2/stdout" && : > "$2/stderr" || exit 1
+( %s > "$2/stdout" 2> "$2/stderr" < /dev/null; echo $? > "$2/exit-code.tmp"; mv "$2/exit-code.tmp" "$2/exit-code" ) > /dev/null 2>&1 < /dev/null &
+echo $! > "$2/pid"`, getSessionScript(shell, `"$2/`+jobPidFile+`"`))
+	return []string{shell, "-c", launcher, "opslevel", script, execMountPath}
+}
+
+// getFollowCommand streams the output files from the given offsets until the exit code is written or the
+// script was killed before it could write it, e.g. by the OOM killer. The script counts as killed once its
+// process is gone or a zombie, the job container's command doesn't reap it. It first kills the follower of
+// a connection that dropped, which keeps running in the container otherwise.
+func getFollowCommand(shell string, offsets ExecOffsets) []string {
+	follow := fmt.Sprintf(`[ -f "$1/follow.pid" ] && kill $(cat "$1/follow.pid") 2> /dev/null
+tail -c +%d -f "$1/stdout" & OUT=$!
+tail -c +%d -f "$1/stderr" >&2 & ERR=$!
+echo "$$ $OUT $ERR" > "$1/follow.pid"
+PID=$(cat "$1/pid")
+running() {
+  kill -0 "$PID" 2> /dev/null && [ "$(sed 's/.*) \(.\).*/\1/' "/proc/$PID/stat" 2> /dev/null)" != Z ]
+}
+while [ ! -f "$1/exit-code" ] && running; do sleep 1; done
+sleep 1
+kill $OUT $ERR
+rm -f "$1/follow.pid"`, offsets.Stdout+1, offsets.Stderr+1)
+	return []string{shell, "-c", follow, "opslevel", execMountPath}
+}
+
+// getFetchCommand prints the output after the given offsets and exits with the script's exit code, or
+// with 137 like a SIGKILL when the script was killed before it wrote one
+func getFetchCommand(shell string, offsets ExecOffsets) []string {
+	fetch := fmt.Sprintf(`tail -c +%d "$1/stdout"
+tail -c +%d "$1/stderr" >&2
+if [ ! -f "$1/exit-code" ]; then
+  echo "opslevel-runner: the job's commands were killed before they exited (e.g. out of memory)" >&2
+  exit 137
+fi
+exit "$(cat "$1/exit-code")"`, offsets.Stdout+1, offsets.Stderr+1)
+	return []string{shell, "-c", fetch, "opslevel", execMountPath}
+}
+
+func getReconnectBackoff(attempt int) time.Duration {
+	return time.Duration(min(1<<attempt, 30)) * time.Second
+}
+
+// execWithReconnect retries an exec that failed to complete until it succeeds, the command itself
+// exits non-zero or the max number of reconnects has been reached. The command is rebuilt on every
+// attempt so that it can continue from the latest offsets.
+func (s *JobRunner) execWithReconnect(ctx context.Context, config JobConfig, command func() []string) error {
+	for attempt := 0; ; attempt++ {
+		config.Command = command()
+		err := s.ExecWithConfig(ctx, config)
+		var exitErr utilexec.ExitError
+		if err == nil || errors.As(err, &exitErr) {
+			return err
+		}
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		if attempt >= s.podConfig.ExecMaxReconnects {
+			return fmt.Errorf("lost connection to pod %s/%s after %d reconnects: %w", config.Namespace, config.PodName, attempt, err)
+		}
+		backoff := getReconnectBackoff(attempt)
+		s.logger.Warn().Err(err).Msgf("lost connection to pod %s/%s, reconnecting in %v ...", config.Namespace, config.PodName, backoff)
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(backoff):
+		}
+	}
+}
+
+// ExecResumable runs the script detached inside the container and attaches to its output
+func (s *JobRunner) ExecResumable(ctx context.Context, stdout, stderr io.Writer, pod *corev1.Pod, containerName string, script string) error {
+	launch := JobConfig{
+		Namespace:     pod.Namespace,
+		PodName:       pod.Name,
+		ContainerName: containerName,
+		Stdout:        stdout,
+		Stderr:        stderr,
+	}
+	if err := s.execWithReconnect(ctx, launch, func() []string { return getLaunchCommand(s.podConfig.Shell, script) }); err != nil {
+		return fmt.Errorf("failed to launch commands: %w", err)
+	}
+	return s.attachExec(ctx, stdout, stderr, pod, containerName, &ExecOffsets{})
+}
+
+// attachExec tails the output of a script started by ExecResumable from the given offsets,
+// re-attaching whenever the connection drops, and returns once the script has exited
+func (s *JobRunner) attachExec(ctx context.Context, stdout, stderr io.Writer, pod *corev1.Pod, containerName string, offsets *ExecOffsets) error {
+	config := JobConfig{
+		Namespace:     pod.Namespace,
+		PodName:       pod.Name,
+		ContainerName: containerName,
+		Stdout:        &offsetWriter{writer: stdout, offset: &offsets.Stdout},
+		Stderr:        &offsetWriter{writer: stderr, offset: &offsets.Stderr},
+	}
+	err := s.execWithReconnect(ctx, config, func() []string { return getFollowCommand(s.podConfig.Shell, *offsets) })
+	var exitErr utilexec.ExitError
+	if err != nil && !errors.As(err, &exitErr) {
+		return err
+	}
+	// The follow command stops shortly after the exit code is written so fetch anything it missed
+	return s.execWithReconnect(ctx, config, func() []string { return getFetchCommand(s.podConfig.Shell, *offsets) })
+}
@@ -0,0 +1,231 @@
+package pkg
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/opslevel/opslevel-go/v2026"
+	"github.com/rocktavious/autopilot/v2023"
+	"github.com/rs/zerolog"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+)
+
+// runExecCommand runs one of the resumable exec commands locally against directory instead of execMountPath
+func runExecCommand(t *testing.T, directory string, command []string) (string, string, int) {
+	t.Helper()
+	command[len(command)-1] = directory
+	var stdout, stderr bytes.Buffer
+	cmd := exec.Command(command[0], command[1:]...)
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	err := cmd.Run()
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) {
+		return stdout.String(), stderr.String(), exitErr.ExitCode()
+	}
+	autopilot.Ok(t, err)
+	return stdout.String(), stderr.String(), 0
+}
+
+func waitForExitCode(t *testing.T, directory string) {
+	t.Helper()
+	for range 50 {
+		if _, err := os.Stat(filepath.Join(directory, "exit-code")); err == nil {
+			return
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+	t.Fatal("script did not write an exit code")
+}
+
+func TestResumableExec_ResumesFromOffsets(t *testing.T) {
+	// Arrange
+	directory := filepath.Join(t.TempDir(), "exec")
+	runExecCommand(t, directory, getLaunchCommand("/bin/sh", "echo hello; echo world; echo oops >&2; exit 3"))
+	waitForExitCode(t, directory)
+	// Act: the runner already received "hello\n" on stdout before the connection dropped
+	stdout, stderr, exitCode := runExecCommand(t, directory, getFetchCommand("/bin/sh", ExecOffsets{Stdout: 6}))
+	// Assert
+	autopilot.Equals(t, "world\n", stdout)
+	autopilot.Equals(t, "oops\n", stderr)
+	autopilot.Equals(t, 3, exitCode)
+}
+
+func TestResumableExec_FollowUntilExit(t *testing.T) {
+	// Arrange
+	directory := filepath.Join(t.TempDir(), "exec")
+	runExecCommand(t, directory, getLaunchCommand("/bin/sh", "echo one; sleep 1; echo two"))
+	// Act
+	stdout, _, exitCode := runExecCommand(t, directory, getFollowCommand("/bin/sh", ExecOffsets{}))
+	_, _, scriptExitCode := runExecCommand(t, directory, getFetchCommand("/bin/sh", ExecOffsets{Stdout: int64(len(stdout))}))
+	// Assert
+	autopilot.Equals(t, "one\ntwo\n", stdout)
+	autopilot.Equals(t, 0, exitCode)
+	autopilot.Equals(t, 0, scriptExitCode)
+}
+
+func TestResumableExec_FollowKillsPreviousFollower(t *testing.T) {
+	// Arrange
+	directory := filepath.Join(t.TempDir(), "exec")
+	runExecCommand(t, directory, getLaunchCommand("/bin/sh", "echo one; sleep 3; echo two"))
+	command := getFollowCommand("/bin/sh", ExecOffsets{})
+	command[len(command)-1] = directory
+	dropped := exec.Command(command[0], command[1:]...)
+	autopilot.Ok(t, dropped.Start())
+	for range 50 {
+		if _, err := os.Stat(filepath.Join(directory, "follow.pid")); err == nil {
+			break
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+	exited := make(chan error, 1)
+	go func() { exited <- dropped.Wait() }()
+	// Act
+	stdout, _, exitCode := runExecCommand(t, directory, getFollowCommand("/bin/sh", ExecOffsets{Stdout: 4}))
+	// Assert
+	select {
+	case err := <-exited:
+		autopilot.Assert(t, err != nil, "the previous follower should have been killed")
+	case <-time.After(time.Second):
+		t.Fatal("the previous follower is still running")
+	}
+	autopilot.Equals(t, "two\n", stdout)
+	autopilot.Equals(t, 0, exitCode)
+}
+
+func TestResumableExec_FollowStopsWhenScriptIsKilled(t *testing.T) {
+	// Arrange
+	directory := filepath.Join(t.TempDir(), "exec")
+	runExecCommand(t, directory, getLaunchCommand("/bin/sh", "echo one; sleep 30"))
+	pid, err := os.ReadFile(filepath.Join(directory, "pid"))
+	autopilot.Ok(t, err)
+	autopilot.Ok(t, exec.Command("kill", "-9", strings.TrimSpace(string(pid))).Run())
+	// Act
+	start := time.Now()
+	stdout, _, exitCode := runExecCommand(t, directory, getFollowCommand("/bin/sh", ExecOffsets{}))
+	_, stderr, scriptExitCode := runExecCommand(t, directory, getFetchCommand("/bin/sh", ExecOffsets{Stdout: int64(len(stdout))}))
+	// Assert
+	autopilot.Assert(t, time.Since(start) < 10*time.Second, "follow should stop once the script is gone")
+	autopilot.Equals(t, 0, exitCode)
+	autopilot.Equals(t, 137, scriptExitCode)
+	autopilot.Equals(t, "opslevel-runner: the job's commands were killed before they exited (e.g. out of memory)\n", stderr)
+}
+
+func TestResumableExec_LaunchIsIdempotent(t *testing.T) {
+	// Arrange
+	directory := filepath.Join(t.TempDir(), "exec")
+	runExecCommand(t, directory, getLaunchCommand("/bin/sh", "echo once"))
+	waitForExitCode(t, directory)
+	// Act
+	runExecCommand(t, directory, getLaunchCommand("/bin/sh", "echo twice"))
+	stdout, _, _ := runExecCommand(t, directory, getFetchCommand("/bin/sh", ExecOffsets{}))
+	// Assert
+	autopilot.Equals(t, "once\n", stdout)
+}
+
+func TestOffsetWriter(t *testing.T) {
+	// Arrange
+	var offset int64 = 10
+	buffer := &bytes.Buffer{}
+	writer := &offsetWriter{writer: buffer, offset: &offset}
+	// Act
+	_, err := writer.Write([]byte("hello"))
+	// Assert
+	autopilot.Ok(t, err)
+	autopilot.Equals(t, int64(15), offset)
+	autopilot.Equals(t, "hello", buffer.String())
+}
+
+func TestGetPodObject_ResumableExecVolume(t *testing.T) {
+	// Arrange
+	runner := &JobRunner{
+		logger: zerolog.Nop(),
+		podConfig: &K8SPodConfig{
+			Namespace: "test", WorkingDir: "/workdir", Shell: "/bin/sh",
+			SecurityContext: corev1.PodSecurityContext{}, TerminationGracePeriodSeconds: 30,
+			ExecMode: ExecModeResumable,
+		},
+	}
+	// Act
+	pod := runner.getPodObject("test-pod", map[string]string{}, opslevel.RunnerJob{Image: "alpine:latest"})
+	// Assert
+	mount := findVolumeMount(pod.Spec.Containers[0].VolumeMounts, execVolumeName)
+	autopilot.Assert(t, mount != nil, "job: should mount the exec volume")
+	autopilot.Equals(t, execMountPath, mount.MountPath)
+	autopilot.Equals(t, execVolumeName, pod.Spec.Volumes[len(pod.Spec.Volumes)-1].Name)
+}
+
+// execRequests returns a runner whose API server rejects every request and records the upgrade protocol asked for
+func execRequests(t *testing.T, transport string) (*JobRunner, *[]string) {
+	t.Helper()
+	var upgrades []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		upgrades = append(upgrades, fmt.Sprintf("%s %s", r.Method, strings.ToLower(r.Header.Get("Upgrade"))))
+		w.WriteHeader(http.StatusForbidden)
+	}))
+	t.Cleanup(server.Close)
+	config := &rest.Config{Host: server.URL}
+	clientset, err := kubernetes.NewForConfig(config)
+	autopilot.Ok(t, err)
+	runner := &JobRunner{
+		logger:    zerolog.Nop(),
+		config:    config,
+		clientset: clientset,
+		podConfig: &K8SPodConfig{ExecTransport: transport},
+	}
+	return runner, &upgrades
+}
+
+func TestExecWithConfig_Transport(t *testing.T) {
+	cases := map[string][]string{
+		ExecTransportAuto:      {"GET websocket", "POST spdy/3.1"},
+		ExecTransportWebSocket: {"GET websocket"},
+		ExecTransportSPDY:      {"POST spdy/3.1"},
+	}
+	for transport, expected := range cases {
+		t.Run(transport, func(t *testing.T) {
+			// Arrange
+			runner, upgrades := execRequests(t, transport)
+			// Act
+			err := runner.ExecWithConfig(context.Background(), JobConfig{
+				Command:       []string{"true"},
+				Namespace:     "test",
+				PodName:       "test-pod",
+				ContainerName: ContainerNameJob,
+				Stdout:        &bytes.Buffer{},
+			})
+			// Assert
+			autopilot.Assert(t, err != nil, "exec should fail against a server that rejects upgrades")
+			autopilot.Equals(t, expected, *upgrades)
+		})
+	}
+}
+
+func TestExecWithConfig_AutoRemembersFallback(t *testing.T) {
+	// Arrange
+	runner, upgrades := execRequests(t, ExecTransportAuto)
+	config := JobConfig{
+		Command:       []string{"true"},
+		Namespace:     "test",
+		PodName:       "test-pod",
+		ContainerName: ContainerNameJob,
+		Stdout:        &bytes.Buffer{},
+	}
+	// Act
+	_ = runner.ExecWithConfig(context.Background(), config)
+	_ = runner.ExecWithConfig(context.Background(), config)
+	// Assert
+	autopilot.Equals(t, []string{"GET websocket", "POST spdy/3.1", "POST spdy/3.1"}, *upgrades)
+}
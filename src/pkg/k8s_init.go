@@ -0,0 +1,83 @@
+package pkg
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"slices"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/wait"
+)
+
+// InitContainerError is returned while waiting for the pod when one of its init containers failed
+type InitContainerError struct {
+	ContainerName string
+	ExitCode      int32
+	Reason        string
+}
+
+func (e *InitContainerError) Error() string {
+	return fmt.Sprintf("init container '%s' failed with exit code %d (%s)", e.ContainerName, e.ExitCode, e.Reason)
+}
+
+func getInitContainerError(pod *corev1.Pod) error {
+	for _, status := range pod.Status.InitContainerStatuses {
+		if terminated := status.State.Terminated; terminated != nil && terminated.ExitCode != 0 {
+			return &InitContainerError{ContainerName: status.Name, ExitCode: terminated.ExitCode, Reason: terminated.Reason}
+		}
+	}
+	return nil
+}
+
+// waitForContainerStart waits until the container is running or has terminated, its logs can't be
+// read before then
+func (s *JobRunner) waitForContainerStart(ctx context.Context, pod *corev1.Pod, containerName string) error {
+	return wait.PollUntilContextCancel(ctx, time.Second, true, func(ctx context.Context) (bool, error) {
+		current, err := s.clientset.CoreV1().Pods(pod.Namespace).Get(ctx, pod.Name, metav1.GetOptions{})
+		if err != nil {
+			return false, err
+		}
+		if current.Status.Phase == corev1.PodSucceeded || current.Status.Phase == corev1.PodFailed {
+			return true, nil
+		}
+		status := getContainerStatus(current, containerName)
+		return status != nil && (status.State.Running != nil || status.State.Terminated != nil), nil
+	})
+}
+
+// followInitContainer streams the output of the job's init commands into writer while the pod starts.
+// The returned function waits until all of it has been written so that it comes before the output of
+// the job's commands. When the pod failed to start for any other reason than an init container the
+// init commands may never run, so it stops following instead.
+func (s *JobRunner) followInitContainer(ctx context.Context, pod *corev1.Pod, writer io.Writer) func(waitErr error) {
+	hasInitCommands := slices.ContainsFunc(pod.Spec.InitContainers, func(container corev1.Container) bool {
+		return container.Name == ContainerNameInit
+	})
+	if !hasInitCommands {
+		return func(error) {}
+	}
+	followCtx, cancel := context.WithCancel(ctx)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		err := s.waitForContainerStart(followCtx, pod, ContainerNameInit)
+		if err == nil {
+			err = s.streamContainerLogs(followCtx, pod, ContainerNameInit, writer)
+		}
+		if err != nil && followCtx.Err() == nil {
+			s.logger.Warn().Err(err).Msgf("unable to stream the output of the init commands")
+		}
+	}()
+	return func(waitErr error) {
+		var initErr *InitContainerError
+		if waitErr != nil && !errors.As(waitErr, &initErr) {
+			cancel()
+		}
+		<-done
+		cancel()
+	}
+}
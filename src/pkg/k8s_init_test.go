@@ -0,0 +1,68 @@
+package pkg
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/rocktavious/autopilot/v2023"
+	"github.com/rs/zerolog"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func getInitFailedPod(exitCode int32) *corev1.Pod {
+	return &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Name: "test-pod", Namespace: "test"},
+		Spec: corev1.PodSpec{
+			InitContainers: []corev1.Container{{Name: ContainerNameHelper}, {Name: ContainerNameInit}},
+		},
+		Status: corev1.PodStatus{
+			Phase: corev1.PodFailed,
+			InitContainerStatuses: []corev1.ContainerStatus{
+				{Name: ContainerNameHelper, State: corev1.ContainerState{Terminated: &corev1.ContainerStateTerminated{ExitCode: 0, Reason: "Completed"}}},
+				{Name: ContainerNameInit, State: corev1.ContainerState{Terminated: &corev1.ContainerStateTerminated{ExitCode: exitCode, Reason: "Error"}}},
+			},
+		},
+	}
+}
+
+func TestIsPodInDesiredState_InitContainerFailed(t *testing.T) {
+	// Arrange
+	pod := getInitFailedPod(128)
+	runner := &JobRunner{logger: zerolog.Nop(), clientset: fake.NewClientset(pod), podConfig: &K8SPodConfig{}}
+	// Act
+	_, err := runner.isPodInDesiredState(pod)(context.Background())
+	// Assert
+	var initErr *InitContainerError
+	autopilot.Assert(t, errors.As(err, &initErr), "expected an init container error")
+	autopilot.Equals(t, int32(128), initErr.ExitCode)
+	autopilot.Equals(t, "init container 'init' failed with exit code 128 (Error)", err.Error())
+}
+
+func TestFollowInitContainer(t *testing.T) {
+	// Arrange
+	pod := getInitFailedPod(1)
+	runner := &JobRunner{logger: zerolog.Nop(), clientset: fake.NewClientset(pod), podConfig: &K8SPodConfig{}}
+	stdout := &bytes.Buffer{}
+	// Act
+	wait := runner.followInitContainer(context.Background(), pod, stdout)
+	wait(getInitContainerError(pod))
+	// Assert: the fake clientset always serves "fake logs" as the container's logs
+	autopilot.Equals(t, "fake logs", stdout.String())
+}
+
+func TestFollowInitContainer_NoInitCommands(t *testing.T) {
+	// Arrange
+	pod := getInitFailedPod(1)
+	pod.Spec.InitContainers = pod.Spec.InitContainers[:1]
+	runner := &JobRunner{logger: zerolog.Nop(), clientset: fake.NewClientset(pod), podConfig: &K8SPodConfig{}}
+	stdout := &bytes.Buffer{}
+	// Act
+	wait := runner.followInitContainer(context.Background(), pod, stdout)
+	wait(nil)
+	// Assert
+	autopilot.Equals(t, "", stdout.String())
+}
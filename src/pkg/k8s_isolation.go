@@ -0,0 +1,155 @@
+package pkg
+
+import (
+	"context"
+	"fmt"
+	"maps"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	networkingv1 "k8s.io/api/networking/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/wait"
+)
+
+// K8SIsolationConfig configures running every job in its own ephemeral namespace so that
+// jobs can't see or talk to each other's pods and ConfigMaps
+type K8SIsolationConfig struct {
+	Enabled       bool                      `yaml:"enabled"`
+	Labels        map[string]string         `yaml:"labels"`
+	Annotations   map[string]string         `yaml:"annotations"`
+	ResourceQuota *corev1.ResourceQuotaSpec `yaml:"resourceQuota"`
+	LimitRange    *corev1.LimitRangeSpec    `yaml:"limitRange"`
+}
+
+// setIsolationObjects moves the job's objects into a namespace of their own with a default deny
+// network policy and the configured quota and limit range
+func (s *JobRunner) setIsolationObjects(objects *JobObjects, identifier string, labels map[string]string) {
+	config := s.podConfig.Isolation
+	if !config.Enabled {
+		return
+	}
+	namespaceLabels := maps.Clone(labels)
+	maps.Copy(namespaceLabels, config.Labels)
+	namespaceLabels[CreatedByLabel] = CreatedByValue
+	namespaceAnnotations := maps.Clone(config.Annotations)
+	if namespaceAnnotations == nil {
+		namespaceAnnotations = map[string]string{}
+	}
+	objects.Namespace = &corev1.Namespace{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        identifier,
+			Labels:      namespaceLabels,
+			Annotations: namespaceAnnotations,
+		},
+	}
+	objects.NetworkPolicy = &networkingv1.NetworkPolicy{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "default-deny",
+			Namespace: identifier,
+			Labels:    labels,
+		},
+		Spec: networkingv1.NetworkPolicySpec{
+			PodSelector: metav1.LabelSelector{},
+			PolicyTypes: []networkingv1.PolicyType{networkingv1.PolicyTypeIngress, networkingv1.PolicyTypeEgress},
+		},
+	}
+	if config.ResourceQuota != nil {
+		objects.ResourceQuota = &corev1.ResourceQuota{
+			ObjectMeta: metav1.ObjectMeta{Name: identifier, Namespace: identifier, Labels: labels},
+			Spec:       *config.ResourceQuota,
+		}
+	}
+	if config.LimitRange != nil {
+		objects.LimitRange = &corev1.LimitRange{
+			ObjectMeta: metav1.ObjectMeta{Name: identifier, Namespace: identifier, Labels: labels},
+			Spec:       *config.LimitRange,
+		}
+	}
+	objects.ConfigMap.Namespace = identifier
+	objects.PDB.Namespace = identifier
+	objects.Pod.Namespace = identifier
+	if objects.Job != nil {
+		objects.Job.Namespace = identifier
+	}
+}
+
+// getNamespaceExpiry is when the reaper may delete a job namespace the runner failed to clean up
+func (s *JobRunner) getNamespaceExpiry(now time.Time, podMaxWait time.Duration) time.Time {
+	return now.Add(time.Duration(s.podConfig.Lifetime)*time.Second + podMaxWait + s.podConfig.getAdmissionTimeout() + 10*time.Minute)
+}
+
+// CreateIsolation creates the job's namespace and the objects that isolate it, then waits for the
+// namespace's service account to exist because pods can't be created in the namespace before it does
+func (s *JobRunner) CreateIsolation(ctx context.Context, objects *JobObjects, expiresAt time.Time) (*corev1.Namespace, error) {
+	objects.Namespace.Annotations[ExpiresAtAnnotation] = expiresAt.UTC().Format(time.RFC3339)
+	s.logger.Trace().Msgf("Creating namespace %s ...", objects.Namespace.Name)
+	namespace, err := s.clientset.CoreV1().Namespaces().Create(ctx, objects.Namespace, metav1.CreateOptions{})
+	if err != nil {
+		return nil, err
+	}
+	if _, err := s.clientset.NetworkingV1().NetworkPolicies(namespace.Name).Create(ctx, objects.NetworkPolicy, metav1.CreateOptions{}); err != nil {
+		return namespace, fmt.Errorf("failed to create network policy: %w", err)
+	}
+	if objects.ResourceQuota != nil {
+		if _, err := s.clientset.CoreV1().ResourceQuotas(namespace.Name).Create(ctx, objects.ResourceQuota, metav1.CreateOptions{}); err != nil {
+			return namespace, fmt.Errorf("failed to create resource quota: %w", err)
+		}
+	}
+	if objects.LimitRange != nil {
+		if _, err := s.clientset.CoreV1().LimitRanges(namespace.Name).Create(ctx, objects.LimitRange, metav1.CreateOptions{}); err != nil {
+			return namespace, fmt.Errorf("failed to create limit range: %w", err)
+		}
+	}
+	if s.podConfig.ServiceAccountName != "" && s.podConfig.ServiceAccountName != "default" {
+		if err := s.copyServiceAccount(ctx, namespace.Name); err != nil {
+			return namespace, err
+		}
+	}
+	err = wait.PollUntilContextTimeout(ctx, 500*time.Millisecond, 30*time.Second, true, func(ctx context.Context) (bool, error) {
+		_, err := s.clientset.CoreV1().ServiceAccounts(namespace.Name).Get(ctx, "default", metav1.GetOptions{})
+		return err == nil, nil
+	})
+	if err != nil {
+		return namespace, fmt.Errorf("default service account was not created: %w", err)
+	}
+	return namespace, nil
+}
+
+// copyServiceAccount creates the configured service account in the job's namespace with the labels and
+// annotations of the one in the runner's namespace, which carries cloud identities like IRSA or workload
+// identity. The RoleBindings and image pull secrets of the original account are not copied.
+func (s *JobRunner) copyServiceAccount(ctx context.Context, namespace string) error {
+	source, err := s.clientset.CoreV1().ServiceAccounts(s.podConfig.Namespace).Get(ctx, s.podConfig.ServiceAccountName, metav1.GetOptions{})
+	if err != nil {
+		return fmt.Errorf("failed to get service account %s/%s: %w", s.podConfig.Namespace, s.podConfig.ServiceAccountName, err)
+	}
+	serviceAccount := &corev1.ServiceAccount{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        source.Name,
+			Namespace:   namespace,
+			Labels:      source.Labels,
+			Annotations: source.Annotations,
+		},
+		AutomountServiceAccountToken: source.AutomountServiceAccountToken,
+	}
+	if _, err := s.clientset.CoreV1().ServiceAccounts(namespace).Create(ctx, serviceAccount, metav1.CreateOptions{}); err != nil {
+		return fmt.Errorf("failed to create service account: %w", err)
+	}
+	return nil
+}
+
+// DeleteNamespace deletes the job's namespace and everything left in it
+func (s *JobRunner) DeleteNamespace(ctx context.Context, namespace *corev1.Namespace) {
+	if namespace == nil {
+		return
+	}
+	s.logger.Trace().Msgf("Deleting namespace %s ...", namespace.Name)
+	propagation := metav1.DeletePropagationBackground
+	err := s.clientset.CoreV1().Namespaces().Delete(ctx, namespace.Name, metav1.DeleteOptions{
+		PropagationPolicy: &propagation,
+	})
+	if err != nil {
+		s.logger.Error().Err(err).Msgf("received error on Namespace deletion")
+	}
+}
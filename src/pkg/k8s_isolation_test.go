@@ -0,0 +1,79 @@
+package pkg
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/opslevel/opslevel-go/v2026"
+	"github.com/rocktavious/autopilot/v2023"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+// isolationConfig labels the namespace and limits it to one pod with 1Gi of memory per container by default
+var isolationConfig = K8SIsolationConfig{
+	Enabled: true,
+	Labels:  map[string]string{"tenant": "customer"},
+	ResourceQuota: &corev1.ResourceQuotaSpec{
+		Hard: corev1.ResourceList{corev1.ResourcePods: resource.MustParse("1")},
+	},
+	LimitRange: &corev1.LimitRangeSpec{
+		Limits: []corev1.LimitRangeItem{{
+			Type:    corev1.LimitTypeContainer,
+			Default: corev1.ResourceList{corev1.ResourceMemory: resource.MustParse("1Gi")},
+		}},
+	},
+}
+
+func TestGetJobObjects_Isolation(t *testing.T) {
+	// Arrange
+	runner := getFeatureRunner(func(config *K8SPodConfig) { config.Isolation = isolationConfig })
+	job := opslevel.RunnerJob{Id: "42", Image: "alpine:latest", Commands: []string{"echo hello"}}
+	// Act
+	objects, err := runner.getJobObjects("opslevel-job-42-1700000000", job)
+	autopilot.Ok(t, err)
+	manifests, err := objects.YAML()
+	autopilot.Ok(t, err)
+	// Assert
+	autopilot.Equals(t, "opslevel-job-42-1700000000", objects.Namespace.Name)
+	autopilot.Equals(t, CreatedByValue, objects.Namespace.Labels[CreatedByLabel])
+	autopilot.Equals(t, "customer", objects.Namespace.Labels["tenant"])
+	for _, namespace := range []string{objects.ConfigMap.Namespace, objects.PDB.Namespace, objects.Pod.Namespace, objects.NetworkPolicy.Namespace} {
+		autopilot.Equals(t, "opslevel-job-42-1700000000", namespace)
+	}
+	autopilot.Equals(t, 0, len(objects.NetworkPolicy.Spec.Ingress))
+	autopilot.Equals(t, 0, len(objects.NetworkPolicy.Spec.Egress))
+	assertGolden(t, filepath.Join("testdata", "render", "isolation.yaml"), manifests)
+}
+
+func TestCreateIsolation(t *testing.T) {
+	// Arrange
+	runner := getFeatureRunner(func(config *K8SPodConfig) { config.Isolation = isolationConfig })
+	runner.podConfig.ServiceAccountName = "jobs"
+	objects, err := runner.getJobObjects("opslevel-job-42-1700000000", opslevel.RunnerJob{Id: "42", Image: "alpine:latest"})
+	autopilot.Ok(t, err)
+	// The service account controller doesn't run against the fake clientset
+	defaultServiceAccount := &corev1.ServiceAccount{ObjectMeta: metav1.ObjectMeta{Name: "default", Namespace: "opslevel-job-42-1700000000"}}
+	sourceServiceAccount := &corev1.ServiceAccount{ObjectMeta: metav1.ObjectMeta{
+		Name: "jobs", Namespace: "jobs", Annotations: map[string]string{"eks.amazonaws.com/role-arn": "arn:aws:iam::123456789012:role/jobs"},
+	}}
+	clientset := fake.NewClientset(defaultServiceAccount, sourceServiceAccount)
+	runner.clientset = clientset
+	expiresAt := time.Date(2026, 10, 19, 12, 0, 0, 0, time.UTC)
+	// Act
+	namespace, err := runner.CreateIsolation(context.Background(), objects, expiresAt)
+	// Assert
+	autopilot.Ok(t, err)
+	autopilot.Equals(t, "2026-10-19T12:00:00Z", namespace.Annotations[ExpiresAtAnnotation])
+	_, err = clientset.NetworkingV1().NetworkPolicies(namespace.Name).Get(context.Background(), "default-deny", metav1.GetOptions{})
+	autopilot.Ok(t, err)
+	_, err = clientset.CoreV1().ResourceQuotas(namespace.Name).Get(context.Background(), namespace.Name, metav1.GetOptions{})
+	autopilot.Ok(t, err)
+	serviceAccount, err := clientset.CoreV1().ServiceAccounts(namespace.Name).Get(context.Background(), "jobs", metav1.GetOptions{})
+	autopilot.Ok(t, err)
+	autopilot.Equals(t, sourceServiceAccount.Annotations, serviceAccount.Annotations)
+}
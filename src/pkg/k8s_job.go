@@ -0,0 +1,132 @@
+package pkg
+
+import (
+	"context"
+	"fmt"
+	"maps"
+	"time"
+
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/wait"
+)
+
+// K8SJobConfig configures running jobs as batch/v1 Jobs instead of bare Pods so that cluster
+// tooling which keys off Jobs (queueing, cost allocation, failure policies) governs them.
+type K8SJobConfig struct {
+	Enabled                 bool                      `yaml:"enabled"`
+	TTLSecondsAfterFinished *int32                    `yaml:"ttlSecondsAfterFinished"`
+	Labels                  map[string]string         `yaml:"labels"`
+	Annotations             map[string]string         `yaml:"annotations"`
+	PodFailurePolicy        *batchv1.PodFailurePolicy `yaml:"podFailurePolicy"`
+	AdmissionTimeout        int                       `yaml:"admissionTimeout"` // in seconds, how long the Job may stay suspended, e.g. queued by Kueue
+}
+
+// getAdmissionTimeout is how long a suspended Job may wait to be admitted on top of the pod max wait
+func (c *K8SPodConfig) getAdmissionTimeout() time.Duration {
+	if !c.Job.Enabled {
+		return 0
+	}
+	return time.Duration(c.Job.AdmissionTimeout) * time.Second
+}
+
+// getBatchJobObject wraps the pod in a Job. The Job never retries a failed pod because
+// the runner only ever attaches to the first one.
+func (s *JobRunner) getBatchJobObject(pod *corev1.Pod) *batchv1.Job {
+	if !s.podConfig.Job.Enabled {
+		return nil
+	}
+	backoffLimit := int32(0)
+	activeDeadlineSeconds := int64(s.podConfig.getPodMaxLifetime())
+	labels := maps.Clone(pod.Labels)
+	if labels == nil {
+		labels = map[string]string{}
+	}
+	maps.Copy(labels, s.podConfig.Job.Labels)
+	return &batchv1.Job{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        pod.Name,
+			Namespace:   pod.Namespace,
+			Labels:      labels,
+			Annotations: s.podConfig.Job.Annotations,
+		},
+		Spec: batchv1.JobSpec{
+			BackoffLimit:            &backoffLimit,
+			ActiveDeadlineSeconds:   &activeDeadlineSeconds,
+			TTLSecondsAfterFinished: s.podConfig.Job.TTLSecondsAfterFinished,
+			PodFailurePolicy:        s.podConfig.Job.PodFailurePolicy,
+			Template: corev1.PodTemplateSpec{
+				ObjectMeta: metav1.ObjectMeta{
+					Labels:      pod.Labels,
+					Annotations: pod.Annotations,
+				},
+				Spec: pod.Spec,
+			},
+		},
+	}
+}
+
+func (s *JobRunner) CreateJob(ctx context.Context, config *batchv1.Job) (*batchv1.Job, error) {
+	s.logger.Trace().Msgf("Creating job %s/%s ...", config.Namespace, config.Name)
+	return s.clientset.BatchV1().Jobs(config.Namespace).Create(ctx, config, metav1.CreateOptions{})
+}
+
+// GetJobPod waits for the Job's controller to create its pod and returns it. The timeout only starts once
+// the Job is no longer suspended because queueing systems like Kueue keep a Job suspended until they admit
+// it, which may take up to the admission timeout.
+func (s *JobRunner) GetJobPod(ctx context.Context, job *batchv1.Job, timeout time.Duration) (*corev1.Pod, error) {
+	s.logger.Debug().Msgf("Waiting for job %s/%s to create its pod in %s ...", job.Namespace, job.Name, timeout)
+	selector := fmt.Sprintf("%s=%s", batchv1.ControllerUidLabel, job.UID)
+	admissionTimeout := s.podConfig.getAdmissionTimeout()
+	admissionDeadline := time.Now().Add(admissionTimeout)
+	deadline := time.Now().Add(timeout)
+	suspended := false
+	var pod *corev1.Pod
+	err := wait.PollUntilContextCancel(ctx, time.Second, true, func(ctx context.Context) (bool, error) {
+		pods, err := s.clientset.CoreV1().Pods(job.Namespace).List(ctx, metav1.ListOptions{LabelSelector: selector})
+		if err != nil {
+			return false, err
+		}
+		if len(pods.Items) > 0 {
+			pod = &pods.Items[0]
+			return true, nil
+		}
+		current, err := s.clientset.BatchV1().Jobs(job.Namespace).Get(ctx, job.Name, metav1.GetOptions{})
+		if err != nil {
+			return false, err
+		}
+		now := time.Now()
+		if current.Spec.Suspend != nil && *current.Spec.Suspend {
+			if !suspended {
+				s.logger.Info().Msgf("Job %s/%s is suspended, waiting up to %s for it to be admitted ...", job.Namespace, job.Name, admissionTimeout)
+				suspended = true
+			}
+			if now.After(admissionDeadline) {
+				return false, fmt.Errorf("job was not admitted in %v", admissionTimeout)
+			}
+			deadline = now.Add(timeout)
+			return false, nil
+		}
+		if now.After(deadline) {
+			return false, fmt.Errorf("job did not create a pod in %v", timeout)
+		}
+		return false, nil
+	})
+	return pod, err
+}
+
+// DeleteJob deletes the Job and lets the garbage collector delete its pod in the background
+func (s *JobRunner) DeleteJob(ctx context.Context, config *batchv1.Job) {
+	if config == nil {
+		return
+	}
+	s.logger.Trace().Msgf("Deleting job %s/%s ...", config.Namespace, config.Name)
+	propagation := metav1.DeletePropagationBackground
+	err := s.clientset.BatchV1().Jobs(config.Namespace).Delete(ctx, config.Name, metav1.DeleteOptions{
+		PropagationPolicy: &propagation,
+	})
+	if err != nil {
+		s.logger.Error().Err(err).Msgf("received error on Job deletion")
+	}
+}
@@ -0,0 +1,131 @@
+package pkg
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/opslevel/opslevel-go/v2026"
+	"github.com/rocktavious/autopilot/v2023"
+	"github.com/rs/zerolog"
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+)
+
+var batchJobTTL = int32(300)
+
+// batchJobConfig queues the Jobs with Kueue and deletes them 5 minutes after they finish
+var batchJobConfig = K8SJobConfig{
+	Enabled:                 true,
+	TTLSecondsAfterFinished: &batchJobTTL,
+	Labels:                  map[string]string{"kueue.x-k8s.io/queue-name": "runners"},
+}
+
+func TestGetJobObjects_BatchJob(t *testing.T) {
+	// Arrange
+	runner := getFeatureRunner(func(config *K8SPodConfig) { config.Job = batchJobConfig })
+	job := opslevel.RunnerJob{Id: "42", Image: "alpine:latest", Commands: []string{"echo hello"}}
+	// Act
+	objects, err := runner.getJobObjects("opslevel-job-42-1700000000", job)
+	autopilot.Ok(t, err)
+	manifests, err := objects.YAML()
+	autopilot.Ok(t, err)
+	// Assert
+	autopilot.Equals(t, int32(0), *objects.Job.Spec.BackoffLimit)
+	autopilot.Equals(t, int64(3600), *objects.Job.Spec.ActiveDeadlineSeconds)
+	autopilot.Equals(t, "runners", objects.Job.Labels["kueue.x-k8s.io/queue-name"])
+	autopilot.Equals(t, objects.Pod.Labels, objects.Job.Spec.Template.Labels)
+	autopilot.Equals(t, objects.Pod.Spec, objects.Job.Spec.Template.Spec)
+	assertGolden(t, filepath.Join("testdata", "render", "batch-job.yaml"), manifests)
+}
+
+func TestGetJobObjects_BatchJobDisabled(t *testing.T) {
+	// Arrange
+	runner := getFeatureRunner(func(config *K8SPodConfig) { config.Job = batchJobConfig })
+	runner.podConfig.Job.Enabled = false
+	// Act
+	objects, err := runner.getJobObjects("opslevel-job-42-1700000000", opslevel.RunnerJob{Image: "alpine:latest"})
+	// Assert
+	autopilot.Ok(t, err)
+	autopilot.Assert(t, objects.Job == nil, "job should not be created when disabled")
+}
+
+func TestGetJobPod(t *testing.T) {
+	// Arrange
+	batchJob := &batchv1.Job{ObjectMeta: metav1.ObjectMeta{Name: "opslevel-job-42", Namespace: "jobs", UID: "1234"}}
+	other := &corev1.Pod{ObjectMeta: metav1.ObjectMeta{
+		Name: "other", Namespace: "jobs", Labels: map[string]string{batchv1.ControllerUidLabel: "5678"},
+	}}
+	expected := &corev1.Pod{ObjectMeta: metav1.ObjectMeta{
+		Name: "opslevel-job-42-abcde", Namespace: "jobs", Labels: map[string]string{batchv1.ControllerUidLabel: "1234"},
+	}}
+	runner := &JobRunner{logger: zerolog.Nop(), clientset: fake.NewClientset(other, expected), podConfig: &K8SPodConfig{}}
+	// Act
+	pod, err := runner.GetJobPod(context.Background(), batchJob, time.Second)
+	// Assert
+	autopilot.Ok(t, err)
+	autopilot.Equals(t, "opslevel-job-42-abcde", pod.Name)
+}
+
+func TestGetJobPod_WaitsWhileSuspended(t *testing.T) {
+	// Arrange
+	suspend := true
+	batchJob := &batchv1.Job{
+		ObjectMeta: metav1.ObjectMeta{Name: "opslevel-job-42", Namespace: "jobs", UID: "1234"},
+		Spec:       batchv1.JobSpec{Suspend: &suspend},
+	}
+	clientset := fake.NewClientset(batchJob)
+	runner := &JobRunner{logger: zerolog.Nop(), clientset: clientset, podConfig: &K8SPodConfig{
+		Job: K8SJobConfig{Enabled: true, AdmissionTimeout: 60},
+	}}
+	go func() {
+		// Admitted after longer than the pod max wait
+		time.Sleep(2500 * time.Millisecond)
+		admitted := batchJob.DeepCopy()
+		admitted.Spec.Suspend = nil
+		_, _ = clientset.BatchV1().Jobs("jobs").Update(context.Background(), admitted, metav1.UpdateOptions{})
+		_, _ = clientset.CoreV1().Pods("jobs").Create(context.Background(), &corev1.Pod{ObjectMeta: metav1.ObjectMeta{
+			Name: "opslevel-job-42-abcde", Namespace: "jobs", Labels: map[string]string{batchv1.ControllerUidLabel: "1234"},
+		}}, metav1.CreateOptions{})
+	}()
+	// Act
+	pod, err := runner.GetJobPod(context.Background(), batchJob, time.Second)
+	// Assert
+	autopilot.Ok(t, err)
+	autopilot.Equals(t, "opslevel-job-42-abcde", pod.Name)
+}
+
+func TestGetJobPod_AdmissionTimeout(t *testing.T) {
+	// Arrange
+	suspend := true
+	batchJob := &batchv1.Job{
+		ObjectMeta: metav1.ObjectMeta{Name: "opslevel-job-42", Namespace: "jobs", UID: "1234"},
+		Spec:       batchv1.JobSpec{Suspend: &suspend},
+	}
+	runner := &JobRunner{logger: zerolog.Nop(), clientset: fake.NewClientset(batchJob), podConfig: &K8SPodConfig{
+		Job: K8SJobConfig{Enabled: true, AdmissionTimeout: 1},
+	}}
+	// Act
+	_, err := runner.GetJobPod(context.Background(), batchJob, time.Minute)
+	// Assert
+	autopilot.Equals(t, "job was not admitted in 1s", err.Error())
+}
+
+func TestDeleteJob_BackgroundPropagation(t *testing.T) {
+	// Arrange
+	batchJob := &batchv1.Job{ObjectMeta: metav1.ObjectMeta{Name: "opslevel-job-42", Namespace: "jobs"}}
+	clientset := fake.NewClientset(batchJob)
+	runner := &JobRunner{logger: zerolog.Nop(), clientset: clientset}
+	// Act
+	runner.DeleteJob(context.Background(), batchJob)
+	runner.DeleteJob(context.Background(), nil)
+	// Assert
+	actions := clientset.Actions()
+	autopilot.Equals(t, 1, len(actions))
+	deleteAction := actions[0].(k8stesting.DeleteAction)
+	autopilot.Equals(t, metav1.DeletePropagationBackground, *deleteAction.GetDeleteOptions().PropagationPolicy)
+}
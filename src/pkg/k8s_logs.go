@@ -0,0 +1,206 @@
+package pkg
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/wait"
+)
+
+// ExecModeLogs runs the job's commands as the job container's command and reads their output from the
+// pod logs API so that the runner does not need the 'pods/exec' permission. Stdout and stderr are merged.
+const ExecModeLogs = "logs"
+
+// logCursor remembers the timestamp of the last log line written, and how many lines shared that
+// timestamp, so that a log stream resumed with SinceTime does not repeat lines.
+type logCursor struct {
+	time  time.Time
+	count int
+}
+
+type logCursorReader struct {
+	cursor *logCursor
+	replay int
+	writer io.Writer
+}
+
+func (c *logCursor) reader(writer io.Writer) *logCursorReader {
+	return &logCursorReader{cursor: c, replay: c.count, writer: writer}
+}
+
+// write strips the timestamp from a log line and writes it unless it was already written by a previous stream
+func (r *logCursorReader) write(line string) error {
+	stamp, text, found := strings.Cut(line, " ")
+	timestamp, err := time.Parse(time.RFC3339Nano, stamp)
+	if !found || err != nil {
+		_, err := io.WriteString(r.writer, line)
+		return err
+	}
+	switch {
+	case timestamp.Before(r.cursor.time):
+		return nil
+	case timestamp.Equal(r.cursor.time):
+		if r.replay > 0 {
+			r.replay--
+			return nil
+		}
+		r.cursor.count++
+	default:
+		r.cursor.time = timestamp
+		r.cursor.count = 1
+		r.replay = 0
+	}
+	_, err = io.WriteString(r.writer, text)
+	return err
+}
+
+// setLogsModeCommand makes the job's commands the job container's command in logs exec mode
+func (s *JobRunner) setLogsModeCommand(pod *corev1.Pod, script string) {
+	if s.podConfig.ExecMode != ExecModeLogs {
+		return
+	}
+	lifetime := int64(s.podConfig.Lifetime)
+	pod.Spec.ActiveDeadlineSeconds = &lifetime
+	pod.Spec.Containers[0].Command = []string{s.podConfig.Shell, "-e", "-c", script}
+}
+
+func getContainerStatus(pod *corev1.Pod, containerName string) *corev1.ContainerStatus {
+	for _, statuses := range [][]corev1.ContainerStatus{pod.Status.InitContainerStatuses, pod.Status.ContainerStatuses} {
+		for i := range statuses {
+			if statuses[i].Name == containerName {
+				return &statuses[i]
+			}
+		}
+	}
+	return nil
+}
+
+// isContainerDone reports whether the container has terminated or will never run because the pod finished
+func (s *JobRunner) isContainerDone(ctx context.Context, pod *corev1.Pod, containerName string) (bool, error) {
+	current, err := s.clientset.CoreV1().Pods(pod.Namespace).Get(ctx, pod.Name, metav1.GetOptions{})
+	if err != nil {
+		return false, err
+	}
+	if current.Status.Phase == corev1.PodSucceeded || current.Status.Phase == corev1.PodFailed {
+		return true, nil
+	}
+	status := getContainerStatus(current, containerName)
+	return status != nil && status.State.Terminated != nil, nil
+}
+
+func (s *JobRunner) readContainerLogs(ctx context.Context, pod *corev1.Pod, containerName string, follow bool, cursor *logCursor, writer io.Writer) error {
+	options := &corev1.PodLogOptions{
+		Container:  containerName,
+		Follow:     follow,
+		Timestamps: true,
+	}
+	if !cursor.time.IsZero() {
+		since := metav1.NewTime(cursor.time)
+		options.SinceTime = &since
+	}
+	stream, err := s.clientset.CoreV1().Pods(pod.Namespace).GetLogs(pod.Name, options).Stream(ctx)
+	if err != nil {
+		return err
+	}
+	defer stream.Close()
+	lines := bufio.NewReader(stream)
+	output := cursor.reader(writer)
+	for {
+		line, err := lines.ReadString('\n')
+		if line != "" {
+			if writeErr := output.write(line); writeErr != nil {
+				return writeErr
+			}
+		}
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// streamContainerLogs follows the logs of a container into writer until the container has terminated.
+// If the stream drops it is resumed from the last line written, up to the max number of reconnects.
+func (s *JobRunner) streamContainerLogs(ctx context.Context, pod *corev1.Pod, containerName string, writer io.Writer) error {
+	cursor := &logCursor{}
+	failures := 0
+	for {
+		written := *cursor
+		done, err := s.isContainerDone(ctx, pod, containerName)
+		if err == nil {
+			err = s.readContainerLogs(ctx, pod, containerName, !done, cursor, writer)
+			if err == nil && done {
+				return nil
+			}
+		}
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		if err == nil {
+			// The stream ended before the container terminated, wait a moment unless it made progress
+			failures = 0
+			if *cursor == written {
+				select {
+				case <-ctx.Done():
+					return ctx.Err()
+				case <-time.After(time.Second):
+				}
+			}
+			continue
+		}
+		if failures >= s.podConfig.ExecMaxReconnects {
+			return fmt.Errorf("lost connection to the logs of %s/%s after %d reconnects: %w", pod.Namespace, pod.Name, failures, err)
+		}
+		backoff := getReconnectBackoff(failures)
+		failures++
+		s.logger.Warn().Err(err).Msgf("lost connection to the logs of %s/%s, reconnecting in %v ...", pod.Namespace, pod.Name, backoff)
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(backoff):
+		}
+	}
+}
+
+// getContainerExitError waits for the container's terminated status and returns an error describing
+// why it failed, or nil if it exited successfully
+func (s *JobRunner) getContainerExitError(ctx context.Context, pod *corev1.Pod, containerName string) error {
+	var current *corev1.Pod
+	err := wait.PollUntilContextTimeout(ctx, time.Second, 30*time.Second, true, func(ctx context.Context) (bool, error) {
+		var err error
+		current, err = s.clientset.CoreV1().Pods(pod.Namespace).Get(ctx, pod.Name, metav1.GetOptions{})
+		if err != nil {
+			return false, err
+		}
+		status := getContainerStatus(current, containerName)
+		return status != nil && status.State.Terminated != nil, nil
+	})
+	if current != nil && current.Status.Reason == "DeadlineExceeded" {
+		return fmt.Errorf("pod exceeded its max lifetime of %ds", s.podConfig.Lifetime)
+	}
+	if err != nil {
+		return fmt.Errorf("container '%s' did not terminate: %w", containerName, err)
+	}
+	terminated := getContainerStatus(current, containerName).State.Terminated
+	if terminated.ExitCode != 0 {
+		return fmt.Errorf("command terminated with exit code %d (%s)", terminated.ExitCode, terminated.Reason)
+	}
+	return nil
+}
+
+// RunWithLogs streams the output of the job container's command from the pod logs API and
+// returns an error if the command failed
+func (s *JobRunner) RunWithLogs(ctx context.Context, stdout io.Writer, pod *corev1.Pod, containerName string) error {
+	if err := s.streamContainerLogs(ctx, pod, containerName, stdout); err != nil {
+		return err
+	}
+	return s.getContainerExitError(ctx, pod, containerName)
+}
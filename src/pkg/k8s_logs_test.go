@@ -0,0 +1,133 @@
+package pkg
+
+import (
+	"bytes"
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/opslevel/opslevel-go/v2026"
+	"github.com/rocktavious/autopilot/v2023"
+	"github.com/rs/zerolog"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func readLogLines(cursor *logCursor, lines ...string) string {
+	var output strings.Builder
+	reader := cursor.reader(&output)
+	for _, line := range lines {
+		_ = reader.write(line)
+	}
+	return output.String()
+}
+
+func getTerminatedPod(phase corev1.PodPhase, reason string, exitCode int32) *corev1.Pod {
+	return &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Name: "test-pod", Namespace: "test"},
+		Status: corev1.PodStatus{
+			Phase:  phase,
+			Reason: reason,
+			ContainerStatuses: []corev1.ContainerStatus{
+				{
+					Name: ContainerNameJob,
+					State: corev1.ContainerState{
+						Terminated: &corev1.ContainerStateTerminated{ExitCode: exitCode, Reason: "Error"},
+					},
+				},
+			},
+		},
+	}
+}
+
+func TestLogCursor_ResumeSkipsWrittenLines(t *testing.T) {
+	// Arrange
+	cursor := &logCursor{}
+	first := readLogLines(cursor,
+		"2026-10-19T00:00:01.000000001Z one\n",
+		"2026-10-19T00:00:01.000000002Z two\n",
+		"2026-10-19T00:00:01.000000002Z three\n",
+	)
+	// Act: SinceTime only has second precision so the resumed stream starts at the beginning of the second
+	second := readLogLines(cursor,
+		"2026-10-19T00:00:01.000000001Z one\n",
+		"2026-10-19T00:00:01.000000002Z two\n",
+		"2026-10-19T00:00:01.000000002Z three\n",
+		"2026-10-19T00:00:01.000000002Z four\n",
+		"2026-10-19T00:00:02.000000000Z five\n",
+	)
+	// Assert
+	autopilot.Equals(t, "one\ntwo\nthree\n", first)
+	autopilot.Equals(t, "four\nfive\n", second)
+}
+
+func TestLogCursor_LineWithoutTimestamp(t *testing.T) {
+	// Act
+	output := readLogLines(&logCursor{}, "unexpected\n")
+	// Assert
+	autopilot.Equals(t, "unexpected\n", output)
+}
+
+func TestGetPodObject_LogsModeCommand(t *testing.T) {
+	// Arrange
+	runner := &JobRunner{
+		logger: zerolog.Nop(),
+		podConfig: &K8SPodConfig{
+			Namespace: "test", Lifetime: 600, WorkingDir: "/jobs", Shell: "/bin/sh",
+			SecurityContext: corev1.PodSecurityContext{}, TerminationGracePeriodSeconds: 30,
+			ExecMode: ExecModeLogs,
+		},
+	}
+	job := opslevel.RunnerJob{Id: "42", Image: "alpine:latest", Commands: []string{"echo hello"}}
+	// Act
+	pod := runner.getPodObject("test-pod", map[string]string{}, job)
+	// Assert
+	autopilot.Equals(t, []string{"/bin/sh", "-e", "-c", "mkdir -p /jobs/42;\ncd /jobs/42;\nset -xv;\necho hello"}, pod.Spec.Containers[0].Command)
+	autopilot.Equals(t, int64(600), *pod.Spec.ActiveDeadlineSeconds)
+}
+
+func TestGetContainerExitError(t *testing.T) {
+	cases := map[string]struct {
+		pod      *corev1.Pod
+		expected string
+	}{
+		"success":  {getTerminatedPod(corev1.PodSucceeded, "", 0), ""},
+		"failure":  {getTerminatedPod(corev1.PodFailed, "", 2), "command terminated with exit code 2 (Error)"},
+		"deadline": {getTerminatedPod(corev1.PodFailed, "DeadlineExceeded", 137), "pod exceeded its max lifetime of 600s"},
+	}
+	for name, tc := range cases {
+		t.Run(name, func(t *testing.T) {
+			// Arrange
+			runner := &JobRunner{
+				logger:    zerolog.Nop(),
+				clientset: fake.NewClientset(tc.pod),
+				podConfig: &K8SPodConfig{Lifetime: 600},
+			}
+			// Act
+			err := runner.getContainerExitError(context.Background(), tc.pod, ContainerNameJob)
+			// Assert
+			if tc.expected == "" {
+				autopilot.Ok(t, err)
+			} else {
+				autopilot.Equals(t, tc.expected, err.Error())
+			}
+		})
+	}
+}
+
+func TestRunWithLogs_TerminatedContainer(t *testing.T) {
+	// Arrange
+	pod := getTerminatedPod(corev1.PodFailed, "", 1)
+	runner := &JobRunner{
+		logger:    zerolog.Nop(),
+		clientset: fake.NewClientset(pod),
+		podConfig: &K8SPodConfig{Lifetime: 600},
+	}
+	stdout := &bytes.Buffer{}
+	// Act
+	err := runner.RunWithLogs(context.Background(), stdout, pod, ContainerNameJob)
+	// Assert: the fake clientset always serves "fake logs" as the container's logs
+	autopilot.Equals(t, "fake logs", stdout.String())
+	autopilot.Equals(t, "command terminated with exit code 1 (Error)", err.Error())
+}
@@ -2,6 +2,9 @@ package pkg
 
 import (
 	"context"
+	"flag"
+	"os"
+	"path/filepath"
 	"testing"
 
 	"github.com/opslevel/opslevel-go/v2026"
@@ -9,8 +12,11 @@ import (
 	"github.com/rs/zerolog"
 	corev1 "k8s.io/api/core/v1"
 	policyv1 "k8s.io/api/policy/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
 )
 
+var updateGolden = flag.Bool("update", false, "update the golden files in testdata")
+
 func TestCreateLabelSelector(t *testing.T) {
 	// Arrange
 	labels := map[string]string{
@@ -288,6 +294,110 @@ func TestGetPodObject_InitImageOverride(t *testing.T) {
 	autopilot.Equals(t, "alpine:latest", pod.Spec.Containers[0].Image)
 }
 
+func TestRenderJobObjects_Golden(t *testing.T) {
+	// Arrange
+	runner := &JobRunner{
+		runnerId: "1",
+		logger:   zerolog.Nop(),
+		podConfig: &K8SPodConfig{
+			Namespace:  "jobs",
+			Lifetime:   3600,
+			Shell:      "/bin/sh",
+			WorkingDir: "/jobs",
+			Resources: corev1.ResourceRequirements{
+				Requests: corev1.ResourceList{
+					corev1.ResourceCPU:    resource.MustParse("500m"),
+					corev1.ResourceMemory: resource.MustParse("512Mi"),
+				},
+				Limits: corev1.ResourceList{
+					corev1.ResourceCPU:    resource.MustParse("1"),
+					corev1.ResourceMemory: resource.MustParse("1Gi"),
+				},
+			},
+			TerminationGracePeriodSeconds: 5,
+			HelperImage:                   "opslevel-runner:test",
+		},
+	}
+	jobs := map[string]opslevel.RunnerJob{
+		"basic": {
+			Id:       "42",
+			Image:    "alpine:latest",
+			Commands: []string{"echo hello"},
+			Variables: []opslevel.RunnerJobVariable{
+				{Key: "GREETING", Value: "hello"},
+			},
+			Files: []opslevel.RunnerJobFile{
+				{Name: "check.sh", Contents: "#!/bin/sh\necho hello"},
+			},
+		},
+		"init-container": {
+			Id:           "42",
+			Image:        "alpine:latest",
+			InitImage:    "git-tools:latest",
+			InitCommands: []string{"/opslevel/clone-repo ."},
+			Commands:     []string{"ls"},
+			Variables: []opslevel.RunnerJobVariable{
+				{Key: "REPO_CLONE_URL", Value: "https://example/repo.git", Scope: opslevel.RunnerJobVariableScopeInit},
+				{Key: "AI_API_KEY", Value: "secret", Scope: opslevel.RunnerJobVariableScopeMain},
+			},
+		},
+	}
+	for name, job := range jobs {
+		t.Run(name, func(t *testing.T) {
+			// Act
+			objects, err := runner.getJobObjects("opslevel-job-42-1700000000", job)
+			autopilot.Ok(t, err)
+			manifests, err := objects.YAML()
+			autopilot.Ok(t, err)
+			// Assert
+			assertGolden(t, filepath.Join("testdata", "render", name+".yaml"), manifests)
+		})
+	}
+}
+
+func TestTailWriter(t *testing.T) {
+	// Arrange
+	writer := &tailWriter{max: 8}
+	// Act
+	_, _ = writer.Write([]byte("error: "))
+	short := writer.String()
+	_, _ = writer.Write([]byte("file not found\n"))
+	// Assert
+	autopilot.Equals(t, "error: ", short)
+	autopilot.Equals(t, "t found", writer.String())
+}
+
+// getFeatureRunner returns a runner with the pod config the golden files of the render tests share,
+// configure turns on the feature under test
+func getFeatureRunner(configure func(config *K8SPodConfig)) *JobRunner {
+	runner := &JobRunner{
+		runnerId: "1",
+		logger:   zerolog.Nop(),
+		podConfig: &K8SPodConfig{
+			Namespace:                     "jobs",
+			Lifetime:                      3600,
+			Shell:                         "/bin/sh",
+			WorkingDir:                    "/jobs",
+			TerminationGracePeriodSeconds: 5,
+			HelperImage:                   "opslevel-runner:test",
+		},
+	}
+	configure(runner.podConfig)
+	return runner
+}
+
+// assertGolden compares the output to the golden file, run 'go test ./pkg -update' to regenerate them
+func assertGolden(t *testing.T, golden string, actual []byte) {
+	t.Helper()
+	if *updateGolden {
+		autopilot.Ok(t, os.MkdirAll(filepath.Dir(golden), 0o755))
+		autopilot.Ok(t, os.WriteFile(golden, actual, 0o644))
+	}
+	expected, err := os.ReadFile(golden)
+	autopilot.Ok(t, err)
+	autopilot.Equals(t, string(expected), string(actual))
+}
+
 func envKeys(env []corev1.EnvVar) []string {
 	keys := make([]string, 0, len(env))
 	for _, e := range env {
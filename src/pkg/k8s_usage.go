@@ -0,0 +1,283 @@
+package pkg
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/opslevel/opslevel-go/v2026"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+const (
+	OutcomeVariablePeakMemory        = "opslevel-peak-memory-bytes"
+	OutcomeVariableCPUSeconds        = "opslevel-cpu-seconds"
+	OutcomeVariableOOMKilled         = "opslevel-oom-killed"
+	OutcomeVariableOOMKills          = "opslevel-oom-kills"
+	OutcomeVariableTerminationReason = "opslevel-termination-reason"
+)
+
+// cgroupUsageScript prints the job container's memory and cpu usage from cgroup v2 or v1 as key=value lines
+const cgroupUsageScript = `if [ -f /sys/fs/cgroup/cgroup.controllers ]; then
+  echo "memory_peak=$(cat /sys/fs/cgroup/memory.peak 2>/dev/null)"
+  echo "memory_current=$(cat /sys/fs/cgroup/memory.current 2>/dev/null)"
+  echo "cpu_usec=$(sed -n 's/^usage_usec //p' /sys/fs/cgroup/cpu.stat 2>/dev/null)"
+  echo "oom_kill=$(sed -n 's/^oom_kill //p' /sys/fs/cgroup/memory.events 2>/dev/null)"
+else
+  echo "memory_peak=$(cat /sys/fs/cgroup/memory/memory.max_usage_in_bytes 2>/dev/null)"
+  echo "memory_current=$(cat /sys/fs/cgroup/memory/memory.usage_in_bytes 2>/dev/null)"
+  echo "cpu_nsec=$(cat /sys/fs/cgroup/cpuacct/cpuacct.usage 2>/dev/null)"
+  echo "oom_kill=$(sed -n 's/^oom_kill //p' /sys/fs/cgroup/memory/memory.oom_control 2>/dev/null)"
+fi`
+
+// ResourceUsage is what the job container used while the job's commands ran
+type ResourceUsage struct {
+	PeakMemoryBytes int64
+	CPUSeconds      float64
+	OOMKilled       bool  // the job container itself was OOM killed
+	OOMKills        int64 // the processes the kernel OOM killed in the job container while it kept running
+	Evicted         bool
+	Reason          string // why the container or pod was terminated, if it was
+}
+
+func (u *ResourceUsage) String() string {
+	return fmt.Sprintf("peak memory %s, cpu %.2fs", resource.NewQuantity(u.PeakMemoryBytes, resource.BinarySI).String(), u.CPUSeconds)
+}
+
+// Apply fails the outcome if the container was OOM killed or evicted, adds the usage to the
+// outcome message on failure and reports it as built-in outcome variables. A process of the job
+// that was OOM killed only explains a failure, the job's commands may have recovered from it.
+func (u *ResourceUsage) Apply(outcome JobOutcome) JobOutcome {
+	switch {
+	case u.OOMKilled:
+		outcome.Outcome = opslevel.RunnerJobOutcomeEnumFailed
+		outcome.Message = strings.TrimSpace(fmt.Sprintf("job ran out of memory and was OOM killed (%s) %s", u, outcome.Message))
+	case u.Evicted:
+		outcome.Outcome = opslevel.RunnerJobOutcomeEnumFailed
+		outcome.Message = strings.TrimSpace(fmt.Sprintf("job pod was evicted REASON: %s (%s) %s", u.Reason, u, outcome.Message))
+	case outcome.Outcome != opslevel.RunnerJobOutcomeEnumSuccess && u.OOMKills > 0:
+		outcome.Message = strings.TrimSpace(fmt.Sprintf("%d process(es) of the job ran out of memory and were OOM killed (%s) %s", u.OOMKills, u, outcome.Message))
+	case outcome.Outcome != opslevel.RunnerJobOutcomeEnumSuccess:
+		outcome.Message = fmt.Sprintf("%s (%s)", outcome.Message, u)
+	}
+	outcome.ResourceUsage = u
+	outcome.OutcomeVariables = append(outcome.OutcomeVariables,
+		opslevel.RunnerJobOutcomeVariable{Key: OutcomeVariablePeakMemory, Value: strconv.FormatInt(u.PeakMemoryBytes, 10)},
+		opslevel.RunnerJobOutcomeVariable{Key: OutcomeVariableCPUSeconds, Value: strconv.FormatFloat(u.CPUSeconds, 'f', 2, 64)},
+		opslevel.RunnerJobOutcomeVariable{Key: OutcomeVariableOOMKilled, Value: strconv.FormatBool(u.OOMKilled)},
+		opslevel.RunnerJobOutcomeVariable{Key: OutcomeVariableOOMKills, Value: strconv.FormatInt(u.OOMKills, 10)},
+	)
+	if u.Reason != "" {
+		outcome.OutcomeVariables = append(outcome.OutcomeVariables, opslevel.RunnerJobOutcomeVariable{Key: OutcomeVariableTerminationReason, Value: u.Reason})
+	}
+	return outcome
+}
+
+func (u *ResourceUsage) observe() {
+	if MetricJobsPeakMemory == nil {
+		return
+	}
+	MetricJobsPeakMemory.Observe(float64(u.PeakMemoryBytes))
+	MetricJobsCPUSeconds.Observe(u.CPUSeconds)
+	if u.OOMKilled {
+		MetricJobsOOMKilled.Inc()
+	}
+	MetricJobsOOMKills.Add(float64(u.OOMKills))
+}
+
+type cgroupUsage struct {
+	PeakMemoryBytes    int64
+	CurrentMemoryBytes int64
+	CPUSeconds         float64
+	OOMKills           int64
+}
+
+// parseCgroupUsage parses the output of cgroupUsageScript, values the kernel doesn't provide are left as 0
+func parseCgroupUsage(output string) cgroupUsage {
+	var usage cgroupUsage
+	scanner := bufio.NewScanner(strings.NewReader(output))
+	for scanner.Scan() {
+		key, raw, found := strings.Cut(scanner.Text(), "=")
+		value, err := strconv.ParseInt(strings.TrimSpace(raw), 10, 64)
+		if !found || err != nil {
+			continue
+		}
+		switch key {
+		case "memory_peak":
+			usage.PeakMemoryBytes = value
+		case "memory_current":
+			usage.CurrentMemoryBytes = value
+		case "cpu_usec":
+			usage.CPUSeconds = float64(value) / float64(time.Second/time.Microsecond)
+		case "cpu_nsec":
+			usage.CPUSeconds = float64(value) / float64(time.Second)
+		case "oom_kill":
+			usage.OOMKills = value
+		}
+	}
+	return usage
+}
+
+type podMetrics struct {
+	Containers []struct {
+		Name  string              `json:"name"`
+		Usage corev1.ResourceList `json:"usage"`
+	} `json:"containers"`
+}
+
+// parsePodMetrics returns the memory in bytes and cpu in cores the container is currently using
+func parsePodMetrics(data []byte, containerName string) (int64, float64, error) {
+	var metrics podMetrics
+	if err := json.Unmarshal(data, &metrics); err != nil {
+		return 0, 0, err
+	}
+	for _, container := range metrics.Containers {
+		if container.Name == containerName {
+			return container.Usage.Memory().Value(), container.Usage.Cpu().AsApproximateFloat64(), nil
+		}
+	}
+	return 0, 0, fmt.Errorf("no metrics for container '%s'", containerName)
+}
+
+func (s *JobRunner) canExec() bool {
+	return s.podConfig.ExecMode != ExecModeLogs
+}
+
+func (s *JobRunner) readPodMetrics(ctx context.Context, pod *corev1.Pod, containerName string) (int64, float64, error) {
+	data, err := s.clientset.CoreV1().RESTClient().Get().
+		AbsPath("/apis/metrics.k8s.io/v1beta1/namespaces", pod.Namespace, "pods", pod.Name).
+		DoRaw(ctx)
+	if err != nil {
+		return 0, 0, err
+	}
+	return parsePodMetrics(data, containerName)
+}
+
+func (s *JobRunner) readCgroupUsage(ctx context.Context, pod *corev1.Pod, containerName string) (cgroupUsage, error) {
+	var stdout, stderr bytes.Buffer
+	err := s.ExecWithConfig(ctx, JobConfig{
+		Command:       []string{s.podConfig.Shell, "-c", cgroupUsageScript},
+		Namespace:     pod.Namespace,
+		PodName:       pod.Name,
+		ContainerName: containerName,
+		Stdout:        &stdout,
+		Stderr:        &stderr,
+	})
+	if err != nil {
+		return cgroupUsage{}, err
+	}
+	return parseCgroupUsage(stdout.String()), nil
+}
+
+// usageSampler periodically samples the job container's usage while the job's commands run
+type usageSampler struct {
+	mutex           sync.Mutex
+	peakMemoryBytes int64
+	cpuSeconds      float64
+	cancel          context.CancelFunc
+	done            chan struct{}
+}
+
+func (s *JobRunner) startUsageSampler(ctx context.Context, pod *corev1.Pod, containerName string) *usageSampler {
+	sampleCtx, cancel := context.WithCancel(ctx)
+	sampler := &usageSampler{cancel: cancel, done: make(chan struct{})}
+	if s.podConfig.UsageSampleInterval <= 0 {
+		close(sampler.done)
+		return sampler
+	}
+	interval := time.Duration(s.podConfig.UsageSampleInterval) * time.Second
+	go func() {
+		defer close(sampler.done)
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-sampleCtx.Done():
+				return
+			case <-ticker.C:
+				s.sampleUsage(sampleCtx, pod, containerName, sampler, interval)
+			}
+		}
+	}()
+	return sampler
+}
+
+// sampleUsage prefers the metrics API and falls back to reading the cgroup files through exec
+func (s *JobRunner) sampleUsage(ctx context.Context, pod *corev1.Pod, containerName string, sampler *usageSampler, interval time.Duration) {
+	memory, cores, err := s.readPodMetrics(ctx, pod, containerName)
+	if err == nil {
+		sampler.mutex.Lock()
+		sampler.peakMemoryBytes = max(sampler.peakMemoryBytes, memory)
+		sampler.cpuSeconds += cores * interval.Seconds()
+		sampler.mutex.Unlock()
+		return
+	}
+	s.logger.Trace().Err(err).Msg("unable to read pod metrics")
+	if !s.canExec() {
+		return
+	}
+	usage, err := s.readCgroupUsage(ctx, pod, containerName)
+	if err != nil {
+		s.logger.Trace().Err(err).Msg("unable to read cgroup usage")
+		return
+	}
+	sampler.mutex.Lock()
+	sampler.peakMemoryBytes = max(sampler.peakMemoryBytes, usage.PeakMemoryBytes, usage.CurrentMemoryBytes)
+	sampler.cpuSeconds = max(sampler.cpuSeconds, usage.CPUSeconds)
+	sampler.mutex.Unlock()
+}
+
+// Stop stops sampling and returns the peak memory and cpu seconds sampled
+func (u *usageSampler) Stop() (int64, float64) {
+	u.cancel()
+	<-u.done
+	u.mutex.Lock()
+	defer u.mutex.Unlock()
+	return u.peakMemoryBytes, u.cpuSeconds
+}
+
+// collectResourceUsage combines the sampled usage with the container's final cgroup usage and
+// terminated state to work out what the job used and whether it was OOM killed or evicted
+func (s *JobRunner) collectResourceUsage(ctx context.Context, pod *corev1.Pod, containerName string, sampler *usageSampler) *ResourceUsage {
+	usage := &ResourceUsage{}
+	usage.PeakMemoryBytes, usage.CPUSeconds = sampler.Stop()
+
+	current, err := s.clientset.CoreV1().Pods(pod.Namespace).Get(ctx, pod.Name, metav1.GetOptions{})
+	if err != nil {
+		s.logger.Warn().Err(err).Msg("unable to get pod status for resource usage")
+		return usage
+	}
+	if current.Status.Reason == "Evicted" {
+		usage.Evicted = true
+		usage.Reason = current.Status.Message
+	}
+	status := getContainerStatus(current, containerName)
+	if status != nil && status.State.Terminated != nil {
+		usage.Reason = status.State.Terminated.Reason
+		usage.OOMKilled = status.State.Terminated.Reason == "OOMKilled"
+		return usage
+	}
+	if !s.canExec() {
+		return usage
+	}
+	// The container is still running so a process other than the container's own was OOM killed
+	cgroup, err := s.readCgroupUsage(ctx, pod, containerName)
+	if err != nil {
+		s.logger.Warn().Err(err).Msg("unable to read cgroup usage")
+		return usage
+	}
+	usage.PeakMemoryBytes = max(usage.PeakMemoryBytes, cgroup.PeakMemoryBytes, cgroup.CurrentMemoryBytes)
+	if cgroup.CPUSeconds > 0 {
+		usage.CPUSeconds = cgroup.CPUSeconds
+	}
+	usage.OOMKills = cgroup.OOMKills
+	return usage
+}
@@ -0,0 +1,107 @@
+package pkg
+
+import (
+	"context"
+	"testing"
+
+	"github.com/opslevel/opslevel-go/v2026"
+	"github.com/rocktavious/autopilot/v2023"
+	"github.com/rs/zerolog"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func TestParseCgroupUsage_V2(t *testing.T) {
+	// Act
+	usage := parseCgroupUsage("memory_peak=536870912\nmemory_current=1048576\ncpu_usec=12500000\noom_kill=1\n")
+	// Assert
+	autopilot.Equals(t, cgroupUsage{PeakMemoryBytes: 536870912, CurrentMemoryBytes: 1048576, CPUSeconds: 12.5, OOMKills: 1}, usage)
+}
+
+func TestParseCgroupUsage_V1MissingFiles(t *testing.T) {
+	// Act: older kernels don't report a peak or oom kills
+	usage := parseCgroupUsage("memory_peak=\nmemory_current=2048\ncpu_nsec=3000000000\noom_kill=\n")
+	// Assert
+	autopilot.Equals(t, cgroupUsage{CurrentMemoryBytes: 2048, CPUSeconds: 3}, usage)
+}
+
+func TestParsePodMetrics(t *testing.T) {
+	// Arrange
+	data := []byte(`{"kind":"PodMetrics","containers":[{"name":"job","usage":{"cpu":"250m","memory":"512Mi"}}]}`)
+	// Act
+	memory, cores, err := parsePodMetrics(data, ContainerNameJob)
+	_, _, missingErr := parsePodMetrics(data, ContainerNameInit)
+	// Assert
+	autopilot.Ok(t, err)
+	autopilot.Equals(t, int64(512*1024*1024), memory)
+	autopilot.Equals(t, 0.25, cores)
+	autopilot.Equals(t, "no metrics for container 'init'", missingErr.Error())
+}
+
+func TestResourceUsage_ApplyOOMKilled(t *testing.T) {
+	// Arrange
+	usage := &ResourceUsage{PeakMemoryBytes: 1024 * 1024 * 1024, CPUSeconds: 4, OOMKilled: true, Reason: "OOMKilled"}
+	// Act
+	outcome := usage.Apply(JobOutcome{Outcome: opslevel.RunnerJobOutcomeEnumSuccess})
+	// Assert
+	autopilot.Equals(t, opslevel.RunnerJobOutcomeEnumFailed, outcome.Outcome)
+	autopilot.Equals(t, "job ran out of memory and was OOM killed (peak memory 1Gi, cpu 4.00s)", outcome.Message)
+	autopilot.Equals(t, []opslevel.RunnerJobOutcomeVariable{
+		{Key: OutcomeVariablePeakMemory, Value: "1073741824"},
+		{Key: OutcomeVariableCPUSeconds, Value: "4.00"},
+		{Key: OutcomeVariableOOMKilled, Value: "true"},
+		{Key: OutcomeVariableOOMKills, Value: "0"},
+		{Key: OutcomeVariableTerminationReason, Value: "OOMKilled"},
+	}, outcome.OutcomeVariables)
+}
+
+func TestResourceUsage_ApplyFailureAndSuccess(t *testing.T) {
+	// Arrange
+	usage := &ResourceUsage{PeakMemoryBytes: 64 * 1024 * 1024, CPUSeconds: 1.5}
+	// Act
+	failed := usage.Apply(JobOutcome{Message: "pod execution failed", Outcome: opslevel.RunnerJobOutcomeEnumFailed})
+	succeeded := usage.Apply(JobOutcome{Outcome: opslevel.RunnerJobOutcomeEnumSuccess})
+	// Assert
+	autopilot.Equals(t, "pod execution failed (peak memory 64Mi, cpu 1.50s)", failed.Message)
+	autopilot.Equals(t, "", succeeded.Message)
+	autopilot.Equals(t, 4, len(succeeded.OutcomeVariables))
+}
+
+func TestResourceUsage_ApplyOOMKills(t *testing.T) {
+	// Arrange
+	usage := &ResourceUsage{PeakMemoryBytes: 64 * 1024 * 1024, CPUSeconds: 1.5, OOMKills: 2}
+	// Act
+	succeeded := usage.Apply(JobOutcome{Outcome: opslevel.RunnerJobOutcomeEnumSuccess})
+	failed := usage.Apply(JobOutcome{Message: "pod execution failed", Outcome: opslevel.RunnerJobOutcomeEnumFailed})
+	// Assert
+	autopilot.Equals(t, opslevel.RunnerJobOutcomeEnumSuccess, succeeded.Outcome)
+	autopilot.Equals(t, "", succeeded.Message)
+	autopilot.Equals(t, opslevel.RunnerJobOutcomeVariable{Key: OutcomeVariableOOMKilled, Value: "false"}, succeeded.OutcomeVariables[2])
+	autopilot.Equals(t, opslevel.RunnerJobOutcomeVariable{Key: OutcomeVariableOOMKills, Value: "2"}, succeeded.OutcomeVariables[3])
+	autopilot.Equals(t, opslevel.RunnerJobOutcomeEnumFailed, failed.Outcome)
+	autopilot.Equals(t, "2 process(es) of the job ran out of memory and were OOM killed (peak memory 64Mi, cpu 1.50s) pod execution failed", failed.Message)
+}
+
+func TestCollectResourceUsage_TerminatedContainer(t *testing.T) {
+	// Arrange
+	pod := &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Name: "test-pod", Namespace: "test"},
+		Status: corev1.PodStatus{
+			ContainerStatuses: []corev1.ContainerStatus{{
+				Name:  ContainerNameJob,
+				State: corev1.ContainerState{Terminated: &corev1.ContainerStateTerminated{ExitCode: 137, Reason: "OOMKilled"}},
+			}},
+		},
+	}
+	runner := &JobRunner{
+		logger:    zerolog.Nop(),
+		clientset: fake.NewClientset(pod),
+		podConfig: &K8SPodConfig{ExecMode: ExecModeLogs},
+	}
+	sampler := runner.startUsageSampler(context.Background(), pod, ContainerNameJob)
+	// Act
+	usage := runner.collectResourceUsage(context.Background(), pod, ContainerNameJob, sampler)
+	// Assert
+	autopilot.Equals(t, &ResourceUsage{OOMKilled: true, Reason: "OOMKilled"}, usage)
+}
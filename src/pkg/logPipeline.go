@@ -0,0 +1,482 @@
+package pkg
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+	"time"
+
+	faktoryWorker "github.com/contribsys/faktory_worker_go"
+	"github.com/opslevel/opslevel-go/v2026"
+	"github.com/rs/zerolog"
+	"github.com/spf13/viper"
+	"sigs.k8s.io/yaml"
+)
+
+const (
+	LogPipelineModeAPI     = "api"
+	LogPipelineModeFaktory = "faktory"
+	LogPipelineModeTest    = "test"
+)
+
+// LogPipelineConfig lists the processors that handle a job's output in order for each mode the
+// runner runs jobs in, a mode without a list of its own uses its default pipeline
+type LogPipelineConfig struct {
+	API     []LogProcessorConfig `yaml:"api"`
+	Faktory []LogProcessorConfig `yaml:"faktory"`
+	Test    []LogProcessorConfig `yaml:"test"`
+}
+
+// LogProcessorConfig names a registered log processor and the options it is built with
+type LogProcessorConfig struct {
+	Name    string              `yaml:"name"`
+	Options LogProcessorOptions `yaml:"options"`
+}
+
+// LogPipelineJob is what the processors of a job's log pipeline are built from. Client is only set in
+// the api mode and Helper only in the faktory mode, processors that ship logs use whichever is set.
+type LogPipelineJob struct {
+	Logger    zerolog.Logger
+	Job       opslevel.RunnerJob
+	RunnerId  opslevel.ID
+	Client    *opslevel.Client
+	Helper    faktoryWorker.Helper
+	LogPrefix func(record LogRecord) string
+	// Redaction are the compiled redaction rules, the built-in rules are used when it's nil
+	Redaction []*RedactionRule
+	// ShipQueue retries the logs and outcome that fail to send with Client, when it's set
+	ShipQueue *ShipQueue
+}
+
+// shipClient returns what the job's logs and outcome are sent with, nil when they aren't sent
+func (job LogPipelineJob) shipClient() ShipClient {
+	if job.ShipQueue != nil {
+		return job.ShipQueue
+	}
+	if job.Client != nil {
+		return job.Client
+	}
+	return nil
+}
+
+// LogProcessorFactory builds a processor for a job from its options. It returns a nil processor when
+// the processor should be left out of the pipeline, e.g. because it only runs at some log levels.
+type LogProcessorFactory func(job LogPipelineJob, options LogProcessorOptions) (LogProcessor, error)
+
+var logProcessors = map[string]LogProcessorFactory{}
+
+// RegisterLogProcessor makes a processor available to the log pipeline by name
+func RegisterLogProcessor(name string, factory LogProcessorFactory) {
+	logProcessors[name] = factory
+}
+
+// LogProcessorNames returns the names of the registered processors in order
+func LogProcessorNames() []string {
+	names := make([]string, 0, len(logProcessors))
+	for name := range logProcessors {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func init() {
+	RegisterLogProcessor("ansi", newAnsiLogProcessor)
+	RegisterLogProcessor("outcome", newOutcomeLogProcessor)
+	RegisterLogProcessor("sanitize", newSanitizeLogProcessor)
+	RegisterLogProcessor("redact", newRedactLogProcessor)
+	RegisterLogProcessor("quota", newQuotaLogProcessor)
+	RegisterLogProcessor("archive", newArchiveLogProcessor)
+	RegisterLogProcessor("prefix", newPrefixLogProcessor)
+	RegisterLogProcessor("logger", newLoggerLogProcessor)
+	RegisterLogProcessor("ship", newShipLogProcessor)
+}
+
+// defaultLogPipelines are the pipelines of the modes that aren't configured. They are the chains the runner
+// always had, the ansi and redact processors are opt-in.
+var defaultLogPipelines = LogPipelineConfig{
+	API: []LogProcessorConfig{
+		{Name: "outcome"},
+		{Name: "sanitize"},
+		{Name: "archive"},
+		{Name: "prefix"},
+		{Name: "ship"},
+		{Name: "logger", Options: LogProcessorOptions{"level": "trace"}},
+	},
+	Faktory: []LogProcessorConfig{
+		{Name: "outcome"},
+		{Name: "sanitize"},
+		{Name: "archive"},
+		{Name: "prefix"},
+		{Name: "ship"},
+	},
+	Test: []LogProcessorConfig{
+		{Name: "outcome"},
+		{Name: "sanitize"},
+		{Name: "archive"},
+		{Name: "logger"},
+		{Name: "ship"},
+	},
+}
+
+// ForMode returns the processors of the mode's pipeline
+func (c *LogPipelineConfig) ForMode(mode string) []LogProcessorConfig {
+	switch mode {
+	case LogPipelineModeAPI:
+		return c.API
+	case LogPipelineModeFaktory:
+		return c.Faktory
+	case LogPipelineModeTest:
+		return c.Test
+	}
+	return nil
+}
+
+// ParseLogPipelineConfig parses the logPipeline section of the config file filling in the default
+// pipeline of every mode the file doesn't configure
+func ParseLogPipelineConfig(data []byte) (*LogPipelineConfig, error) {
+	// Only the logPipeline section is decoded so that problems in other sections don't stop the pipeline from being built
+	var config struct {
+		LogPipeline LogPipelineConfig `json:"logPipeline"`
+	}
+	if err := yaml.Unmarshal(data, &config); err != nil {
+		return nil, err
+	}
+	pipeline := config.LogPipeline
+	if pipeline.API == nil {
+		pipeline.API = defaultLogPipelines.API
+	}
+	if pipeline.Faktory == nil {
+		pipeline.Faktory = defaultLogPipelines.Faktory
+	}
+	if pipeline.Test == nil {
+		pipeline.Test = defaultLogPipelines.Test
+	}
+	return &pipeline, nil
+}
+
+// ValidateLogPipelineConfig resolves the log pipeline exactly like ParseLogPipelineConfig does and
+// reports every unknown key, unknown processor and invalid processor option it can find
+func ValidateLogPipelineConfig(data []byte) (*LogPipelineConfig, []error) {
+	problems := checkLogPipelineConfigData(data)
+	config, err := ParseLogPipelineConfig(data)
+	if err != nil {
+		if len(problems) == 0 {
+			problems = append(problems, fmt.Errorf("logPipeline: %w", err))
+		}
+		return nil, problems
+	}
+	for _, mode := range []string{LogPipelineModeAPI, LogPipelineModeFaktory, LogPipelineModeTest} {
+		for i, processor := range config.ForMode(mode) {
+			factory, ok := logProcessors[processor.Name]
+			if !ok {
+				problems = append(problems, fmt.Errorf("logPipeline.%s[%d].name: '%s' is not one of %v", mode, i, processor.Name, LogProcessorNames()))
+				continue
+			}
+			if _, err := factory(LogPipelineJob{Logger: zerolog.Nop()}, processor.Options); err != nil {
+				problems = append(problems, fmt.Errorf("logPipeline.%s[%d].options: %w", mode, i, err))
+			}
+		}
+	}
+	return config, problems
+}
+
+// checkLogPipelineConfigData decodes the logPipeline section of the config strictly so that
+// misspelled keys are reported instead of silently ignored
+func checkLogPipelineConfigData(data []byte) []error {
+	sections := map[string]any{}
+	if err := yaml.Unmarshal(data, &sections); err != nil {
+		return []error{err}
+	}
+	section, ok := sections["logPipeline"]
+	if !ok {
+		return nil
+	}
+	data, err := json.Marshal(section)
+	if err != nil {
+		return []error{err}
+	}
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&LogPipelineConfig{}); err != nil {
+		return []error{fmt.Errorf("logPipeline: %w", err)}
+	}
+	return nil
+}
+
+// NewLogPipeline builds the processors of a pipeline for the job in order
+func NewLogPipeline(config []LogProcessorConfig, job LogPipelineJob) ([]LogProcessor, error) {
+	var processors []LogProcessor
+	for _, processorConfig := range config {
+		factory, ok := logProcessors[processorConfig.Name]
+		if !ok {
+			return nil, fmt.Errorf("unknown log processor '%s'", processorConfig.Name)
+		}
+		processor, err := factory(job, processorConfig.Options)
+		if err != nil {
+			return nil, fmt.Errorf("failed to build log processor '%s': %w", processorConfig.Name, err)
+		}
+		if processor != nil {
+			processors = append(processors, processor)
+		}
+	}
+	return processors, nil
+}
+
+// LogProcessorOptions are the options of a processor in the config file
+type LogProcessorOptions map[string]any
+
+// Only reports options that aren't one of keys so misspelled options don't go unnoticed
+func (o LogProcessorOptions) Only(keys ...string) error {
+	known := map[string]bool{}
+	for _, key := range keys {
+		known[key] = true
+	}
+	var unknown []string
+	for key := range o {
+		if !known[key] {
+			unknown = append(unknown, key)
+		}
+	}
+	if len(unknown) > 0 {
+		sort.Strings(unknown)
+		return fmt.Errorf("unknown options %v must be one of %v", unknown, keys)
+	}
+	return nil
+}
+
+func (o LogProcessorOptions) String(key string, fallback string) (string, error) {
+	value, ok := o[key]
+	if !ok || value == nil {
+		return fallback, nil
+	}
+	casted, ok := value.(string)
+	if !ok {
+		return "", fmt.Errorf("%s: expected a string but got '%v'", key, value)
+	}
+	return casted, nil
+}
+
+func (o LogProcessorOptions) Int(key string, fallback int) (int, error) {
+	value, ok := o[key]
+	if !ok || value == nil {
+		return fallback, nil
+	}
+	switch casted := value.(type) {
+	case int:
+		return casted, nil
+	case float64:
+		if casted == float64(int(casted)) {
+			return int(casted), nil
+		}
+	case string:
+		if parsed, err := strconv.Atoi(casted); err == nil {
+			return parsed, nil
+		}
+	}
+	return 0, fmt.Errorf("%s: expected an integer but got '%v'", key, value)
+}
+
+func (o LogProcessorOptions) Bool(key string, fallback bool) (bool, error) {
+	value, ok := o[key]
+	if !ok || value == nil {
+		return fallback, nil
+	}
+	switch casted := value.(type) {
+	case bool:
+		return casted, nil
+	case string:
+		if parsed, err := strconv.ParseBool(casted); err == nil {
+			return parsed, nil
+		}
+	}
+	return false, fmt.Errorf("%s: expected a boolean but got '%v'", key, value)
+}
+
+func (o LogProcessorOptions) Strings(key string) ([]string, error) {
+	value, ok := o[key]
+	if !ok || value == nil {
+		return nil, nil
+	}
+	switch casted := value.(type) {
+	case []string:
+		return casted, nil
+	case []any:
+		output := make([]string, 0, len(casted))
+		for _, item := range casted {
+			text, ok := item.(string)
+			if !ok {
+				return nil, fmt.Errorf("%s: expected a list of strings but got '%v'", key, value)
+			}
+			output = append(output, text)
+		}
+		return output, nil
+	}
+	return nil, fmt.Errorf("%s: expected a list of strings but got '%v'", key, value)
+}
+
+// newAnsiLogProcessor cleans up terminal output, it runs first so that escape sequences at the start
+// of a line don't hide log commands from the processors after it
+func newAnsiLogProcessor(job LogPipelineJob, options LogProcessorOptions) (LogProcessor, error) {
+	if err := options.Only("mode"); err != nil {
+		return nil, err
+	}
+	mode, err := options.String("mode", AnsiModeStrip)
+	if err != nil {
+		return nil, err
+	}
+	return NewAnsiLogProcessor(mode)
+}
+
+// newOutcomeLogProcessor captures the outcome variables the job sets and reports its outcome
+func newOutcomeLogProcessor(job LogPipelineJob, options LogProcessorOptions) (LogProcessor, error) {
+	if err := options.Only(); err != nil {
+		return nil, err
+	}
+	if job.Helper != nil {
+		return NewFaktorySetOutcomeProcessor(job.Helper, job.Logger, job.Job.Id), nil
+	}
+	return NewSetOutcomeVarLogProcessor(job.shipClient(), job.Logger, job.RunnerId, job.Job.Id, job.Job.Number()), nil
+}
+
+// newSanitizeLogProcessor masks the values of the job's sensitive variables
+func newSanitizeLogProcessor(job LogPipelineJob, options LogProcessorOptions) (LogProcessor, error) {
+	if err := options.Only(); err != nil {
+		return nil, err
+	}
+	return NewSanitizeLogProcessor(job.Job.Variables), nil
+}
+
+// newRedactLogProcessor redacts credentials matching the redaction rules from the config file
+func newRedactLogProcessor(job LogPipelineJob, options LogProcessorOptions) (LogProcessor, error) {
+	if err := options.Only(); err != nil {
+		return nil, err
+	}
+	rules := job.Redaction
+	if rules == nil {
+		var err error
+		if rules, err = NewRedactionRules(RedactionConfig{}); err != nil {
+			return nil, err
+		}
+	}
+	return NewRedactLogProcessor(rules), nil
+}
+
+// newQuotaLogProcessor limits the lines and bytes shipped for the job, it isn't part of the default
+// pipelines so that output is only ever dropped when a quota is configured
+func newQuotaLogProcessor(job LogPipelineJob, options LogProcessorOptions) (LogProcessor, error) {
+	if err := options.Only("maxLines", "maxBytes", "tailLines", "maxLineLength", "collapseRepeats", "failWhenExceeded"); err != nil {
+		return nil, err
+	}
+	config := LogQuotaConfig{}
+	limits := []struct {
+		key   string
+		value *int
+	}{
+		{"maxLines", &config.MaxLines},
+		{"maxBytes", &config.MaxBytes},
+		{"tailLines", &config.TailLines},
+		{"maxLineLength", &config.MaxLineLength},
+	}
+	for _, limit := range limits {
+		var err error
+		if *limit.value, err = options.Int(limit.key, 0); err != nil {
+			return nil, err
+		}
+		if *limit.value < 0 {
+			return nil, fmt.Errorf("%s: must not be negative but got '%d'", limit.key, *limit.value)
+		}
+	}
+	var err error
+	if config.CollapseRepeats, err = options.Bool("collapseRepeats", false); err != nil {
+		return nil, err
+	}
+	if config.FailWhenExceeded, err = options.Bool("failWhenExceeded", false); err != nil {
+		return nil, err
+	}
+	return NewQuotaLogProcessor(job.Logger, config), nil
+}
+
+// newArchiveLogProcessor writes the job's log to the archive on disk, it's left out when the archive
+// has no directory. The options default to the job-log-archive-* flags.
+func newArchiveLogProcessor(job LogPipelineJob, options LogProcessorOptions) (LogProcessor, error) {
+	if err := options.Only("directory", "maxFileSize", "maxAge", "maxSize"); err != nil {
+		return nil, err
+	}
+	directory, err := options.String("directory", viper.GetString("job-log-archive-dir"))
+	if err != nil {
+		return nil, err
+	}
+	config := LogArchiveConfig{Directory: directory}
+	if config.MaxFileSize, err = options.Int("maxFileSize", viper.GetInt("job-log-archive-max-file-size")); err != nil {
+		return nil, err
+	}
+	if config.MaxAge, err = options.Int("maxAge", viper.GetInt("job-log-archive-max-age")); err != nil {
+		return nil, err
+	}
+	if config.MaxSize, err = options.Int("maxSize", viper.GetInt("job-log-archive-max-size")); err != nil {
+		return nil, err
+	}
+	if config.Directory == "" {
+		return nil, nil
+	}
+	return NewArchiveLogProcessor(job.Logger, config, job.Job.Number()), nil
+}
+
+// newPrefixLogProcessor stamps each line with the job's log prefix, or the time the line arrived
+// when the mode has none
+func newPrefixLogProcessor(job LogPipelineJob, options LogProcessorOptions) (LogProcessor, error) {
+	if err := options.Only(); err != nil {
+		return nil, err
+	}
+	prefix := job.LogPrefix
+	if prefix == nil {
+		prefix = func(record LogRecord) string { return record.Time.UTC().Format(time.RFC3339) + " " }
+	}
+	return NewPrefixLogProcessor(prefix), nil
+}
+
+// newLoggerLogProcessor writes the job's output to the runner's log, with the 'level' option only
+// when the runner logs at that level or a more verbose one
+func newLoggerLogProcessor(job LogPipelineJob, options LogProcessorOptions) (LogProcessor, error) {
+	if err := options.Only("level"); err != nil {
+		return nil, err
+	}
+	level, err := options.String("level", "")
+	if err != nil {
+		return nil, err
+	}
+	if level != "" {
+		parsed, err := zerolog.ParseLevel(level)
+		if err != nil {
+			return nil, fmt.Errorf("level: %w", err)
+		}
+		if zerolog.GlobalLevel() > parsed {
+			return nil, nil
+		}
+	}
+	return NewLoggerLogProcessor(job.Logger), nil
+}
+
+// newShipLogProcessor ships the job's logs to OpsLevel in batches of up to 'maxBytes' bytes at least
+// every 'maxInterval' seconds, both default to the job-pod-log-max-size and job-pod-log-max-interval flags
+func newShipLogProcessor(job LogPipelineJob, options LogProcessorOptions) (LogProcessor, error) {
+	if err := options.Only("maxBytes", "maxInterval"); err != nil {
+		return nil, err
+	}
+	maxBytes, err := options.Int("maxBytes", viper.GetInt("job-pod-log-max-size"))
+	if err != nil {
+		return nil, err
+	}
+	maxInterval, err := options.Int("maxInterval", viper.GetInt("job-pod-log-max-interval"))
+	if err != nil {
+		return nil, err
+	}
+	maxTime := time.Duration(maxInterval) * time.Second
+	if job.Helper != nil {
+		return NewFaktoryAppendJobLogProcessor(job.Helper, job.Logger, job.Job.Id, maxBytes, maxTime), nil
+	}
+	return NewOpsLevelAppendLogProcessor(job.shipClient(), job.Logger, job.RunnerId, job.Job.Id, job.Job.Number(), maxBytes, maxTime), nil
+}
@@ -0,0 +1,134 @@
+package pkg
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/rocktavious/autopilot/v2023"
+	"github.com/rs/zerolog"
+)
+
+func processorNames(processors []LogProcessor) []string {
+	output := make([]string, 0, len(processors))
+	for _, processor := range processors {
+		output = append(output, fmt.Sprintf("%T", processor))
+	}
+	return output
+}
+
+func TestParseLogPipelineConfig_Defaults(t *testing.T) {
+	// Act
+	config, err := ParseLogPipelineConfig(nil)
+	// Assert
+	autopilot.Ok(t, err)
+	autopilot.Equals(t, defaultLogPipelines, *config)
+}
+
+func TestParseLogPipelineConfig_OverridesConfiguredModes(t *testing.T) {
+	// Arrange
+	config := []byte(`
+logPipeline:
+  faktory:
+    - name: sanitize
+    - name: ship
+      options:
+        maxBytes: 2048
+`)
+	// Act
+	pipeline, err := ParseLogPipelineConfig(config)
+	// Assert
+	autopilot.Ok(t, err)
+	autopilot.Equals(t, defaultLogPipelines.API, pipeline.API)
+	autopilot.Equals(t, defaultLogPipelines.Test, pipeline.Test)
+	autopilot.Equals(t, []LogProcessorConfig{
+		{Name: "sanitize"},
+		{Name: "ship", Options: LogProcessorOptions{"maxBytes": float64(2048)}},
+	}, pipeline.Faktory)
+}
+
+func TestValidateLogPipelineConfig(t *testing.T) {
+	// Arrange
+	config := []byte(`
+logPipeline:
+  api:
+    - name: outcome
+    - name: scrub
+    - name: logger
+      options:
+        level: loud
+  test:
+    - name: ship
+      options:
+        maxInterval: soon
+`)
+	// Act
+	_, problems := ValidateLogPipelineConfig(config)
+	// Assert
+	autopilot.Equals(t, []string{
+		"logPipeline.api[1].name: 'scrub' is not one of [ansi archive logger outcome prefix quota redact sanitize ship]",
+		"logPipeline.api[2].options: level: Unknown Level String: 'loud', defaulting to NoLevel",
+		"logPipeline.test[0].options: maxInterval: expected an integer but got 'soon'",
+	}, problemMessages(problems))
+}
+
+func TestValidateLogPipelineConfig_UnknownKey(t *testing.T) {
+	// Arrange
+	config := []byte(`
+logPipeline:
+  apii: []
+`)
+	// Act
+	_, problems := ValidateLogPipelineConfig(config)
+	// Assert
+	autopilot.Equals(t, []string{`logPipeline: json: unknown field "apii"`}, problemMessages(problems))
+}
+
+func TestNewLogPipeline(t *testing.T) {
+	// Arrange
+	level := zerolog.GlobalLevel()
+	zerolog.SetGlobalLevel(zerolog.InfoLevel)
+	defer zerolog.SetGlobalLevel(level)
+	job := LogPipelineJob{Logger: zerolog.Nop()}
+	// Act
+	api, apiErr := NewLogPipeline(defaultLogPipelines.API, job)
+	test, testErr := NewLogPipeline(defaultLogPipelines.Test, job)
+	_, unknownErr := NewLogPipeline([]LogProcessorConfig{{Name: "unknown"}}, job)
+	// Assert
+	autopilot.Ok(t, apiErr)
+	autopilot.Ok(t, testErr)
+	autopilot.Equals(t, []string{
+		"*pkg.SetOutcomeVarLogProcessor",
+		"*pkg.SanitizeLogProcessor",
+		"*pkg.PrefixLogProcessor",
+		"*pkg.OpsLevelAppendLogProcessor",
+	}, processorNames(api))
+	autopilot.Equals(t, []string{
+		"*pkg.SetOutcomeVarLogProcessor",
+		"*pkg.SanitizeLogProcessor",
+		"*pkg.LoggerLogProcessor",
+		"*pkg.OpsLevelAppendLogProcessor",
+	}, processorNames(test))
+	autopilot.Equals(t, "unknown log processor 'unknown'", unknownErr.Error())
+}
+
+func TestLogProcessorOptions(t *testing.T) {
+	// Arrange
+	options := LogProcessorOptions{"size": float64(10), "enabled": "true", "words": []any{"a", "b"}, "name": 1}
+	// Act
+	size, sizeErr := options.Int("size", 0)
+	fallback, _ := options.Int("missing", 5)
+	enabled, enabledErr := options.Bool("enabled", false)
+	words, wordsErr := options.Strings("words")
+	_, nameErr := options.String("name", "")
+	onlyErr := options.Only("size", "enabled")
+	// Assert
+	autopilot.Ok(t, sizeErr)
+	autopilot.Ok(t, enabledErr)
+	autopilot.Ok(t, wordsErr)
+	autopilot.Equals(t, 10, size)
+	autopilot.Equals(t, 5, fallback)
+	autopilot.Equals(t, true, enabled)
+	autopilot.Equals(t, []string{"a", "b"}, words)
+	autopilot.Equals(t, "name: expected a string but got '1'", nameErr.Error())
+	autopilot.Equals(t, "unknown options [name words] must be one of [size enabled]", onlyErr.Error())
+}
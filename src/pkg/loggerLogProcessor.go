@@ -21,12 +21,22 @@ func (s *LoggerLogProcessor) Process(line string) string {
 	return line
 }
 
+// ProcessRecord logs the line and marks the lines the job wrote to stderr so they stand out
+func (s *LoggerLogProcessor) ProcessRecord(record LogRecord) LogRecord {
+	if record.Stream != LogStreamStderr {
+		s.Process(record.Text)
+	} else if len(record.Text) > 0 {
+		s.logger.Info().Str("stream", string(LogStreamStderr)).Msg(record.Text)
+	}
+	return record
+}
+
 func (s *LoggerLogProcessor) ProcessStdout(line string) string {
 	return s.Process(line)
 }
 
 func (s *LoggerLogProcessor) ProcessStderr(line string) string {
-	return s.Process(line)
+	return s.ProcessRecord(LogRecord{Text: line, Stream: LogStreamStderr}).Text
 }
 
 func (s *LoggerLogProcessor) Flush(outcome JobOutcome) {}
@@ -1,37 +1,279 @@
 package pkg
 
 import (
+	"bytes"
 	"container/ring"
 	"context"
-	"strings"
+	"fmt"
+	"io"
+	"sync"
 	"time"
 
+	"github.com/opslevel/opslevel-go/v2026"
 	"github.com/rs/zerolog"
 )
 
+const (
+	// LogBufferPolicyBlock makes writers wait for the processors when the buffer is full, which slows
+	// down the exec stream and with it the job's output
+	LogBufferPolicyBlock = "block"
+	// LogBufferPolicyDrop drops lines when the buffer is full and reports how many were dropped
+	LogBufferPolicyDrop = "drop"
+)
+
+// LogBufferConfig bounds how much of a job's output the streamer holds in memory while it waits to
+// be processed. A MaxBytes of 0 or less leaves the buffer unbounded.
+type LogBufferConfig struct {
+	MaxBytes int
+	Policy   string
+}
+
+const (
+	LogStreamStdout LogStream = "stdout"
+	LogStreamStderr LogStream = "stderr"
+	// LogStepInit labels the output of the job's init commands and LogStepMain the output of its commands
+	LogStepInit = "init"
+	LogStepMain = "main"
+)
+
+type LogStream string
+
+// LogRecord is a line of a job's output with where and when it was written
+type LogRecord struct {
+	Text   string
+	Stream LogStream
+	// Time is when the line arrived at the runner, not when it was processed
+	Time time.Time
+	// Sequence orders the records of a job across both streams starting at 1
+	Sequence uint64
+	Step     string
+	JobId    opslevel.ID
+	// Partial is set when a line was too long for the buffer and continues in the next record of its stream
+	Partial bool
+	// Omitted is set by a processor that drops the record, the processors after it never see it
+	Omitted bool
+}
+
 type LogProcessor interface {
 	ProcessStdout(line string) string
 	ProcessStderr(line string) string
 	Flush(outcome JobOutcome)
 }
 
+// LogRecordProcessor is implemented by processors that need more than the text of a line, the
+// streamer passes them the whole record instead of calling ProcessStdout or ProcessStderr
+type LogRecordProcessor interface {
+	ProcessRecord(record LogRecord) LogRecord
+}
+
+// LogRecordInserter is implemented by record processors that add records of their own to the job's
+// output, e.g. markers for lines they dropped. The streamer passes the inserted records to the processors
+// after it: the ones from Inserted before the record that was just processed and the ones from Remaining
+// after the job's last record.
+type LogRecordInserter interface {
+	LogRecordProcessor
+	Inserted() []LogRecord
+	Remaining() []LogRecord
+}
+
+// LogOutcomeAmender is implemented by processors that change the outcome of the job, e.g. to fail it
+// because of its output. The outcome is amended before any processor is flushed with it.
+type LogOutcomeAmender interface {
+	AmendOutcome(outcome JobOutcome) JobOutcome
+}
+
+// LogStepSetter is implemented by writers that label the lines written to them with the step of the job
+// that wrote them
+type LogStepSetter interface {
+	SetStep(step string)
+}
+
+// setLogStep labels the lines written to writer from now on with step if the writer supports it
+func setLogStep(writer io.Writer, step string) {
+	if setter, ok := writer.(LogStepSetter); ok {
+		setter.SetStep(step)
+	}
+}
+
+// logQueue holds the lines waiting for the processors within the configured number of bytes
+type logQueue struct {
+	mutex        sync.Mutex
+	config       LogBufferConfig
+	jobId        opslevel.ID
+	step         string
+	sequence     uint64
+	lines        []LogRecord
+	bytes        int
+	highWater    int
+	dropped      int
+	droppedBytes int
+	closed       bool
+	// running is set once Run processes the queue and stopped once Close gave up waiting for it
+	running bool
+	stopped bool
+	ready   chan struct{} // signalled when lines are added or the queue is closed
+	space   chan struct{} // signalled when lines are removed
+}
+
+func newLogQueue() *logQueue {
+	return &logQueue{ready: make(chan struct{}, 1), space: make(chan struct{}, 1)}
+}
+
+func notify(ch chan struct{}) {
+	select {
+	case ch <- struct{}{}:
+	default:
+	}
+}
+
+// fits reports whether the line fits in the buffer, a line always fits in an empty buffer so that
+// lines longer than the bound can't get stuck
+func (q *logQueue) fits(size int) bool {
+	return q.config.MaxBytes <= 0 || q.bytes == 0 || q.bytes+size <= q.config.MaxBytes
+}
+
+// append stamps the record with the job, step and next sequence number and queues it
+func (q *logQueue) append(record LogRecord) {
+	q.sequence++
+	record.Sequence = q.sequence
+	record.Step = q.step
+	record.JobId = q.jobId
+	q.lines = append(q.lines, record)
+	q.bytes += len(record.Text)
+}
+
+// dropMarker reports the lines dropped since the last line that fit
+func (q *logQueue) dropMarker(stream LogStream, at time.Time) LogRecord {
+	marker := LogRecord{Text: fmt.Sprintf("[%d lines dropped]", q.dropped), Stream: stream, Time: at}
+	q.dropped = 0
+	return marker
+}
+
+// add appends the line, preceded by a marker for the lines dropped since the last line that fit
+func (q *logQueue) add(line LogRecord) {
+	if q.dropped > 0 {
+		q.append(q.dropMarker(line.Stream, line.Time))
+	}
+	q.append(line)
+	q.highWater = max(q.highWater, q.bytes)
+	notify(q.ready)
+}
+
+// push adds the line once it fits in the buffer or drops it, depending on the policy. It gives up
+// when the streamer has stopped or the context is done.
+func (q *logQueue) push(ctx context.Context, line LogRecord, done <-chan struct{}) {
+	for {
+		q.mutex.Lock()
+		if q.fits(len(line.Text)) {
+			q.add(line)
+			q.mutex.Unlock()
+			return
+		}
+		if q.config.Policy == LogBufferPolicyDrop {
+			q.dropped++
+			q.droppedBytes += len(line.Text)
+			q.mutex.Unlock()
+			if MetricLogDroppedBytes != nil {
+				MetricLogDroppedBytes.Add(float64(len(line.Text)))
+			}
+			return
+		}
+		q.mutex.Unlock()
+		select {
+		case <-q.space:
+		case <-done:
+			return
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// pop returns the next line, waiting for one until the queue is closed and empty or the context is done
+func (q *logQueue) pop(ctx context.Context) (LogRecord, bool) {
+	for {
+		q.mutex.Lock()
+		if q.stopped {
+			q.mutex.Unlock()
+			return LogRecord{}, false
+		}
+		if len(q.lines) > 0 {
+			line := q.lines[0]
+			q.lines[0] = LogRecord{}
+			q.lines = q.lines[1:]
+			q.bytes -= len(line.Text)
+			q.mutex.Unlock()
+			notify(q.space)
+			return line, true
+		}
+		if q.closed {
+			q.mutex.Unlock()
+			return LogRecord{}, false
+		}
+		q.mutex.Unlock()
+		select {
+		case <-q.ready:
+		case <-ctx.Done():
+			return LogRecord{}, false
+		}
+	}
+}
+
+// close rejects further lines after reporting any that were dropped since the last line that fit
+func (q *logQueue) close() {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+	if q.dropped > 0 {
+		q.append(q.dropMarker(LogStreamStdout, time.Now()))
+	}
+	q.closed = true
+	notify(q.ready)
+}
+
+// start marks the queue as processed by Run, it reports false when Close already stopped waiting for it
+func (q *logQueue) start() bool {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+	q.running = !q.stopped
+	return q.running
+}
+
+// stop makes Run return before it processes another line and reports whether Run is processing the queue
+func (q *logQueue) stop() bool {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+	q.stopped = true
+	notify(q.ready)
+	return q.running
+}
+
+// LogStreamer hands every line written to Stdout and Stderr to the processors in the order the
+// lines were written. Run processes the lines as they arrive and Close waits until all of them
+// have been processed before flushing the processors.
 type LogStreamer struct {
-	Stdout     *SafeBuffer
-	Stderr     *SafeBuffer
+	Stdout     io.Writer
+	Stderr     io.Writer
 	processors []LogProcessor
 	logger     zerolog.Logger
-	quit       chan bool
+	writers    []*lineWriter
+	queue      *logQueue
+	done       chan struct{}
 	logBuffer  *ring.Ring
 }
 
 func NewLogStreamer(logger zerolog.Logger, processors ...LogProcessor) LogStreamer {
-	quit := make(chan bool)
+	queue := newLogQueue()
+	done := make(chan struct{})
+	stdout := &lineWriter{queue: queue, done: done, stream: LogStreamStdout}
+	stderr := &lineWriter{queue: queue, done: done, stream: LogStreamStderr}
 	return LogStreamer{
-		Stdout:     &SafeBuffer{},
-		Stderr:     &SafeBuffer{},
+		Stdout:     stdout,
+		Stderr:     stderr,
 		processors: processors,
 		logger:     logger,
-		quit:       quit,
+		writers:    []*lineWriter{stderr, stdout},
+		queue:      queue,
+		done:       done,
 		logBuffer:  ring.New(20),
 	}
 }
@@ -40,31 +282,124 @@ func (s *LogStreamer) AddProcessor(processor LogProcessor) {
 	s.processors = append(s.processors, processor)
 }
 
-type logStream struct {
-	buf *SafeBuffer
-	fn  func(LogProcessor, string) string
+// SetBuffer bounds the output held in memory, it must be called before anything is written
+func (s *LogStreamer) SetBuffer(config LogBufferConfig) {
+	s.queue.mutex.Lock()
+	defer s.queue.mutex.Unlock()
+	s.queue.config = config
+}
+
+// SetJobId labels the records of the job's output with its id
+func (s *LogStreamer) SetJobId(jobId opslevel.ID) {
+	s.queue.mutex.Lock()
+	defer s.queue.mutex.Unlock()
+	s.queue.jobId = jobId
 }
 
-func (s *LogStreamer) streams() []logStream {
-	return []logStream{
-		{s.Stderr, LogProcessor.ProcessStderr},
-		{s.Stdout, LogProcessor.ProcessStdout},
+// lineWriter splits what is written to it into lines and sends them to the streamer. Output without
+// a newline is split into lines of the buffer's max size so that it can't grow without a bound.
+type lineWriter struct {
+	mutex   sync.Mutex
+	partial []byte
+	stream  LogStream
+	closed  bool
+	queue   *logQueue
+	done    <-chan struct{}
+}
+
+func (w *lineWriter) Write(p []byte) (int, error) {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	if w.closed {
+		return 0, io.ErrClosedPipe
 	}
+	w.partial = append(w.partial, p...)
+	w.queue.mutex.Lock()
+	maxBytes := w.queue.config.MaxBytes
+	w.queue.mutex.Unlock()
+	start := 0
+	for {
+		index := bytes.IndexByte(w.partial[start:], '\n')
+		if index < 0 {
+			if maxBytes > 0 && len(w.partial)-start >= maxBytes {
+				w.send(context.Background(), string(w.partial[start:start+maxBytes]), true)
+				start += maxBytes
+				continue
+			}
+			break
+		}
+		w.send(context.Background(), string(w.partial[start:start+index]), false)
+		start += index + 1
+	}
+	w.partial = append(w.partial[:0], w.partial[start:]...)
+	return len(p), nil
+}
+
+func (w *lineWriter) send(ctx context.Context, text string, partial bool) {
+	w.queue.push(ctx, LogRecord{Text: text, Stream: w.stream, Time: time.Now(), Partial: partial}, w.done)
+}
+
+// SetStep labels the lines written to both of the streamer's writers from now on with step
+func (w *lineWriter) SetStep(step string) {
+	w.queue.mutex.Lock()
+	defer w.queue.mutex.Unlock()
+	w.queue.step = step
 }
 
-func (s *LogStreamer) processLine(stream logStream) {
-	line, _ := stream.buf.ReadString('\n')
-	if line == "" {
+// close sends the last line if it never received a terminating newline and rejects further writes
+func (w *lineWriter) close(ctx context.Context) {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	if w.closed {
 		return
 	}
-	line = strings.TrimSuffix(line, "\n")
-	for _, processor := range s.processors {
-		line = stream.fn(processor, line)
+	if len(w.partial) > 0 {
+		w.send(ctx, string(w.partial), false)
+		w.partial = nil
 	}
-	s.logBuffer.Value = line
+	w.closed = true
+}
+
+func (s *LogStreamer) processRecord(record LogRecord) {
+	s.processRecordFrom(0, record)
+}
+
+// processRecordFrom hands the record to the processors starting at the one at index start
+func (s *LogStreamer) processRecordFrom(start int, record LogRecord) {
+	for i := start; i < len(s.processors); i++ {
+		processor := s.processors[i]
+		if recordProcessor, ok := processor.(LogRecordProcessor); ok {
+			record = recordProcessor.ProcessRecord(record)
+		} else if record.Stream == LogStreamStderr {
+			record.Text = processor.ProcessStderr(record.Text)
+		} else {
+			record.Text = processor.ProcessStdout(record.Text)
+		}
+		if inserter, ok := processor.(LogRecordInserter); ok {
+			for _, inserted := range inserter.Inserted() {
+				s.processRecordFrom(i+1, inserted)
+			}
+		}
+		if record.Omitted {
+			return
+		}
+	}
+	s.logBuffer.Value = record.Text
 	s.logBuffer = s.logBuffer.Next()
 }
 
+// processRemaining hands the records the processors held back until the end of the job's output to
+// the processors after them
+func (s *LogStreamer) processRemaining() {
+	for i, processor := range s.processors {
+		if inserter, ok := processor.(LogRecordInserter); ok {
+			for _, remaining := range inserter.Remaining() {
+				s.processRecordFrom(i+1, remaining)
+			}
+		}
+	}
+}
+
 func (s *LogStreamer) GetLogBuffer() []string {
 	output := make([]string, 0)
 	s.logBuffer.Do(func(line any) {
@@ -75,52 +410,68 @@ func (s *LogStreamer) GetLogBuffer() []string {
 	return output
 }
 
+// Run processes lines as they are written until Close is called. Cancelling the context stops
+// processing immediately and drops any lines still waiting.
 func (s *LogStreamer) Run(ctx context.Context) {
 	s.logger.Trace().Msg("Starting log streamer ...")
-	ticker := time.NewTicker(50 * time.Millisecond)
-	defer ticker.Stop()
+	defer close(s.done)
+	if !s.queue.start() {
+		return
+	}
 	for {
-		select {
-		case <-ctx.Done():
-			s.logger.Trace().Msg("Shutting down log streamer ...")
-			return
-		case <-s.quit:
+		record, ok := s.queue.pop(ctx)
+		if !ok {
 			s.logger.Trace().Msg("Shutting down log streamer ...")
 			return
-		case <-ticker.C:
-			for _, stream := range s.streams() {
-				for strings.Contains(stream.buf.String(), "\n") {
-					s.processLine(stream)
-				}
-			}
 		}
+		s.processRecord(record)
 	}
 }
 
-func (s *LogStreamer) Flush(outcome JobOutcome) {
+// Close stops accepting writes, waits until Run has processed every line written and then flushes
+// the processors with the job's outcome, which it returns as amended by the processors. If the context is done first Run is stopped once it's done
+// with the line it's processing, the remaining lines are dropped but the processors are still flushed.
+func (s *LogStreamer) Close(ctx context.Context, outcome JobOutcome) JobOutcome {
 	s.logger.Trace().Msg("Starting log streamer flush ...")
-	ticker := time.NewTicker(200 * time.Millisecond)
-	defer ticker.Stop()
-	timeout := time.After(30 * time.Second)
-	for strings.Contains(s.Stderr.String(), "\n") || strings.Contains(s.Stdout.String(), "\n") {
-		select {
-		case <-ticker.C:
-			// Continue waiting
-		case <-timeout:
-			s.logger.Warn().Msg("Flush timeout reached, proceeding with remaining data")
-			goto done
+	for _, writer := range s.writers {
+		writer.close(ctx)
+	}
+	s.queue.close()
+	select {
+	case <-s.done:
+	case <-ctx.Done():
+		s.logger.Warn().Msg("Flush timeout reached, dropping the lines that are still waiting")
+		// The processors aren't safe to use from Close while Run still uses them
+		if s.queue.stop() {
+			<-s.done
 		}
 	}
-done:
-	s.logger.Trace().Msg("Finished log streamer flush ...")
-	s.quit <- true
-	time.Sleep(200 * time.Millisecond) // Allow 'Run' goroutine to quit
-	// Drain any partial line that never received a terminating newline.
-	for _, stream := range s.streams() {
-		s.processLine(stream)
+	s.processRemaining()
+	s.queue.mutex.Lock()
+	highWater, dropped := s.queue.highWater, s.queue.droppedBytes
+	s.queue.mutex.Unlock()
+	if dropped > 0 {
+		s.logger.Warn().Msgf("Dropped %d bytes of job output because the log buffer was full", dropped)
+	}
+	if MetricLogBufferHighWater != nil {
+		MetricLogBufferHighWater.Observe(float64(highWater))
+	}
+	for _, processor := range s.processors {
+		if amender, ok := processor.(LogOutcomeAmender); ok {
+			outcome = amender.AmendOutcome(outcome)
+		}
 	}
 	s.logger.Trace().Msg("Flushing log processors ...")
 	for i := len(s.processors) - 1; i >= 0; i-- {
 		s.processors[i].Flush(outcome)
 	}
+	return outcome
+}
+
+// Flush closes the streamer allowing up to 30 seconds for the remaining lines to be processed and
+// returns the amended outcome
+func (s *LogStreamer) Flush(outcome JobOutcome) JobOutcome {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	return s.Close(ctx, outcome)
 }
@@ -2,6 +2,9 @@ package pkg
 
 import (
 	"context"
+	"fmt"
+	"io"
+	"sync"
 	"testing"
 	"time"
 
@@ -64,3 +67,276 @@ func TestLogStreamerPartialLineStderr(t *testing.T) {
 
 	autopilot.Equals(t, []string{"partial", "trailing-no-newline"}, cap.lines)
 }
+
+type streamCaptureProcessor struct {
+	lines   []string
+	flushed bool
+}
+
+func (c *streamCaptureProcessor) ProcessStdout(line string) string {
+	c.lines = append(c.lines, "stdout: "+line)
+	return line
+}
+
+func (c *streamCaptureProcessor) ProcessStderr(line string) string {
+	c.lines = append(c.lines, "stderr: "+line)
+	return line
+}
+
+func (c *streamCaptureProcessor) Flush(_ JobOutcome) {
+	c.flushed = true
+}
+
+func TestLogStreamerCloseProcessesEveryLineInOrder(t *testing.T) {
+	// Arrange
+	cap := &streamCaptureProcessor{}
+	s := NewLogStreamer(zerolog.Nop(), cap)
+	go s.Run(context.Background())
+	var expected []string
+	// Act
+	for i := range 3000 {
+		if i%2 == 0 {
+			_, _ = fmt.Fprintf(s.Stdout, "line %d\n", i)
+			expected = append(expected, fmt.Sprintf("stdout: line %d", i))
+		} else {
+			_, _ = fmt.Fprintf(s.Stderr, "line %d\n", i)
+			expected = append(expected, fmt.Sprintf("stderr: line %d", i))
+		}
+	}
+	s.Close(context.Background(), JobOutcome{})
+	// Assert
+	autopilot.Equals(t, expected, cap.lines)
+	autopilot.Equals(t, true, cap.flushed)
+}
+
+func TestLogStreamerWriteAfterClose(t *testing.T) {
+	// Arrange
+	s := NewLogStreamer(zerolog.Nop())
+	go s.Run(context.Background())
+	s.Close(context.Background(), JobOutcome{})
+	// Act
+	_, err := s.Stdout.Write([]byte("late\n"))
+	// Assert
+	autopilot.Equals(t, io.ErrClosedPipe, err)
+}
+
+func TestLogStreamerCloseTimeout(t *testing.T) {
+	// Arrange: Run was never started so nothing drains the lines
+	cap := &streamCaptureProcessor{}
+	s := NewLogStreamer(zerolog.Nop(), cap)
+	_, _ = s.Stdout.Write([]byte("unprocessed\n"))
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	// Act
+	s.Close(ctx, JobOutcome{})
+	// Assert
+	autopilot.Equals(t, 0, len(cap.lines))
+	autopilot.Equals(t, true, cap.flushed)
+}
+
+// slowProcessor takes a while with every line and records whether it was flushed in the middle of one
+type slowProcessor struct {
+	mutex       sync.Mutex
+	processing  bool
+	lines       int
+	flushedMid  bool
+	flushedWith int
+}
+
+func (c *slowProcessor) ProcessStdout(line string) string {
+	c.mutex.Lock()
+	c.processing = true
+	c.mutex.Unlock()
+	time.Sleep(50 * time.Millisecond)
+	c.mutex.Lock()
+	c.processing = false
+	c.lines++
+	c.mutex.Unlock()
+	return line
+}
+
+func (c *slowProcessor) ProcessStderr(line string) string {
+	return c.ProcessStdout(line)
+}
+
+func (c *slowProcessor) Flush(_ JobOutcome) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.flushedMid = c.processing
+	c.flushedWith = c.lines
+}
+
+func TestLogStreamerCloseTimeoutStopsRun(t *testing.T) {
+	// Arrange
+	cap := &slowProcessor{}
+	s := NewLogStreamer(zerolog.Nop(), cap)
+	go s.Run(context.Background())
+	_, _ = s.Stdout.Write([]byte("1\n2\n3\n4\n5\n6\n7\n8\n9\n10\n"))
+	ctx, cancel := context.WithTimeout(context.Background(), 75*time.Millisecond)
+	defer cancel()
+	// Act
+	s.Close(ctx, JobOutcome{})
+	// Assert: Run finished the line it was processing, then stopped before the processors were flushed
+	cap.mutex.Lock()
+	defer cap.mutex.Unlock()
+	autopilot.Equals(t, false, cap.flushedMid)
+	autopilot.Equals(t, cap.lines, cap.flushedWith)
+	autopilot.Assert(t, cap.lines < 10, "Run should stop processing lines after the timeout")
+}
+
+func TestLogStreamerDropsLinesWhenBufferIsFull(t *testing.T) {
+	// Arrange: Run isn't started until after the writes so the buffer fills up
+	cap := &streamCaptureProcessor{}
+	s := NewLogStreamer(zerolog.Nop(), cap)
+	s.SetBuffer(LogBufferConfig{MaxBytes: 12, Policy: LogBufferPolicyDrop})
+	// Act
+	_, _ = s.Stdout.Write([]byte("line 1\nline 2\nline 3\nline 4\n"))
+	go s.Run(context.Background())
+	s.Close(context.Background(), JobOutcome{})
+	_, _ = s.Stdout.Write([]byte("late\n"))
+	// Assert
+	autopilot.Equals(t, []string{"stdout: line 1", "stdout: line 2", "stdout: [2 lines dropped]"}, cap.lines)
+	autopilot.Equals(t, 12, s.queue.droppedBytes)
+	autopilot.Equals(t, 12, s.queue.highWater)
+}
+
+func TestLogStreamerDropMarkerPrecedesNextLine(t *testing.T) {
+	// Arrange
+	cap := &streamCaptureProcessor{}
+	s := NewLogStreamer(zerolog.Nop(), cap)
+	s.SetBuffer(LogBufferConfig{MaxBytes: 6, Policy: LogBufferPolicyDrop})
+	_, _ = s.Stdout.Write([]byte("line 1\nline 2\n"))
+	go s.Run(context.Background())
+	for {
+		s.queue.mutex.Lock()
+		drained := s.queue.bytes == 0
+		s.queue.mutex.Unlock()
+		if drained {
+			break
+		}
+		time.Sleep(time.Millisecond)
+	}
+	// Act
+	_, _ = s.Stderr.Write([]byte("line 3\n"))
+	s.Close(context.Background(), JobOutcome{})
+	// Assert
+	autopilot.Equals(t, []string{"stdout: line 1", "stderr: [1 lines dropped]", "stderr: line 3"}, cap.lines)
+}
+
+func TestLogStreamerBlocksWhenBufferIsFull(t *testing.T) {
+	// Arrange
+	cap := &streamCaptureProcessor{}
+	s := NewLogStreamer(zerolog.Nop(), cap)
+	s.SetBuffer(LogBufferConfig{MaxBytes: 6, Policy: LogBufferPolicyBlock})
+	written := make(chan struct{})
+	// Act
+	go func() {
+		_, _ = s.Stdout.Write([]byte("line 1\nline 2\n"))
+		close(written)
+	}()
+	blocked := false
+	select {
+	case <-written:
+	case <-time.After(50 * time.Millisecond):
+		blocked = true
+	}
+	go s.Run(context.Background())
+	<-written
+	s.Close(context.Background(), JobOutcome{})
+	// Assert
+	autopilot.Equals(t, true, blocked)
+	autopilot.Equals(t, []string{"stdout: line 1", "stdout: line 2"}, cap.lines)
+	autopilot.Equals(t, 0, s.queue.droppedBytes)
+}
+
+func TestLogStreamerSplitsLinesLongerThanBuffer(t *testing.T) {
+	// Arrange
+	cap := &streamCaptureProcessor{}
+	s := NewLogStreamer(zerolog.Nop(), cap)
+	s.SetBuffer(LogBufferConfig{MaxBytes: 4, Policy: LogBufferPolicyBlock})
+	go s.Run(context.Background())
+	// Act
+	_, _ = s.Stdout.Write([]byte("abcdefghij"))
+	s.Close(context.Background(), JobOutcome{})
+	// Assert
+	autopilot.Equals(t, []string{"stdout: abcd", "stdout: efgh", "stdout: ij"}, cap.lines)
+}
+
+type recordCaptureProcessor struct {
+	captureProcessor
+	records   []LogRecord
+	processed []time.Time
+}
+
+func (c *recordCaptureProcessor) ProcessRecord(record LogRecord) LogRecord {
+	c.records = append(c.records, record)
+	c.processed = append(c.processed, time.Now())
+	return record
+}
+
+func TestLogStreamerRecords(t *testing.T) {
+	// Arrange
+	cap := &recordCaptureProcessor{}
+	s := NewLogStreamer(zerolog.Nop(), cap)
+	s.SetJobId("job-1")
+	start := time.Now()
+	// Act
+	setLogStep(s.Stdout, LogStepInit)
+	_, _ = s.Stdout.Write([]byte("cloning\n"))
+	setLogStep(s.Stdout, LogStepMain)
+	_, _ = s.Stderr.Write([]byte("+ make\n"))
+	_, _ = s.Stdout.Write([]byte("done\n"))
+	time.Sleep(10 * time.Millisecond)
+	go s.Run(context.Background())
+	s.Close(context.Background(), JobOutcome{})
+	// Assert
+	autopilot.Equals(t, 0, len(cap.lines))
+	autopilot.Equals(t, 3, len(cap.records))
+	for i, expected := range []LogRecord{
+		{Text: "cloning", Stream: LogStreamStdout, Sequence: 1, Step: LogStepInit, JobId: "job-1"},
+		{Text: "+ make", Stream: LogStreamStderr, Sequence: 2, Step: LogStepMain, JobId: "job-1"},
+		{Text: "done", Stream: LogStreamStdout, Sequence: 3, Step: LogStepMain, JobId: "job-1"},
+	} {
+		record := cap.records[i]
+		autopilot.Equals(t, true, !record.Time.Before(start) && cap.processed[i].Sub(record.Time) >= 10*time.Millisecond)
+		record.Time = time.Time{}
+		autopilot.Equals(t, expected, record)
+	}
+}
+
+func TestPrefixLogProcessorUsesArrivalTime(t *testing.T) {
+	// Arrange
+	arrived := time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC)
+	p := NewPrefixLogProcessor(func(record LogRecord) string {
+		prefix := record.Time.Format(time.RFC3339) + " "
+		if record.Stream == LogStreamStderr {
+			prefix += "[stderr] "
+		}
+		return prefix
+	})
+	// Act
+	stdout := p.ProcessRecord(LogRecord{Text: "hello", Stream: LogStreamStdout, Time: arrived})
+	stderr := p.ProcessRecord(LogRecord{Text: "oops", Stream: LogStreamStderr, Time: arrived})
+	// Assert
+	autopilot.Equals(t, "2026-01-02T03:04:05Z hello", stdout.Text)
+	autopilot.Equals(t, "2026-01-02T03:04:05Z [stderr] oops", stderr.Text)
+	autopilot.Equals(t, LogStreamStderr, stderr.Stream)
+}
+
+func TestLogStreamerMarksSplitLinesPartial(t *testing.T) {
+	// Arrange
+	cap := &recordCaptureProcessor{}
+	s := NewLogStreamer(zerolog.Nop(), cap)
+	s.SetBuffer(LogBufferConfig{MaxBytes: 4, Policy: LogBufferPolicyBlock})
+	go s.Run(context.Background())
+	// Act
+	_, _ = s.Stdout.Write([]byte("abcdefghij"))
+	_, _ = s.Stdout.Write([]byte("\nkl\n"))
+	s.Close(context.Background(), JobOutcome{})
+	// Assert
+	partial := []bool{}
+	for _, record := range cap.records {
+		partial = append(partial, record.Partial)
+	}
+	autopilot.Equals(t, []bool{true, true, false, false}, partial)
+}
@@ -20,6 +20,21 @@ var (
 	MetricJobsProcessing     prometheus.Gauge
 	MetricEnqueueFailed      prometheus.Counter
 	MetricEnqueueBatchFailed prometheus.Counter
+	MetricCacheEvictions     prometheus.Counter
+	MetricCacheSize          prometheus.Gauge
+	MetricExecSessions       *prometheus.CounterVec
+	MetricJobsPeakMemory     prometheus.Histogram
+	MetricJobsCPUSeconds     prometheus.Histogram
+	MetricJobsOOMKilled      prometheus.Counter
+	MetricJobsOOMKills       prometheus.Counter
+	MetricLogDroppedBytes    prometheus.Counter
+	MetricLogBufferHighWater prometheus.Histogram
+	MetricLogRedactions      *prometheus.CounterVec
+	MetricLogQuotaExceeded   prometheus.Counter
+	MetricShipQueueDepth     prometheus.Gauge
+	MetricShipQueueAge       prometheus.Gauge
+	MetricShipRetries        prometheus.Counter
+	MetricShipDeadLetters    prometheus.Counter
 )
 
 func initMetrics(id string) {
@@ -61,6 +76,101 @@ func initMetrics(id string) {
 		Help:        "The count of jobs that failed to enqueue to faktory for a batch.",
 		ConstLabels: prometheus.Labels{"runner": id},
 	})
+	MetricCacheEvictions = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace:   metricNamespace,
+		Name:        "cache_evictions",
+		Help:        "The count of job caches evicted from the cache volume.",
+		ConstLabels: prometheus.Labels{"runner": id},
+	})
+	MetricCacheSize = promauto.NewGauge(prometheus.GaugeOpts{
+		Namespace:   metricNamespace,
+		Name:        "cache_size_bytes",
+		Help:        "The total size of the job caches on the cache volume after eviction.",
+		ConstLabels: prometheus.Labels{"runner": id},
+	})
+	MetricExecSessions = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace:   metricNamespace,
+		Name:        "exec_sessions",
+		Help:        "The count of job pod exec sessions by the transport they used.",
+		ConstLabels: prometheus.Labels{"runner": id},
+	},
+		[]string{"transport"})
+	MetricJobsPeakMemory = promauto.NewHistogram(prometheus.HistogramOpts{
+		Namespace:   metricNamespace,
+		Name:        "jobs_peak_memory_bytes",
+		Help:        "The peak memory used by job pods in bytes.",
+		ConstLabels: prometheus.Labels{"runner": id},
+		Buckets:     prometheus.ExponentialBuckets(64*1024*1024, 2, 9), // 64Mi to 16Gi
+	})
+	MetricJobsCPUSeconds = promauto.NewHistogram(prometheus.HistogramOpts{
+		Namespace:   metricNamespace,
+		Name:        "jobs_cpu_seconds",
+		Help:        "The cpu time used by job pods in seconds.",
+		ConstLabels: prometheus.Labels{"runner": id},
+		Buckets:     []float64{1, 5, 30, 60, 120, 300, 600, 1200, 3600},
+	})
+	MetricJobsOOMKilled = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace:   metricNamespace,
+		Name:        "jobs_oom_killed",
+		Help:        "The count of jobs that ran out of memory and were OOM killed.",
+		ConstLabels: prometheus.Labels{"runner": id},
+	})
+	MetricJobsOOMKills = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace:   metricNamespace,
+		Name:        "jobs_oom_kills",
+		Help:        "The count of processes OOM killed in job containers that kept running.",
+		ConstLabels: prometheus.Labels{"runner": id},
+	})
+	MetricLogDroppedBytes = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace:   metricNamespace,
+		Name:        "log_dropped_bytes",
+		Help:        "The count of bytes of job output dropped because the log buffer was full.",
+		ConstLabels: prometheus.Labels{"runner": id},
+	})
+	MetricLogBufferHighWater = promauto.NewHistogram(prometheus.HistogramOpts{
+		Namespace:   metricNamespace,
+		Name:        "log_buffer_high_water_bytes",
+		Help:        "The most job output in bytes held in the log buffer during a job.",
+		ConstLabels: prometheus.Labels{"runner": id},
+		Buckets:     prometheus.ExponentialBuckets(64*1024, 4, 8), // 64Ki to 1Gi
+	})
+	MetricLogRedactions = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace:   metricNamespace,
+		Name:        "log_redactions",
+		Help:        "The count of credentials redacted from job logs by redaction rule.",
+		ConstLabels: prometheus.Labels{"runner": id},
+	},
+		[]string{"rule"})
+	MetricLogQuotaExceeded = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace:   metricNamespace,
+		Name:        "log_quota_exceeded",
+		Help:        "The count of jobs whose output exceeded their log quota.",
+		ConstLabels: prometheus.Labels{"runner": id},
+	})
+	MetricShipQueueDepth = promauto.NewGauge(prometheus.GaugeOpts{
+		Namespace:   metricNamespace,
+		Name:        "ship_queue_depth",
+		Help:        "The current number of job log batches and outcome reports waiting to be sent to OpsLevel.",
+		ConstLabels: prometheus.Labels{"runner": id},
+	})
+	MetricShipQueueAge = promauto.NewGauge(prometheus.GaugeOpts{
+		Namespace:   metricNamespace,
+		Name:        "ship_queue_oldest_age_seconds",
+		Help:        "The age in seconds of the oldest shipment waiting to be sent to OpsLevel.",
+		ConstLabels: prometheus.Labels{"runner": id},
+	})
+	MetricShipRetries = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace:   metricNamespace,
+		Name:        "ship_retries",
+		Help:        "The count of failed retries to send queued shipments to OpsLevel.",
+		ConstLabels: prometheus.Labels{"runner": id},
+	})
+	MetricShipDeadLetters = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace:   metricNamespace,
+		Name:        "ship_dead_letters",
+		Help:        "The count of shipments OpsLevel rejected that won't be retried.",
+		ConstLabels: prometheus.Labels{"runner": id},
+	})
 }
 
 func StartMetricsServer(id string, port int) {
@@ -9,7 +9,7 @@ import (
 )
 
 type OpsLevelAppendLogProcessor struct {
-	client            *opslevel.Client
+	client            ShipClient
 	logger            zerolog.Logger
 	runnerId          opslevel.ID
 	jobId             opslevel.ID
@@ -23,7 +23,7 @@ type OpsLevelAppendLogProcessor struct {
 	elapsed           time.Duration
 }
 
-func NewOpsLevelAppendLogProcessor(client *opslevel.Client, logger zerolog.Logger, runnerId opslevel.ID, jobId opslevel.ID, jobNumber string, maxBytes int, maxTime time.Duration) *OpsLevelAppendLogProcessor {
+func NewOpsLevelAppendLogProcessor(client ShipClient, logger zerolog.Logger, runnerId opslevel.ID, jobId opslevel.ID, jobNumber string, maxBytes int, maxTime time.Duration) *OpsLevelAppendLogProcessor {
 	return &OpsLevelAppendLogProcessor{
 		client:            client,
 		logger:            logger,
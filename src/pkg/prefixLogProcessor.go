@@ -1,29 +1,31 @@
 package pkg
 
 import (
-	"fmt"
+	"time"
 )
 
+// PrefixLogProcessor prepends a prefix built from each record, e.g. the time the line arrived
 type PrefixLogProcessor struct {
-	prefix func() string
+	prefix func(record LogRecord) string
 }
 
-func NewPrefixLogProcessor(prefix func() string) *PrefixLogProcessor {
+func NewPrefixLogProcessor(prefix func(record LogRecord) string) *PrefixLogProcessor {
 	return &PrefixLogProcessor{
 		prefix: prefix,
 	}
 }
 
-func (s *PrefixLogProcessor) Process(line string) string {
-	return fmt.Sprintf("%s%s", s.prefix(), line)
+func (s *PrefixLogProcessor) ProcessRecord(record LogRecord) LogRecord {
+	record.Text = s.prefix(record) + record.Text
+	return record
 }
 
 func (s *PrefixLogProcessor) ProcessStdout(line string) string {
-	return s.Process(line)
+	return s.ProcessRecord(LogRecord{Text: line, Stream: LogStreamStdout, Time: time.Now()}).Text
 }
 
 func (s *PrefixLogProcessor) ProcessStderr(line string) string {
-	return s.Process(line)
+	return s.ProcessRecord(LogRecord{Text: line, Stream: LogStreamStderr, Time: time.Now()}).Text
 }
 
 func (s *PrefixLogProcessor) Flush(outcome JobOutcome) {}
@@ -0,0 +1,208 @@
+package pkg
+
+import (
+	"fmt"
+	"unicode/utf8"
+
+	"github.com/opslevel/opslevel-go/v2026"
+	"github.com/rs/zerolog"
+)
+
+// LogQuotaConfig limits how much of a job's output is shipped. A limit of 0 or less is no limit.
+type LogQuotaConfig struct {
+	// MaxLines and MaxBytes limit the lines shipped from the start of the output
+	MaxLines int
+	MaxBytes int
+	// TailLines is how many of the last lines are shipped after a limit was reached
+	TailLines int
+	// MaxLineLength truncates longer lines, including lines split into partial records
+	MaxLineLength int
+	// CollapseRepeats replaces consecutive repeats of a line with a marker that counts them
+	CollapseRepeats bool
+	// FailWhenExceeded fails a job that succeeded when it reached a limit
+	FailWhenExceeded bool
+}
+
+// QuotaLogProcessor keeps the output of noisy jobs within a quota. Once a limit is reached it drops
+// lines, except for the last TailLines lines which are shipped after a marker for the dropped lines
+// when the job's output ends.
+type QuotaLogProcessor struct {
+	config   LogQuotaConfig
+	logger   zerolog.Logger
+	lines    int
+	bytes    int
+	exceeded bool
+	tail     []LogRecord
+	omitted  int
+	// omittedBytes counts the bytes of the omitted lines
+	omittedBytes int
+	// lineLength is the length so far of the partial line on each stream
+	lineLength map[LogStream]int
+	// truncating is set for a stream while the rest of a truncated partial line is dropped
+	truncating map[LogStream]bool
+	last       *LogRecord
+	repeats    int
+	// latest is the last record processed, markers without a record of their own are stamped like it
+	latest   LogRecord
+	inserted []LogRecord
+}
+
+func NewQuotaLogProcessor(logger zerolog.Logger, config LogQuotaConfig) *QuotaLogProcessor {
+	return &QuotaLogProcessor{
+		config:     config,
+		logger:     logger,
+		lineLength: map[LogStream]int{},
+		truncating: map[LogStream]bool{},
+	}
+}
+
+func (s *QuotaLogProcessor) ProcessRecord(record LogRecord) LogRecord {
+	if record.Omitted {
+		return record
+	}
+	s.latest = record
+	if s.config.MaxLineLength > 0 {
+		if record = s.truncate(record); record.Omitted {
+			return record
+		}
+	}
+	if s.config.CollapseRepeats {
+		if s.last != nil && !s.last.Partial && !record.Partial && s.last.Stream == record.Stream && s.last.Text == record.Text {
+			s.repeats++
+			record.Omitted = true
+			return record
+		}
+		s.insertRepeats()
+		last := record
+		s.last = &last
+	}
+	if !s.admit(record) {
+		record.Omitted = true
+	}
+	return record
+}
+
+// truncate shortens the line to MaxLineLength bytes and drops the rest of it when it continues in
+// the stream's next records
+func (s *QuotaLogProcessor) truncate(record LogRecord) LogRecord {
+	if s.truncating[record.Stream] {
+		if !record.Partial {
+			delete(s.truncating, record.Stream)
+		}
+		record.Omitted = true
+		return record
+	}
+	length := s.lineLength[record.Stream] + len(record.Text)
+	if length <= s.config.MaxLineLength {
+		if record.Partial {
+			s.lineLength[record.Stream] = length
+		} else {
+			delete(s.lineLength, record.Stream)
+		}
+		return record
+	}
+	cut := s.config.MaxLineLength - s.lineLength[record.Stream]
+	for cut > 0 && !utf8.RuneStart(record.Text[cut]) {
+		cut--
+	}
+	record.Text = fmt.Sprintf("%s [line truncated to %d bytes]", record.Text[:cut], s.config.MaxLineLength)
+	delete(s.lineLength, record.Stream)
+	if record.Partial {
+		s.truncating[record.Stream] = true
+		record.Partial = false
+	}
+	return record
+}
+
+// insertRepeats inserts a marker for the repeats of the last line before the next line
+func (s *QuotaLogProcessor) insertRepeats() {
+	if s.repeats == 0 {
+		return
+	}
+	marker := s.marker(*s.last, fmt.Sprintf("[previous line repeated %d times]", s.repeats))
+	s.repeats = 0
+	if s.admit(marker) {
+		s.inserted = append(s.inserted, marker)
+	}
+}
+
+// admit reports whether the record fits in the quota, once it doesn't the record is held in the
+// tail and the oldest line in the tail is omitted instead
+func (s *QuotaLogProcessor) admit(record LogRecord) bool {
+	if !s.exceeded {
+		if (s.config.MaxLines <= 0 || s.lines < s.config.MaxLines) && (s.config.MaxBytes <= 0 || s.bytes+len(record.Text) <= s.config.MaxBytes) {
+			s.lines++
+			s.bytes += len(record.Text)
+			return true
+		}
+		s.exceeded = true
+		if MetricLogQuotaExceeded != nil {
+			MetricLogQuotaExceeded.Inc()
+		}
+	}
+	if s.config.TailLines > 0 {
+		s.tail = append(s.tail, record)
+		if len(s.tail) <= s.config.TailLines {
+			return false
+		}
+		record = s.tail[0]
+		s.tail[0] = LogRecord{}
+		s.tail = s.tail[1:]
+	}
+	s.omitted++
+	s.omittedBytes += len(record.Text)
+	return false
+}
+
+func (s *QuotaLogProcessor) marker(record LogRecord, text string) LogRecord {
+	return LogRecord{Text: text, Stream: record.Stream, Time: record.Time, Step: record.Step, JobId: record.JobId}
+}
+
+func (s *QuotaLogProcessor) Inserted() []LogRecord {
+	inserted := s.inserted
+	s.inserted = nil
+	return inserted
+}
+
+// Remaining returns the marker for the repeats of the last line, the marker for the omitted lines
+// and the tail
+func (s *QuotaLogProcessor) Remaining() []LogRecord {
+	s.insertRepeats()
+	remaining := s.Inserted()
+	if s.omitted > 0 {
+		at := s.latest
+		if len(s.tail) > 0 {
+			at = s.tail[0]
+		}
+		remaining = append(remaining, s.marker(at, fmt.Sprintf("[%d lines omitted because the job's output exceeded its log quota]", s.omitted)))
+	}
+	remaining = append(remaining, s.tail...)
+	s.tail = nil
+	return remaining
+}
+
+// AmendOutcome fails a job that succeeded but exceeded its quota when FailWhenExceeded is set
+func (s *QuotaLogProcessor) AmendOutcome(outcome JobOutcome) JobOutcome {
+	if s.config.FailWhenExceeded && s.exceeded && outcome.Outcome == opslevel.RunnerJobOutcomeEnumSuccess {
+		outcome.Outcome = opslevel.RunnerJobOutcomeEnumFailed
+		outcome.Message = "job output exceeded its log quota"
+		if s.omitted > 0 {
+			outcome.Message += fmt.Sprintf(", %d lines were omitted", s.omitted)
+		}
+	}
+	return outcome
+}
+
+func (s *QuotaLogProcessor) ProcessStdout(line string) string {
+	return s.ProcessRecord(LogRecord{Text: line, Stream: LogStreamStdout}).Text
+}
+
+func (s *QuotaLogProcessor) ProcessStderr(line string) string {
+	return s.ProcessRecord(LogRecord{Text: line, Stream: LogStreamStderr}).Text
+}
+
+func (s *QuotaLogProcessor) Flush(outcome JobOutcome) {
+	if s.omitted > 0 {
+		s.logger.Warn().Msgf("Omitted %d lines (%d bytes) of job output because it exceeded its log quota", s.omitted, s.omittedBytes)
+	}
+}
@@ -0,0 +1,125 @@
+package pkg
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/opslevel/opslevel-go/v2026"
+	"github.com/rocktavious/autopilot/v2023"
+	"github.com/rs/zerolog"
+)
+
+type outcomeCaptureProcessor struct {
+	captureProcessor
+	outcome JobOutcome
+	// returned is the outcome Close returned
+	returned JobOutcome
+}
+
+func (c *outcomeCaptureProcessor) Flush(outcome JobOutcome) {
+	c.outcome = outcome
+}
+
+func streamQuota(config LogQuotaConfig, output string, outcome JobOutcome) *outcomeCaptureProcessor {
+	cap := &outcomeCaptureProcessor{}
+	s := NewLogStreamer(zerolog.Nop(), NewQuotaLogProcessor(zerolog.Nop(), config), cap)
+	go s.Run(context.Background())
+	_, _ = s.Stdout.Write([]byte(output))
+	cap.returned = s.Close(context.Background(), outcome)
+	return cap
+}
+
+func TestQuotaLogProcessorKeepsHeadAndTail(t *testing.T) {
+	// Arrange
+	var output strings.Builder
+	for i := 1; i <= 10; i++ {
+		fmt.Fprintf(&output, "line %d\n", i)
+	}
+	// Act
+	cap := streamQuota(LogQuotaConfig{MaxLines: 3, TailLines: 2}, output.String(), JobOutcome{Outcome: opslevel.RunnerJobOutcomeEnumSuccess})
+	// Assert
+	autopilot.Equals(t, []string{
+		"line 1",
+		"line 2",
+		"line 3",
+		"[5 lines omitted because the job's output exceeded its log quota]",
+		"line 9",
+		"line 10",
+	}, cap.lines)
+	autopilot.Equals(t, opslevel.RunnerJobOutcomeEnumSuccess, cap.outcome.Outcome)
+}
+
+func TestQuotaLogProcessorMaxBytes(t *testing.T) {
+	// Act
+	cap := streamQuota(LogQuotaConfig{MaxBytes: 10}, "12345\n67890\nabc\n", JobOutcome{})
+	// Assert
+	autopilot.Equals(t, []string{
+		"12345",
+		"67890",
+		"[1 lines omitted because the job's output exceeded its log quota]",
+	}, cap.lines)
+}
+
+func TestQuotaLogProcessorFailsWhenExceeded(t *testing.T) {
+	// Arrange
+	config := LogQuotaConfig{MaxLines: 1, FailWhenExceeded: true}
+	// Act
+	exceeded := streamQuota(config, "a\nb\nc\n", JobOutcome{Outcome: opslevel.RunnerJobOutcomeEnumSuccess})
+	within := streamQuota(config, "a\n", JobOutcome{Outcome: opslevel.RunnerJobOutcomeEnumSuccess})
+	failed := streamQuota(config, "a\nb\n", JobOutcome{Outcome: opslevel.RunnerJobOutcomeEnumFailed, Message: "exit code 1"})
+	// Assert
+	autopilot.Equals(t, opslevel.RunnerJobOutcomeEnumFailed, exceeded.outcome.Outcome)
+	autopilot.Equals(t, "job output exceeded its log quota, 2 lines were omitted", exceeded.outcome.Message)
+	autopilot.Equals(t, exceeded.outcome, exceeded.returned)
+	autopilot.Equals(t, opslevel.RunnerJobOutcomeEnumSuccess, within.outcome.Outcome)
+	autopilot.Equals(t, "exit code 1", failed.outcome.Message)
+}
+
+func TestQuotaLogProcessorCollapsesRepeats(t *testing.T) {
+	// Arrange
+	output := "start\n" + strings.Repeat("waiting\n", 5000) + "done\n" + strings.Repeat("waiting\n", 2)
+	// Act
+	cap := streamQuota(LogQuotaConfig{CollapseRepeats: true}, output, JobOutcome{})
+	// Assert
+	autopilot.Equals(t, []string{
+		"start",
+		"waiting",
+		"[previous line repeated 4999 times]",
+		"done",
+		"waiting",
+		"[previous line repeated 1 times]",
+	}, cap.lines)
+}
+
+func TestQuotaLogProcessorTruncatesLongLines(t *testing.T) {
+	// Arrange
+	cap := &captureProcessor{}
+	s := NewLogStreamer(zerolog.Nop(), NewQuotaLogProcessor(zerolog.Nop(), LogQuotaConfig{MaxLineLength: 6}), cap)
+	s.SetBuffer(LogBufferConfig{MaxBytes: 4, Policy: LogBufferPolicyBlock})
+	go s.Run(context.Background())
+	// Act
+	_, _ = s.Stdout.Write([]byte("short\nhéllo wörld\n"))
+	_, _ = s.Stdout.Write([]byte("abcdefghijkl"))
+	_, _ = s.Stdout.Write([]byte("mnop\nend\n"))
+	s.Close(context.Background(), JobOutcome{})
+	// Assert
+	autopilot.Equals(t, []string{
+		"short",
+		"héllo [line truncated to 6 bytes]",
+		"abcd",
+		"ef [line truncated to 6 bytes]",
+		"end",
+	}, cap.lines)
+}
+
+func TestNewQuotaLogProcessorOptions(t *testing.T) {
+	// Act
+	_, negativeErr := newQuotaLogProcessor(LogPipelineJob{}, LogProcessorOptions{"tailLines": float64(-1)})
+	processor, err := newQuotaLogProcessor(LogPipelineJob{}, LogProcessorOptions{"maxBytes": float64(1024), "collapseRepeats": true})
+	// Assert
+	autopilot.Equals(t, "tailLines: must not be negative but got '-1'", negativeErr.Error())
+	autopilot.Ok(t, err)
+	autopilot.Equals(t, LogQuotaConfig{MaxBytes: 1024, CollapseRepeats: true}, processor.(*QuotaLogProcessor).config)
+}
@@ -0,0 +1,145 @@
+package pkg
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/rs/zerolog"
+	"github.com/rs/zerolog/log"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes"
+)
+
+const (
+	// CreatedByLabel marks the objects the reaper is allowed to clean up
+	CreatedByLabel = "app.kubernetes.io/created-by"
+	CreatedByValue = "opslevel-runner"
+	// ExpiresAtAnnotation is the RFC3339 time after which the reaper deletes the object
+	ExpiresAtAnnotation = "opslevel.com/expires-at"
+)
+
+// Reaper deletes objects created by runners that have expired, covering runners that
+// crashed or were killed before they could clean up after a job and failed job pods
+// that were held for debugging
+type Reaper struct {
+	clientset kubernetes.Interface
+	podConfig *K8SPodConfig
+	logger    zerolog.Logger
+	interval  time.Duration
+}
+
+// reapable lists and deletes one kind of object the reaper cleans up
+type reapable struct {
+	kind   string
+	list   func(ctx context.Context, options metav1.ListOptions) (runtime.Object, error)
+	delete func(ctx context.Context, name string, options metav1.DeleteOptions) error
+}
+
+func newReapable[T runtime.Object](kind string, list func(context.Context, metav1.ListOptions) (T, error), delete func(context.Context, string, metav1.DeleteOptions) error) reapable {
+	return reapable{
+		kind: kind,
+		list: func(ctx context.Context, options metav1.ListOptions) (runtime.Object, error) {
+			return list(ctx, options)
+		},
+		delete: delete,
+	}
+}
+
+func NewReaper(clientset kubernetes.Interface, podConfig *K8SPodConfig, interval time.Duration) *Reaper {
+	return &Reaper{
+		clientset: clientset,
+		podConfig: podConfig,
+		logger:    log.With().Str("worker", "reaper").Logger(),
+		interval:  interval,
+	}
+}
+
+func isExpired(annotations map[string]string, now time.Time) bool {
+	value, ok := annotations[ExpiresAtAnnotation]
+	if !ok {
+		return false
+	}
+	expiresAt, err := time.Parse(time.RFC3339, value)
+	return err == nil && now.After(expiresAt)
+}
+
+// reapables returns the kinds of objects this runner's configuration can leave behind. Job namespaces
+// are only created in isolation mode and held jobs are only left behind when a debug hold is possible.
+func (r *Reaper) reapables() []reapable {
+	var output []reapable
+	if r.podConfig.Isolation.Enabled {
+		namespaces := r.clientset.CoreV1().Namespaces()
+		output = append(output, newReapable("namespace", namespaces.List, namespaces.Delete))
+	}
+	if r.podConfig.DebugHoldDuration > 0 {
+		jobs := r.clientset.BatchV1().Jobs(r.podConfig.Namespace)
+		pods := r.clientset.CoreV1().Pods(r.podConfig.Namespace)
+		configMaps := r.clientset.CoreV1().ConfigMaps(r.podConfig.Namespace)
+		networkPolicies := r.clientset.NetworkingV1().NetworkPolicies(r.podConfig.Namespace)
+		output = append(output,
+			newReapable("job", jobs.List, jobs.Delete),
+			newReapable("pod", pods.List, pods.Delete),
+			newReapable("configmap", configMaps.List, configMaps.Delete),
+			newReapable("networkpolicy", networkPolicies.List, networkPolicies.Delete),
+		)
+	}
+	return output
+}
+
+// Reap deletes the expired objects and returns their names
+func (r *Reaper) Reap(ctx context.Context, now time.Time) ([]string, error) {
+	selector := fmt.Sprintf("%s=%s", CreatedByLabel, CreatedByValue)
+	propagation := metav1.DeletePropagationBackground
+	var reaped []string
+	for _, kind := range r.reapables() {
+		list, err := kind.list(ctx, metav1.ListOptions{LabelSelector: selector})
+		if err != nil {
+			return reaped, fmt.Errorf("failed to list %ss: %w", kind.kind, err)
+		}
+		err = meta.EachListItem(list, func(object runtime.Object) error {
+			item, err := meta.Accessor(object)
+			if err != nil {
+				return err
+			}
+			if item.GetDeletionTimestamp() != nil || !isExpired(item.GetAnnotations(), now) {
+				return nil
+			}
+			err = kind.delete(ctx, item.GetName(), metav1.DeleteOptions{PropagationPolicy: &propagation})
+			if err != nil {
+				r.logger.Error().Err(err).Msgf("failed to reap %s '%s'", kind.kind, item.GetName())
+				return nil
+			}
+			reaped = append(reaped, fmt.Sprintf("%s/%s", kind.kind, item.GetName()))
+			return nil
+		})
+		if err != nil {
+			return reaped, err
+		}
+	}
+	return reaped, nil
+}
+
+// Run reaps expired objects until the context is cancelled
+func (r *Reaper) Run(ctx context.Context) {
+	ticker := time.NewTicker(r.interval)
+	defer ticker.Stop()
+	r.logger.Info().Msg("Starting reaper ...")
+	for {
+		select {
+		case <-ctx.Done():
+			r.logger.Info().Msg("Stopping reaper ...")
+			return
+		case <-ticker.C:
+			reaped, err := r.Reap(ctx, time.Now())
+			if err != nil {
+				r.logger.Error().Err(err).Msg("failed to reap expired objects")
+			}
+			for _, name := range reaped {
+				r.logger.Info().Msgf("Reaped expired %s", name)
+			}
+		}
+	}
+}
@@ -0,0 +1,66 @@
+package pkg
+
+import (
+	"context"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/rocktavious/autopilot/v2023"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func getReaperNamespace(name string, labels map[string]string, expiresAt string) *corev1.Namespace {
+	annotations := map[string]string{}
+	if expiresAt != "" {
+		annotations[ExpiresAtAnnotation] = expiresAt
+	}
+	return &corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: name, Labels: labels, Annotations: annotations}}
+}
+
+func TestReaper_Reap(t *testing.T) {
+	// Arrange
+	ours := map[string]string{CreatedByLabel: CreatedByValue}
+	clientset := fake.NewClientset(
+		getReaperNamespace("expired", ours, "2026-10-19T11:00:00Z"),
+		getReaperNamespace("active", ours, "2026-10-19T13:00:00Z"),
+		getReaperNamespace("no-expiry", ours, ""),
+		getReaperNamespace("not-ours", map[string]string{}, "2026-10-19T11:00:00Z"),
+	)
+	reaper := NewReaper(clientset, &K8SPodConfig{Isolation: K8SIsolationConfig{Enabled: true}}, time.Minute)
+	// Act
+	reaped, err := reaper.Reap(context.Background(), time.Date(2026, 10, 19, 12, 0, 0, 0, time.UTC))
+	// Assert
+	autopilot.Ok(t, err)
+	autopilot.Equals(t, []string{"namespace/expired"}, reaped)
+	namespaces, err := clientset.CoreV1().Namespaces().List(context.Background(), metav1.ListOptions{})
+	autopilot.Ok(t, err)
+	var remaining []string
+	for _, namespace := range namespaces.Items {
+		remaining = append(remaining, namespace.Name)
+	}
+	sort.Strings(remaining)
+	autopilot.Equals(t, []string{"active", "no-expiry", "not-ours"}, remaining)
+}
+
+func TestReaper_ReapDebugHolds(t *testing.T) {
+	// Arrange
+	held := map[string]string{CreatedByLabel: CreatedByValue}
+	annotations := map[string]string{ExpiresAtAnnotation: "2026-10-19T11:00:00Z"}
+	clientset := fake.NewClientset(
+		&corev1.Pod{ObjectMeta: metav1.ObjectMeta{Name: "held", Namespace: "jobs", Labels: held, Annotations: annotations}},
+		&corev1.ConfigMap{ObjectMeta: metav1.ObjectMeta{Name: "held", Namespace: "jobs", Labels: held, Annotations: annotations}},
+		&corev1.Pod{ObjectMeta: metav1.ObjectMeta{Name: "running", Namespace: "jobs"}},
+		getReaperNamespace("expired", held, "2026-10-19T11:00:00Z"),
+	)
+	reaper := NewReaper(clientset, &K8SPodConfig{Namespace: "jobs", DebugHoldDuration: 3600}, time.Minute)
+	// Act
+	reaped, err := reaper.Reap(context.Background(), time.Date(2026, 10, 19, 12, 0, 0, 0, time.UTC))
+	// Assert: namespaces are only reaped in isolation mode
+	autopilot.Ok(t, err)
+	autopilot.Equals(t, []string{"pod/held", "configmap/held"}, reaped)
+	_, err = clientset.CoreV1().Pods("jobs").Get(context.Background(), "running", metav1.GetOptions{})
+	autopilot.Ok(t, err)
+}
@@ -0,0 +1,212 @@
+package pkg
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"regexp"
+
+	"sigs.k8s.io/yaml"
+)
+
+// RedactionConfig configures the rules that redact credentials the job was never given, e.g. tokens
+// a tool prints. The built-in rules for well-known credential formats run before the configured ones.
+type RedactionConfig struct {
+	DisableDefaults bool                  `yaml:"disableDefaults"`
+	Rules           []RedactionRuleConfig `yaml:"rules"`
+}
+
+// RedactionRuleConfig redacts every match of Pattern with Replacement, which can refer to the pattern's
+// groups like $1. With Until the lines after a match are redacted as well up to and including the next
+// line that matches Until, but no more than MaxLines lines in case Until never comes, e.g. for output
+// that was cut off.
+type RedactionRuleConfig struct {
+	Name        string `yaml:"name"`
+	Pattern     string `yaml:"pattern"`
+	Replacement string `yaml:"replacement"`
+	Until       string `yaml:"until"`
+	MaxLines    int    `yaml:"maxLines"`
+}
+
+// defaultRedactionMaxLines is how many lines a rule with Until redacts at most by default, a PEM
+// block of a 4096 bit RSA private key is about 50 lines
+const defaultRedactionMaxLines = 100
+
+var defaultRedactionRules = []RedactionRuleConfig{
+	{Name: "aws-access-key-id", Pattern: `\b(?:AKIA|ASIA|ABIA|ACCA)[0-9A-Z]{16}\b`},
+	{Name: "github-token", Pattern: `\b(?:gh[pousr]_[A-Za-z0-9]{36,255}|github_pat_[A-Za-z0-9_]{22,255})\b`},
+	{Name: "gitlab-token", Pattern: `\bglpat-[A-Za-z0-9_-]{20,}`},
+	{Name: "slack-token", Pattern: `\bxox[abprs]-[A-Za-z0-9-]{10,}`},
+	{Name: "google-api-key", Pattern: `\bAIza[0-9A-Za-z_-]{35}`},
+	{Name: "stripe-key", Pattern: `\b[rs]k_live_[0-9A-Za-z]{24,}`},
+	{Name: "jwt", Pattern: `\beyJ[A-Za-z0-9_-]{5,}\.eyJ[A-Za-z0-9_-]{5,}\.[A-Za-z0-9_-]{10,}`},
+	{Name: "private-key", Pattern: `-----BEGIN (?:[A-Z0-9]+ )*PRIVATE KEY( BLOCK)?-----.*`, Until: `-----END (?:[A-Z0-9]+ )*PRIVATE KEY( BLOCK)?-----`},
+}
+
+// RedactionRule is a compiled RedactionRuleConfig
+type RedactionRule struct {
+	Name        string
+	pattern     *regexp.Regexp
+	replacement string
+	until       *regexp.Regexp
+	maxLines    int
+}
+
+// NewRedactionRules compiles the built-in rules unless they are disabled followed by the configured rules
+func NewRedactionRules(config RedactionConfig) ([]*RedactionRule, error) {
+	var rules []*RedactionRule
+	if !config.DisableDefaults {
+		for _, ruleConfig := range defaultRedactionRules {
+			rule, err := newRedactionRule(ruleConfig)
+			if err != nil {
+				return nil, fmt.Errorf("redaction: built-in rule '%s': %w", ruleConfig.Name, err)
+			}
+			rules = append(rules, rule)
+		}
+	}
+	for i, ruleConfig := range config.Rules {
+		rule, err := newRedactionRule(ruleConfig)
+		if err != nil {
+			return nil, fmt.Errorf("redaction.rules[%d].%w", i, err)
+		}
+		rules = append(rules, rule)
+	}
+	return rules, nil
+}
+
+func newRedactionRule(config RedactionRuleConfig) (*RedactionRule, error) {
+	if config.Name == "" {
+		return nil, fmt.Errorf("name: must not be empty")
+	}
+	pattern, err := regexp.Compile(config.Pattern)
+	if err != nil {
+		return nil, fmt.Errorf("pattern: %w", err)
+	}
+	if pattern.MatchString("") {
+		return nil, fmt.Errorf("pattern: '%s' must not match an empty string", config.Pattern)
+	}
+	rule := &RedactionRule{
+		Name:        config.Name,
+		pattern:     pattern,
+		replacement: config.Replacement,
+	}
+	if rule.replacement == "" {
+		rule.replacement = maskedValue
+	}
+	if config.Until != "" {
+		if rule.until, err = regexp.Compile(config.Until); err != nil {
+			return nil, fmt.Errorf("until: %w", err)
+		}
+	}
+	if config.MaxLines < 0 {
+		return nil, fmt.Errorf("maxLines: must not be negative but was %d", config.MaxLines)
+	}
+	rule.maxLines = config.MaxLines
+	if rule.maxLines == 0 {
+		rule.maxLines = defaultRedactionMaxLines
+	}
+	return rule, nil
+}
+
+// ParseRedactionConfig parses the redaction section of the config
+func ParseRedactionConfig(data []byte) (*RedactionConfig, error) {
+	var config struct {
+		Redaction RedactionConfig `json:"redaction"`
+	}
+	if err := yaml.Unmarshal(data, &config); err != nil {
+		return nil, err
+	}
+	return &config.Redaction, nil
+}
+
+// ValidateRedactionConfig parses the redaction section like ParseRedactionConfig does and reports unknown
+// keys and rules that can't be compiled
+func ValidateRedactionConfig(data []byte) (*RedactionConfig, []error) {
+	var problems []error
+	sections := map[string]any{}
+	if err := yaml.Unmarshal(data, &sections); err == nil && sections["redaction"] != nil {
+		section, _ := json.Marshal(sections["redaction"])
+		decoder := json.NewDecoder(bytes.NewReader(section))
+		decoder.DisallowUnknownFields()
+		if err := decoder.Decode(&RedactionConfig{}); err != nil {
+			problems = append(problems, fmt.Errorf("redaction: %w", err))
+		}
+	}
+	config, err := ParseRedactionConfig(data)
+	if err != nil {
+		if len(problems) == 0 {
+			problems = append(problems, fmt.Errorf("redaction: %w", err))
+		}
+		return nil, problems
+	}
+	if _, err := NewRedactionRules(*config); err != nil {
+		problems = append(problems, err)
+	}
+	return config, problems
+}
+
+// RedactLogProcessor redacts credentials that match the redaction rules and counts the redactions
+// of each rule
+type RedactLogProcessor struct {
+	rules []*RedactionRule
+	// within is the rule whose Until hasn't matched yet on each stream
+	within map[LogStream]*redactionBlock
+}
+
+// redactionBlock is a rule whose Until hasn't matched yet and the number of lines it redacted since
+type redactionBlock struct {
+	rule  *RedactionRule
+	lines int
+}
+
+func NewRedactLogProcessor(rules []*RedactionRule) *RedactLogProcessor {
+	return &RedactLogProcessor{
+		rules:  rules,
+		within: map[LogStream]*redactionBlock{},
+	}
+}
+
+func (s *RedactLogProcessor) ProcessRecord(record LogRecord) LogRecord {
+	if block := s.within[record.Stream]; block != nil {
+		if block.lines < block.rule.maxLines {
+			block.lines++
+			if block.rule.until.MatchString(record.Text) {
+				delete(s.within, record.Stream)
+			}
+			record.Text = block.rule.replacement
+			return record
+		}
+		// Until never came, the rest of the output is redacted by the rules as usual
+		delete(s.within, record.Stream)
+	}
+	for _, rule := range s.rules {
+		matches := rule.pattern.FindAllStringIndex(record.Text, -1)
+		if len(matches) == 0 {
+			continue
+		}
+		if rule.until != nil && !rule.until.MatchString(record.Text[matches[len(matches)-1][0]:]) {
+			s.within[record.Stream] = &redactionBlock{rule: rule}
+		}
+		record.Text = rule.pattern.ReplaceAllString(record.Text, rule.replacement)
+		for range matches {
+			countRedaction(rule)
+		}
+	}
+	return record
+}
+
+func countRedaction(rule *RedactionRule) {
+	if MetricLogRedactions != nil {
+		MetricLogRedactions.WithLabelValues(rule.Name).Inc()
+	}
+}
+
+func (s *RedactLogProcessor) ProcessStdout(line string) string {
+	return s.ProcessRecord(LogRecord{Text: line, Stream: LogStreamStdout}).Text
+}
+
+func (s *RedactLogProcessor) ProcessStderr(line string) string {
+	return s.ProcessRecord(LogRecord{Text: line, Stream: LogStreamStderr}).Text
+}
+
+func (s *RedactLogProcessor) Flush(outcome JobOutcome) {}
@@ -1,35 +1,186 @@
 package pkg
 
 import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"net/url"
+	"regexp"
+	"slices"
 	"strings"
 
 	"github.com/opslevel/opslevel-go/v2026"
 )
 
+const (
+	// AddMaskCommand is the log command a job prints to mask a secret it obtained at runtime
+	AddMaskCommand = "::add-mask::"
+	// minSecretLineLength is the shortest line of a multi-line secret that is masked on its own, shorter
+	// lines (e.g. "apiVersion: v1" in a kubeconfig) would mask unrelated output without hiding anything
+	minSecretLineLength = 16
+	// minEncodedSecretLength is the shortest base64 fragment of a secret that is masked where the secret
+	// is encoded as part of a longer value, shorter fragments would match unrelated base64 data
+	minEncodedSecretLength = 8
+	maskedValue            = "**********"
+)
+
+var addMaskExp = regexp.MustCompile(`^::add-mask::(?P<Value>.*)`)
+
+// SanitizeLogProcessor masks the values of sensitive variables and of secrets added at runtime with
+// ::add-mask:: in their common encodings as well as verbatim
 type SanitizeLogProcessor struct {
-	variables []opslevel.RunnerJobVariable
+	secrets []string
+	// secretLines are the lines of multi-line secrets, which are only masked where they make up a whole line
+	secretLines map[string]bool
+	longest     int
+	replacer    *strings.Replacer
+	carry       map[LogStream]string
 }
 
 func NewSanitizeLogProcessor(variables []opslevel.RunnerJobVariable) *SanitizeLogProcessor {
-	var secrets []opslevel.RunnerJobVariable
+	processor := &SanitizeLogProcessor{secretLines: map[string]bool{}, carry: map[LogStream]string{}}
 	for _, variable := range variables {
-		if variable.Sensitive && variable.Value != "" {
-			secrets = append(secrets, variable)
+		if variable.Sensitive {
+			processor.AddMask(variable.Value)
+		}
+	}
+	return processor
+}
+
+// AddMask masks value and its variants in every line processed from now on
+func (s *SanitizeLogProcessor) AddMask(value string) {
+	for _, variant := range getSecretVariants(value) {
+		if !slices.Contains(s.secrets, variant) {
+			s.secrets = append(s.secrets, variant)
+			s.longest = max(s.longest, len(variant))
+			s.replacer = nil
+		}
+	}
+	for _, line := range getSecretLines(value) {
+		s.secretLines[line] = true
+	}
+}
+
+// getReplacer returns a replacer that masks every secret in a single pass over a line. The secrets are
+// passed longest first because at each position the replacer masks the first secret that matches, so a
+// secret is masked as a whole rather than a shorter secret it contains, e.g. a line of it.
+func (s *SanitizeLogProcessor) getReplacer() *strings.Replacer {
+	if s.replacer == nil {
+		secrets := slices.Clone(s.secrets)
+		slices.SortStableFunc(secrets, func(a, b string) int { return len(b) - len(a) })
+		pairs := make([]string, 0, 2*len(secrets))
+		for _, secret := range secrets {
+			pairs = append(pairs, secret, maskedValue)
 		}
+		s.replacer = strings.NewReplacer(pairs...)
 	}
-	return &SanitizeLogProcessor{
-		variables: secrets,
+	return s.replacer
+}
+
+// getSecretLines returns the lines of a multi-line secret (e.g. a PEM key or a kubeconfig) that are masked
+// on their own because the job's output is processed in lines
+func getSecretLines(value string) []string {
+	if !strings.Contains(value, "\n") {
+		return nil
 	}
+	var output []string
+	for _, line := range strings.Split(value, "\n") {
+		if line = strings.TrimSpace(line); len(line) >= minSecretLineLength {
+			output = append(output, line)
+		}
+	}
+	return output
+}
+
+// getSecretVariants returns the forms a secret is likely to be printed in
+func getSecretVariants(value string) []string {
+	if value == "" {
+		return nil
+	}
+	variants := []string{value}
+	variants = append(variants, getBase64Variants(value)...)
+	variants = append(variants, url.QueryEscape(value), url.PathEscape(value))
+	if escaped, err := json.Marshal(value); err == nil {
+		variants = append(variants, string(escaped[1:len(escaped)-1]))
+	}
+	var buffer bytes.Buffer
+	encoder := json.NewEncoder(&buffer)
+	encoder.SetEscapeHTML(false)
+	if err := encoder.Encode(value); err == nil {
+		escaped := strings.TrimSpace(buffer.String())
+		variants = append(variants, escaped[1:len(escaped)-1])
+	}
+	output := variants[:0]
+	for _, variant := range variants {
+		if variant != "" && !slices.Contains(output, variant) {
+			output = append(output, variant)
+		}
+	}
+	return output
+}
+
+// getBase64Variants returns the secret encoded on its own in both base64 alphabets with and without
+// padding, and the part of the encoding that doesn't depend on the surrounding bytes for each of the
+// three offsets the secret can start at when it is encoded as part of a longer value (e.g. "user:secret")
+func getBase64Variants(value string) []string {
+	var output []string
+	for _, encoding := range []*base64.Encoding{base64.StdEncoding, base64.URLEncoding} {
+		output = append(output, encoding.EncodeToString([]byte(value)))
+		output = append(output, encoding.WithPadding(base64.NoPadding).EncodeToString([]byte(value)))
+		for offset := range 3 {
+			data := append(make([]byte, offset), value...)
+			encoded := encoding.EncodeToString(data)
+			start := (offset*4 + 2) / 3
+			end := len(data) * 4 / 3
+			if end-start >= minEncodedSecretLength {
+				output = append(output, encoded[start:end])
+			}
+		}
+	}
+	return output
 }
 
 func (s *SanitizeLogProcessor) Process(line string) string {
+	maskData := addMaskExp.FindStringSubmatch(line)
+	if len(maskData) > 0 {
+		s.AddMask(strings.TrimSpace(maskData[1]))
+		return ""
+	}
+	if trimmed := strings.TrimSpace(line); s.secretLines[trimmed] {
+		return strings.Replace(line, trimmed, maskedValue, 1)
+	}
+	return s.mask(line)
+}
+
+func (s *SanitizeLogProcessor) mask(line string) string {
 	scrubbed := line
-	for _, variable := range s.variables {
-		scrubbed = strings.ReplaceAll(scrubbed, variable.Value, "**********")
+	if len(s.secrets) > 0 {
+		scrubbed = s.getReplacer().Replace(line)
+	}
+	// The shell traces the command that prints a mask before the mask is added (e.g. `+ echo ::add-mask::value`)
+	if index := strings.Index(scrubbed, AddMaskCommand); index >= 0 {
+		scrubbed = scrubbed[:index+len(AddMaskCommand)] + maskedValue
 	}
 	return scrubbed
 }
 
+// ProcessRecord masks a line that was split because it was too long together with the rest of it. The
+// end of each part that could be the start of a secret is held back and masked with the next part of
+// the same stream.
+func (s *SanitizeLogProcessor) ProcessRecord(record LogRecord) LogRecord {
+	text := s.carry[record.Stream] + record.Text
+	delete(s.carry, record.Stream)
+	if !record.Partial {
+		record.Text = s.Process(text)
+		return record
+	}
+	text = s.mask(text)
+	keep := min(len(text), max(s.longest, len(AddMaskCommand))-1)
+	s.carry[record.Stream] = text[len(text)-keep:]
+	record.Text = text[:len(text)-keep]
+	return record
+}
+
 func (s *SanitizeLogProcessor) ProcessStdout(line string) string {
 	return s.Process(line)
 }
@@ -18,7 +18,7 @@ var (
 )
 
 type SetOutcomeVarLogProcessor struct {
-	client                 *opslevel.Client
+	client                 ShipClient
 	logger                 zerolog.Logger
 	runnerId               opslevel.ID
 	jobId                  opslevel.ID
@@ -27,7 +27,7 @@ type SetOutcomeVarLogProcessor struct {
 	vars                   map[string]string
 }
 
-func NewSetOutcomeVarLogProcessor(client *opslevel.Client, logger zerolog.Logger, runnerId opslevel.ID, jobId opslevel.ID, jobNumber string) *SetOutcomeVarLogProcessor {
+func NewSetOutcomeVarLogProcessor(client ShipClient, logger zerolog.Logger, runnerId opslevel.ID, jobId opslevel.ID, jobNumber string) *SetOutcomeVarLogProcessor {
 	return &SetOutcomeVarLogProcessor{
 		client:                 client,
 		logger:                 logger,
@@ -82,13 +82,7 @@ func (s *SetOutcomeVarLogProcessor) ProcessStderr(line string) string {
 }
 
 func (s *SetOutcomeVarLogProcessor) Flush(outcome JobOutcome) {
-	vars := make([]opslevel.RunnerJobOutcomeVariable, 0)
-	for k, v := range s.vars {
-		vars = append(vars, opslevel.RunnerJobOutcomeVariable{
-			Key:   k,
-			Value: v,
-		})
-	}
+	vars := mergeOutcomeVariables(s.vars, outcome.OutcomeVariables)
 	s.logger.Debug().Msgf("Outcome Variables:")
 	bytes, _ := json.MarshalIndent(vars, "    ", "  ")
 	s.logger.Debug().Msg(string(bytes))
@@ -110,3 +104,23 @@ func (s *SetOutcomeVarLogProcessor) Flush(outcome JobOutcome) {
 		s.logger.Error().Err(err).Msgf("error when reporting outcome '%s' for job '%s'", outcome.Outcome, s.jobNumber)
 	}
 }
+
+// mergeOutcomeVariables combines the variables a job set with the built-in variables the runner reports,
+// the built-in variables win when a job sets one with the same key
+func mergeOutcomeVariables(vars map[string]string, builtin []opslevel.RunnerJobOutcomeVariable) []opslevel.RunnerJobOutcomeVariable {
+	output := make([]opslevel.RunnerJobOutcomeVariable, 0, len(vars)+len(builtin))
+	overridden := map[string]bool{}
+	for _, variable := range builtin {
+		overridden[variable.Key] = true
+	}
+	for k, v := range vars {
+		if overridden[k] {
+			continue
+		}
+		output = append(output, opslevel.RunnerJobOutcomeVariable{
+			Key:   k,
+			Value: v,
+		})
+	}
+	return append(output, builtin...)
+}
@@ -3,6 +3,7 @@ package pkg
 import (
 	"testing"
 
+	"github.com/opslevel/opslevel-go/v2026"
 	"github.com/rocktavious/autopilot/v2023"
 	"github.com/rs/zerolog/log"
 )
@@ -89,3 +90,16 @@ func TestNestedMultilineKeyOutcomeVarLogProcessor(t *testing.T) {
 	autopilot.Equals(t, "hello\nworld\nfoo\nbar", p.vars["one"])
 	autopilot.Equals(t, "foo\nfoo\nfoo", p.vars["two"])
 }
+
+func TestMergeOutcomeVariables(t *testing.T) {
+	// Act
+	vars := mergeOutcomeVariables(
+		map[string]string{OutcomeVariableOOMKilled: "spoofed", "hello-world": "42"},
+		[]opslevel.RunnerJobOutcomeVariable{{Key: OutcomeVariableOOMKilled, Value: "false"}},
+	)
+	// Assert
+	autopilot.Equals(t, []opslevel.RunnerJobOutcomeVariable{
+		{Key: "hello-world", Value: "42"},
+		{Key: OutcomeVariableOOMKilled, Value: "false"},
+	}, vars)
+}
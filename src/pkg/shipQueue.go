@@ -0,0 +1,415 @@
+package pkg
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/opslevel/opslevel-go/v2026"
+	"github.com/rs/zerolog"
+)
+
+const (
+	shipQueueMinBackoff = time.Second
+	shipQueueMaxBackoff = time.Minute
+	// shipQueueMetricsInterval is how often the age of the oldest shipment is updated while the queue waits
+	shipQueueMetricsInterval = 15 * time.Second
+	shipmentFileExtension    = ".json"
+	// shipDeadLetterDirectory is where the spool keeps the shipments OpsLevel rejected
+	shipDeadLetterDirectory = "dead-letter"
+)
+
+// ShipClient sends a job's logs and outcome to OpsLevel, it's implemented by the OpsLevel client and
+// by the ShipQueue that retries it
+type ShipClient interface {
+	RunnerAppendJobLog(input opslevel.RunnerAppendJobLogInput) error
+	RunnerReportJobOutcome(input opslevel.RunnerReportJobOutcomeInput) error
+}
+
+// ShipQueueConfig configures the ShipQueue. Shipments are only kept in memory when Directory is empty
+// and are dropped once they are older than MaxAge, a MaxAge of 0 or less keeps retrying them. RunnerId
+// is the id the runner registered with, it replaces the id of the runner that queued a shipment before
+// a restart.
+type ShipQueueConfig struct {
+	Directory string
+	MaxAge    time.Duration
+	RunnerId  opslevel.ID
+}
+
+// shipment is a batch of log lines or an outcome report waiting to be sent
+type shipment struct {
+	Queued  time.Time                             `json:"queued"`
+	Log     *opslevel.RunnerAppendJobLogInput     `json:"log,omitempty"`
+	Outcome *opslevel.RunnerReportJobOutcomeInput `json:"outcome,omitempty"`
+	// Error is why OpsLevel rejected a dead-lettered shipment
+	Error string `json:"error,omitempty"`
+	path  string
+}
+
+func (s *shipment) jobId() opslevel.ID {
+	if s.Log != nil {
+		return s.Log.RunnerJobId
+	}
+	return s.Outcome.RunnerJobId
+}
+
+// jobShipments are the queued shipments of a job in order and when to retry them
+type jobShipments struct {
+	items   []*shipment
+	backoff time.Duration
+	retryAt time.Time
+}
+
+// statusCoder is implemented by the errors of requests that got an HTTP response, e.g. the network
+// errors of the GraphQL client
+type statusCoder interface {
+	StatusCode() int
+}
+
+// isRetryableShipError reports whether a shipment that failed with err may succeed when it's sent again,
+// which is when the request didn't get through or OpsLevel failed to handle it. Any other error is OpsLevel
+// rejecting the shipment itself, e.g. with GraphQL errors for a job that doesn't exist, which retrying
+// won't fix.
+func isRetryableShipError(err error) bool {
+	var status statusCoder
+	if errors.As(err, &status) {
+		code := status.StatusCode()
+		return code >= 500 || code == http.StatusTooManyRequests || code == http.StatusRequestTimeout
+	}
+	var netErr net.Error
+	return errors.As(err, &netErr) || errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) ||
+		errors.Is(err, context.DeadlineExceeded)
+}
+
+// ShipQueue sends a job's logs and outcome to OpsLevel in the order they were shipped. A shipment that
+// can't be sent is queued, along with the later shipments of its job, and retried with backoff by Run.
+// Each job is retried on its own so that a job whose shipments keep failing doesn't hold up the others.
+// With a spool directory the queued shipments are written to disk and replayed after a restart, and
+// the shipments OpsLevel rejected are kept in its dead-letter directory.
+type ShipQueue struct {
+	client   ShipClient
+	logger   zerolog.Logger
+	config   ShipQueueConfig
+	mutex    sync.Mutex
+	jobs     map[opslevel.ID]*jobShipments
+	sequence uint64
+	ready    chan struct{}
+	// sending is held while queued shipments are retried
+	sending sync.Mutex
+}
+
+// NewShipQueue creates the queue and loads the shipments left in the spool directory
+func NewShipQueue(client ShipClient, logger zerolog.Logger, config ShipQueueConfig) (*ShipQueue, error) {
+	q := &ShipQueue{
+		client: client,
+		logger: logger,
+		config: config,
+		jobs:   map[opslevel.ID]*jobShipments{},
+		ready:  make(chan struct{}, 1),
+	}
+	if config.Directory == "" {
+		return q, nil
+	}
+	if err := os.MkdirAll(config.Directory, 0o750); err != nil {
+		return nil, err
+	}
+	files, err := filepath.Glob(filepath.Join(config.Directory, "*"+shipmentFileExtension))
+	if err != nil {
+		return nil, err
+	}
+	slices.Sort(files)
+	for _, file := range files {
+		data, err := os.ReadFile(file)
+		if err != nil {
+			return nil, err
+		}
+		item := &shipment{path: file}
+		if err := json.Unmarshal(data, item); err != nil || (item.Log == nil && item.Outcome == nil) {
+			logger.Warn().Err(err).Msgf("removing invalid shipment '%s' from the spool", file)
+			_ = os.Remove(file)
+			continue
+		}
+		// Replayed shipments are retried right away
+		q.getJob(item.jobId()).items = append(q.getJob(item.jobId()).items, item)
+	}
+	if len(files) > 0 {
+		logger.Info().Msgf("Replaying %d shipment(s) from the spool ...", q.count())
+	}
+	q.updateMetrics()
+	return q, nil
+}
+
+// getJob returns the queued shipments of the job, adding it to the queue if it has none, the mutex must be held
+func (q *ShipQueue) getJob(jobId opslevel.ID) *jobShipments {
+	job, ok := q.jobs[jobId]
+	if !ok {
+		job = &jobShipments{backoff: shipQueueMinBackoff}
+		q.jobs[jobId] = job
+	}
+	return job
+}
+
+// count returns the number of queued shipments, the mutex must be held
+func (q *ShipQueue) count() int {
+	count := 0
+	for _, job := range q.jobs {
+		count += len(job.items)
+	}
+	return count
+}
+
+func (q *ShipQueue) RunnerAppendJobLog(input opslevel.RunnerAppendJobLogInput) error {
+	return q.ship(&shipment{Log: &input})
+}
+
+func (q *ShipQueue) RunnerReportJobOutcome(input opslevel.RunnerReportJobOutcomeInput) error {
+	return q.ship(&shipment{Outcome: &input})
+}
+
+// ship sends the shipment right away unless earlier shipments of its job are still queued, it only
+// returns an error when a shipment that failed to send can't be queued
+func (q *ShipQueue) ship(item *shipment) error {
+	item.Queued = time.Now()
+	q.mutex.Lock()
+	_, waiting := q.jobs[item.jobId()]
+	q.mutex.Unlock()
+	if !waiting {
+		err := q.send(item)
+		if err == nil {
+			return nil
+		}
+		if !isRetryableShipError(err) {
+			q.deadLetter(item, err)
+			return nil
+		}
+		q.logger.Warn().Err(err).Msgf("error while shipping to OpsLevel for job '%s', queueing it to retry", item.jobId())
+	}
+	return q.enqueue(item)
+}
+
+func (q *ShipQueue) send(item *shipment) error {
+	if item.Log != nil {
+		if q.config.RunnerId != "" {
+			item.Log.RunnerId = q.config.RunnerId
+		}
+		item.Log.SentAt = opslevel.NewISO8601DateNow()
+		return q.client.RunnerAppendJobLog(*item.Log)
+	}
+	if q.config.RunnerId != "" {
+		item.Outcome.RunnerId = q.config.RunnerId
+	}
+	return q.client.RunnerReportJobOutcome(*item.Outcome)
+}
+
+// enqueue adds the shipment to its job's queue and the spool, a job that wasn't queued yet is retried
+// after the min backoff
+func (q *ShipQueue) enqueue(item *shipment) error {
+	if item.Log != nil {
+		// The processor that shipped the lines reuses their slice
+		item.Log.Logs = slices.Clone(item.Log.Logs)
+	}
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+	if q.config.Directory != "" {
+		q.sequence++
+		item.path = filepath.Join(q.config.Directory, fmt.Sprintf("%020d-%06d%s", item.Queued.UnixNano(), q.sequence, shipmentFileExtension))
+		if err := writeShipment(item); err != nil {
+			return fmt.Errorf("error while spooling shipment for job '%s': %w", item.jobId(), err)
+		}
+	}
+	job := q.getJob(item.jobId())
+	if len(job.items) == 0 {
+		job.retryAt = time.Now().Add(job.backoff)
+	}
+	job.items = append(job.items, item)
+	q.updateMetrics()
+	notify(q.ready)
+	return nil
+}
+
+// deadLetter gives up on a shipment OpsLevel rejected, it's kept in the dead-letter directory of the
+// spool for inspection
+func (q *ShipQueue) deadLetter(item *shipment, err error) {
+	q.logger.Error().Err(err).Msgf("OpsLevel rejected a shipment for job '%s', it won't be retried", item.jobId())
+	if MetricShipDeadLetters != nil {
+		MetricShipDeadLetters.Inc()
+	}
+	if q.config.Directory == "" {
+		return
+	}
+	directory := filepath.Join(q.config.Directory, shipDeadLetterDirectory)
+	name := fmt.Sprintf("%020d%s", item.Queued.UnixNano(), shipmentFileExtension)
+	if item.path != "" {
+		name = filepath.Base(item.path)
+	}
+	rejected := *item
+	rejected.Error = err.Error()
+	rejected.path = filepath.Join(directory, name)
+	err = os.MkdirAll(directory, 0o750)
+	if err == nil {
+		err = writeShipment(&rejected)
+	}
+	if err != nil {
+		q.logger.Warn().Err(err).Msgf("error while writing the rejected shipment for job '%s' to '%s'", item.jobId(), directory)
+	}
+}
+
+// writeShipment writes the shipment to a temporary file first so a crash never leaves half of it behind
+func writeShipment(item *shipment) error {
+	data, err := json.Marshal(item)
+	if err != nil {
+		return err
+	}
+	temporary := strings.TrimSuffix(item.path, shipmentFileExtension) + ".tmp"
+	if err := os.WriteFile(temporary, data, 0o640); err != nil {
+		return err
+	}
+	return os.Rename(temporary, item.path)
+}
+
+// Len returns the number of queued shipments
+func (q *ShipQueue) Len() int {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+	return q.count()
+}
+
+// Run retries the queued shipments until the context is done, backing off for each job while its
+// shipments fail
+func (q *ShipQueue) Run(ctx context.Context) {
+	q.run(ctx, false)
+}
+
+// Drain retries the queued shipments like Run but returns once the queue is empty. Shipments that are
+// still queued when the context is done stay in the spool.
+func (q *ShipQueue) Drain(ctx context.Context) {
+	q.run(ctx, true)
+}
+
+func (q *ShipQueue) run(ctx context.Context, drain bool) {
+	for {
+		wait, empty := q.retry()
+		if empty && drain {
+			return
+		}
+		if empty {
+			wait = shipQueueMetricsInterval
+		}
+		timer := time.NewTimer(min(wait, shipQueueMetricsInterval))
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return
+		case <-q.ready:
+		case <-timer.C:
+		}
+		timer.Stop()
+		q.mutex.Lock()
+		q.updateMetrics()
+		q.mutex.Unlock()
+	}
+}
+
+// retry sends the queued shipments of the jobs that are due and returns how long until the next job is
+// due or whether the queue is empty
+func (q *ShipQueue) retry() (time.Duration, bool) {
+	q.sending.Lock()
+	defer q.sending.Unlock()
+	now := time.Now()
+	q.mutex.Lock()
+	var due []opslevel.ID
+	for jobId, job := range q.jobs {
+		if !job.retryAt.After(now) {
+			due = append(due, jobId)
+		}
+	}
+	q.mutex.Unlock()
+	for _, jobId := range due {
+		q.retryJob(jobId)
+	}
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+	if len(q.jobs) == 0 {
+		return 0, true
+	}
+	var next time.Time
+	for _, job := range q.jobs {
+		if next.IsZero() || job.retryAt.Before(next) {
+			next = job.retryAt
+		}
+	}
+	return max(time.Until(next), 0), false
+}
+
+// retryJob sends the job's queued shipments in order until one fails, which backs off the job
+func (q *ShipQueue) retryJob(jobId opslevel.ID) {
+	for {
+		q.mutex.Lock()
+		job, ok := q.jobs[jobId]
+		if !ok {
+			q.mutex.Unlock()
+			return
+		}
+		item := job.items[0]
+		q.mutex.Unlock()
+		if q.config.MaxAge > 0 && time.Since(item.Queued) > q.config.MaxAge {
+			q.logger.Error().Msgf("dropping shipment for job '%s' queued at %s because it's older than %s", item.jobId(), item.Queued.Format(time.RFC3339), q.config.MaxAge)
+		} else if err := q.send(item); err != nil {
+			if isRetryableShipError(err) {
+				q.logger.Warn().Err(err).Msgf("error while retrying shipment for job '%s'", item.jobId())
+				if MetricShipRetries != nil {
+					MetricShipRetries.Inc()
+				}
+				q.mutex.Lock()
+				job.backoff = min(2*job.backoff, shipQueueMaxBackoff)
+				job.retryAt = time.Now().Add(job.backoff)
+				q.mutex.Unlock()
+				return
+			}
+			q.deadLetter(item, err)
+		}
+		q.remove(jobId, item)
+	}
+}
+
+// remove takes the shipment at the head of the job's queue off the queue and the spool
+func (q *ShipQueue) remove(jobId opslevel.ID, item *shipment) {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+	job := q.jobs[jobId]
+	job.items[0] = nil
+	job.items = job.items[1:]
+	job.backoff = shipQueueMinBackoff
+	if len(job.items) == 0 {
+		delete(q.jobs, jobId)
+	}
+	if item.path != "" {
+		if err := os.Remove(item.path); err != nil && !os.IsNotExist(err) {
+			q.logger.Warn().Err(err).Msgf("error while removing shipment '%s' from the spool", item.path)
+		}
+	}
+	q.updateMetrics()
+}
+
+// updateMetrics reports the depth of the queue and the age of its oldest shipment, the mutex must be held
+func (q *ShipQueue) updateMetrics() {
+	if MetricShipQueueDepth == nil {
+		return
+	}
+	MetricShipQueueDepth.Set(float64(q.count()))
+	age := 0.0
+	for _, job := range q.jobs {
+		age = max(age, time.Since(job.items[0].Queued).Seconds())
+	}
+	MetricShipQueueAge.Set(age)
+}
@@ -0,0 +1,200 @@
+package pkg
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"path/filepath"
+	"slices"
+	"sync"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/opslevel/opslevel-go/v2026"
+	"github.com/rocktavious/autopilot/v2023"
+	"github.com/rs/zerolog"
+)
+
+type fakeShipClient struct {
+	mutex   sync.Mutex
+	failing bool
+	// rejected are the jobs OpsLevel answers with GraphQL errors
+	rejected []opslevel.ID
+	sent     []string
+	runners  []opslevel.ID
+}
+
+func (c *fakeShipClient) record(runnerId opslevel.ID, jobId opslevel.ID, shipped string) error {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if c.failing {
+		return &url.Error{Op: "Post", URL: "https://app.opslevel.com/graphql", Err: syscall.ECONNREFUSED}
+	}
+	if slices.Contains(c.rejected, jobId) {
+		return fmt.Errorf("RunnerJob with id '%s' does not exist", jobId)
+	}
+	c.sent = append(c.sent, shipped)
+	c.runners = append(c.runners, runnerId)
+	return nil
+}
+
+func (c *fakeShipClient) RunnerAppendJobLog(input opslevel.RunnerAppendJobLogInput) error {
+	return c.record(input.RunnerId, input.RunnerJobId, fmt.Sprintf("%s log %v", input.RunnerJobId, input.Logs))
+}
+
+func (c *fakeShipClient) RunnerReportJobOutcome(input opslevel.RunnerReportJobOutcomeInput) error {
+	return c.record(input.RunnerId, input.RunnerJobId, fmt.Sprintf("%s outcome %s", input.RunnerJobId, input.Outcome))
+}
+
+func (c *fakeShipClient) setFailing(failing bool) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.failing = failing
+}
+
+func spooledShipments(t *testing.T, directory string) int {
+	files, err := filepath.Glob(filepath.Join(directory, "*.json"))
+	autopilot.Ok(t, err)
+	return len(files)
+}
+
+func TestShipQueueSendsRightAway(t *testing.T) {
+	// Arrange
+	client := &fakeShipClient{}
+	q, err := NewShipQueue(client, zerolog.Nop(), ShipQueueConfig{})
+	autopilot.Ok(t, err)
+	// Act
+	logErr := q.RunnerAppendJobLog(opslevel.RunnerAppendJobLogInput{RunnerJobId: "1", Logs: []string{"a"}})
+	outcomeErr := q.RunnerReportJobOutcome(opslevel.RunnerReportJobOutcomeInput{RunnerJobId: "1", Outcome: opslevel.RunnerJobOutcomeEnumSuccess})
+	// Assert
+	autopilot.Ok(t, logErr)
+	autopilot.Ok(t, outcomeErr)
+	autopilot.Equals(t, 0, q.Len())
+	autopilot.Equals(t, []string{"1 log [a]", "1 outcome success"}, client.sent)
+}
+
+func TestShipQueueReplaysSpoolInOrder(t *testing.T) {
+	// Arrange
+	directory := t.TempDir()
+	client := &fakeShipClient{failing: true}
+	q, err := NewShipQueue(client, zerolog.Nop(), ShipQueueConfig{Directory: directory})
+	autopilot.Ok(t, err)
+	logs := []string{"a", "b"}
+	autopilot.Ok(t, q.RunnerAppendJobLog(opslevel.RunnerAppendJobLogInput{RunnerJobId: "1", Logs: logs}))
+	logs[0] = "reused"
+	client.failing = false
+	// Act
+	autopilot.Ok(t, q.RunnerReportJobOutcome(opslevel.RunnerReportJobOutcomeInput{RunnerJobId: "1", Outcome: opslevel.RunnerJobOutcomeEnumFailed}))
+	autopilot.Ok(t, q.RunnerAppendJobLog(opslevel.RunnerAppendJobLogInput{RunnerJobId: "2", Logs: []string{"c"}}))
+	spooled := spooledShipments(t, directory)
+	restarted, restartErr := NewShipQueue(client, zerolog.Nop(), ShipQueueConfig{Directory: directory})
+	autopilot.Ok(t, restartErr)
+	replayed := restarted.Len()
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	restarted.Drain(ctx)
+	// Assert
+	autopilot.Equals(t, 2, spooled)
+	autopilot.Equals(t, 2, replayed)
+	autopilot.Equals(t, []string{"2 log [c]", "1 log [a b]", "1 outcome failed"}, client.sent)
+	autopilot.Equals(t, 0, restarted.Len())
+	autopilot.Equals(t, 0, spooledShipments(t, directory))
+}
+
+func TestShipQueueRetriesWithBackoff(t *testing.T) {
+	// Arrange
+	client := &fakeShipClient{failing: true}
+	q, err := NewShipQueue(client, zerolog.Nop(), ShipQueueConfig{})
+	autopilot.Ok(t, err)
+	autopilot.Ok(t, q.RunnerReportJobOutcome(opslevel.RunnerReportJobOutcomeInput{RunnerJobId: "1", Outcome: opslevel.RunnerJobOutcomeEnumSuccess}))
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go q.Run(ctx)
+	// Act
+	time.Sleep(100 * time.Millisecond)
+	client.setFailing(false)
+	deadline := time.Now().Add(5 * time.Second)
+	for q.Len() > 0 && time.Now().Before(deadline) {
+		time.Sleep(50 * time.Millisecond)
+	}
+	// Assert
+	autopilot.Equals(t, 0, q.Len())
+	client.mutex.Lock()
+	defer client.mutex.Unlock()
+	autopilot.Equals(t, []string{"1 outcome success"}, client.sent)
+}
+
+func TestShipQueueDropsExpiredShipments(t *testing.T) {
+	// Arrange
+	client := &fakeShipClient{failing: true}
+	q, err := NewShipQueue(client, zerolog.Nop(), ShipQueueConfig{MaxAge: time.Millisecond})
+	autopilot.Ok(t, err)
+	autopilot.Ok(t, q.RunnerAppendJobLog(opslevel.RunnerAppendJobLogInput{RunnerJobId: "1", Logs: []string{"a"}}))
+	time.Sleep(5 * time.Millisecond)
+	// Act
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	q.Drain(ctx)
+	// Assert
+	autopilot.Equals(t, 0, q.Len())
+	autopilot.Equals(t, 0, len(client.sent))
+}
+
+func TestShipQueueFailingJobDoesNotHoldUpOthers(t *testing.T) {
+	// Arrange
+	client := &fakeShipClient{failing: true}
+	q, err := NewShipQueue(client, zerolog.Nop(), ShipQueueConfig{})
+	autopilot.Ok(t, err)
+	autopilot.Ok(t, q.RunnerAppendJobLog(opslevel.RunnerAppendJobLogInput{RunnerJobId: "1", Logs: []string{"a"}}))
+	client.setFailing(false)
+	// Act
+	autopilot.Ok(t, q.RunnerAppendJobLog(opslevel.RunnerAppendJobLogInput{RunnerJobId: "2", Logs: []string{"b"}}))
+	autopilot.Ok(t, q.RunnerAppendJobLog(opslevel.RunnerAppendJobLogInput{RunnerJobId: "1", Logs: []string{"c"}}))
+	queued := q.Len()
+	// Assert
+	autopilot.Equals(t, 2, queued)
+	autopilot.Equals(t, []string{"2 log [b]"}, client.sent)
+}
+
+func TestShipQueueDeadLettersRejectedShipments(t *testing.T) {
+	// Arrange
+	directory := t.TempDir()
+	client := &fakeShipClient{failing: true}
+	q, err := NewShipQueue(client, zerolog.Nop(), ShipQueueConfig{Directory: directory})
+	autopilot.Ok(t, err)
+	autopilot.Ok(t, q.RunnerAppendJobLog(opslevel.RunnerAppendJobLogInput{RunnerJobId: "1", Logs: []string{"a"}}))
+	autopilot.Ok(t, q.RunnerReportJobOutcome(opslevel.RunnerReportJobOutcomeInput{RunnerJobId: "2", Outcome: opslevel.RunnerJobOutcomeEnumSuccess}))
+	client.setFailing(false)
+	client.rejected = []opslevel.ID{"1"}
+	// Act
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	q.Drain(ctx)
+	// Assert
+	autopilot.Ok(t, ctx.Err())
+	autopilot.Equals(t, 0, q.Len())
+	autopilot.Equals(t, []string{"2 outcome success"}, client.sent)
+	autopilot.Equals(t, 0, spooledShipments(t, directory))
+	autopilot.Equals(t, 1, spooledShipments(t, filepath.Join(directory, shipDeadLetterDirectory)))
+}
+
+func TestShipQueueReplaysWithRegisteredRunnerId(t *testing.T) {
+	// Arrange
+	directory := t.TempDir()
+	client := &fakeShipClient{failing: true}
+	q, err := NewShipQueue(client, zerolog.Nop(), ShipQueueConfig{Directory: directory, RunnerId: "old"})
+	autopilot.Ok(t, err)
+	autopilot.Ok(t, q.RunnerAppendJobLog(opslevel.RunnerAppendJobLogInput{RunnerId: "old", RunnerJobId: "1", Logs: []string{"a"}}))
+	autopilot.Ok(t, q.RunnerReportJobOutcome(opslevel.RunnerReportJobOutcomeInput{RunnerId: "old", RunnerJobId: "1", Outcome: opslevel.RunnerJobOutcomeEnumSuccess}))
+	client.setFailing(false)
+	restarted, restartErr := NewShipQueue(client, zerolog.Nop(), ShipQueueConfig{Directory: directory, RunnerId: "new"})
+	autopilot.Ok(t, restartErr)
+	// Act
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	restarted.Drain(ctx)
+	// Assert
+	autopilot.Equals(t, []string{"1 log [a]", "1 outcome success"}, client.sent)
+	autopilot.Equals(t, []opslevel.ID{"new", "new"}, client.runners)
+}